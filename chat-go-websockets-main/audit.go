@@ -0,0 +1,215 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Tipos de entrada del log de auditoría
+const (
+	AuditModerationResult = "moderation_result"
+	AuditStrategyChange   = "strategy_change"
+	AuditModeratorAction  = "moderator_action"
+	AuditSanction         = "sanction"
+	AuditReport           = "report"
+)
+
+// AuditEntry es un registro inmutable del log de auditoría
+type AuditEntry struct {
+	Timestamp    time.Time              `json:"timestamp"`
+	Kind         string                 `json:"kind"`
+	Actor        string                 `json:"actor"`  // moderador, "system" o "admin"
+	Action       string                 `json:"action"` // acción de moderación o del moderador
+	Target       string                 `json:"target,omitempty"`
+	Username     string                 `json:"username,omitempty"`
+	Room         string                 `json:"room,omitempty"`
+	MessageID    string                 `json:"message_id,omitempty"`
+	Strategy     string                 `json:"strategy,omitempty"`
+	MatchedTerms []string               `json:"matched_terms,omitempty"`
+	Reason       string                 `json:"reason,omitempty"`
+	Data         map[string]interface{} `json:"data,omitempty"`
+}
+
+// AuditFilter selecciona entradas del log. Los campos vacíos no filtran.
+type AuditFilter struct {
+	User     string
+	Action   string
+	Strategy string
+	Kind     string
+	Since    time.Time
+	Until    time.Time
+	Limit    int // cantidad máxima de entradas (las más recientes); 0 = sin límite
+}
+
+func (f AuditFilter) matches(entry AuditEntry) bool {
+	if f.User != "" {
+		user := normalizeUsername(f.User)
+		if normalizeUsername(entry.Username) != user && normalizeUsername(entry.Target) != user && normalizeUsername(entry.Actor) != user {
+			return false
+		}
+	}
+	if f.Action != "" && entry.Action != f.Action {
+		return false
+	}
+	if f.Strategy != "" && !strings.EqualFold(entry.Strategy, f.Strategy) {
+		return false
+	}
+	if f.Kind != "" && entry.Kind != f.Kind {
+		return false
+	}
+	if !f.Since.IsZero() && entry.Timestamp.Before(f.Since) {
+		return false
+	}
+	if !f.Until.IsZero() && entry.Timestamp.After(f.Until) {
+		return false
+	}
+	return true
+}
+
+// AuditLog guarda las entradas en un archivo JSON Lines de solo escritura al final
+type AuditLog struct {
+	path  string
+	mutex sync.Mutex
+}
+
+func NewAuditLog(path string) *AuditLog {
+	return &AuditLog{path: path}
+}
+
+// Record agrega una entrada al final del log
+func (al *AuditLog) Record(entry AuditEntry) {
+	if entry.Timestamp.IsZero() {
+		entry.Timestamp = time.Now()
+	}
+
+	line, err := json.Marshal(entry)
+	if err != nil {
+		componentLog("audit").Error("could not marshal entry", "error", err)
+		return
+	}
+
+	al.mutex.Lock()
+	defer al.mutex.Unlock()
+
+	if err := os.MkdirAll(filepath.Dir(al.path), 0o755); err != nil {
+		componentLog("audit").Error("could not create directory", "path", al.path, "error", err)
+		return
+	}
+	file, err := os.OpenFile(al.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		componentLog("audit").Error("could not open audit log", "path", al.path, "error", err)
+		return
+	}
+	defer file.Close()
+
+	file.Write(append(line, '\n'))
+}
+
+// RecordModeration registra una decisión de moderación distinta de "allow"
+func (al *AuditLog) RecordModeration(username, room, messageID string, result ModerationResult) {
+	if result.Action == "allow" {
+		return
+	}
+	al.Record(AuditEntry{
+		Timestamp:    result.Timestamp,
+		Kind:         AuditModerationResult,
+		Actor:        "system",
+		Action:       result.Action,
+		Username:     username,
+		Room:         room,
+		MessageID:    messageID,
+		Strategy:     result.StrategyUsed,
+		MatchedTerms: result.MatchedTerms,
+		Reason:       result.Reason,
+		Data: map[string]interface{}{
+			"original_message": result.OriginalMessage,
+			"modified_message": result.ModifiedMessage,
+			"confidence":       result.Confidence,
+		},
+	})
+}
+
+// RecordSanction registra una sanción aplicada automáticamente
+func (al *AuditLog) RecordSanction(sanction Sanction, room, messageID, strategy string) {
+	al.Record(AuditEntry{
+		Kind:      AuditSanction,
+		Actor:     "system",
+		Action:    string(sanction.Type),
+		Target:    sanction.Username,
+		Username:  sanction.Username,
+		Room:      room,
+		MessageID: messageID,
+		Strategy:  strategy,
+		Reason:    sanction.Reason,
+	})
+}
+
+// Query devuelve las entradas que cumplen el filtro, en orden cronológico
+func (al *AuditLog) Query(filter AuditFilter) ([]AuditEntry, error) {
+	result := []AuditEntry{}
+	err := al.scan(filter, func(entry AuditEntry, _ []byte) error {
+		result = append(result, entry)
+		if filter.Limit > 0 && len(result) > filter.Limit {
+			result = result[1:]
+		}
+		return nil
+	})
+	return result, err
+}
+
+// Export escribe en formato JSON Lines las entradas que cumplen el filtro
+func (al *AuditLog) Export(w io.Writer, filter AuditFilter) error {
+	return al.scan(filter, func(_ AuditEntry, line []byte) error {
+		if _, err := w.Write(line); err != nil {
+			return err
+		}
+		_, err := w.Write([]byte{'\n'})
+		return err
+	})
+}
+
+// maxAuditLineBytes es el tamaño máximo de una entrada; las líneas más largas
+// se saltean al leer
+const maxAuditLineBytes = 1 << 20
+
+// scan recorre el archivo llamando a fn por cada entrada que cumple el filtro.
+// No toma el mutex: las escrituras son líneas completas al final del archivo y
+// una línea a medio escribir se descarta como corrupta.
+func (al *AuditLog) scan(filter AuditFilter, fn func(entry AuditEntry, line []byte) error) error {
+	file, err := os.Open(al.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	reader := bufio.NewReaderSize(file, 64*1024)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if len(line) > 0 && len(line) <= maxAuditLineBytes {
+			line = bytes.TrimSuffix(line, []byte{'\n'})
+			var entry AuditEntry
+			// Una línea corrupta (por ejemplo tras un corte de energía) se saltea
+			if json.Unmarshal(line, &entry) == nil && filter.matches(entry) {
+				if err := fn(entry, line); err != nil {
+					return err
+				}
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,23 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestAuditQuerySkipsOversizedLines(t *testing.T) {
+	audit := NewAuditLog(filepath.Join(t.TempDir(), "audit.jsonl"))
+	audit.Record(AuditEntry{Kind: AuditModeratorAction, Action: "mute", Username: "ana"})
+	// Una entrada más larga que el máximo no corta la lectura de las siguientes
+	audit.Record(AuditEntry{Kind: AuditModeratorAction, Action: "ban", Reason: strings.Repeat("x", maxAuditLineBytes)})
+	audit.Record(AuditEntry{Kind: AuditModeratorAction, Action: "kick", Username: "beto"})
+
+	entries, err := audit.Query(AuditFilter{})
+	if err != nil {
+		t.Fatalf("Query: %v", err)
+	}
+	if len(entries) != 2 || entries[0].Action != "mute" || entries[1].Action != "kick" {
+		t.Fatalf("entradas inesperadas: %+v", entries)
+	}
+}
@@ -0,0 +1,336 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// BackpressurePolicy decide qué hacer con un evento cuando la cola de un
+// suscriptor está llena
+type BackpressurePolicy string
+
+const (
+	DropOldest       BackpressurePolicy = "drop_oldest" // descartar el evento más viejo de la cola
+	DropNewest       BackpressurePolicy = "drop_newest" // descartar el evento nuevo
+	Coalesce         BackpressurePolicy = "coalesce"    // reemplazar un evento encolado del mismo tipo, sala y usuario
+	BlockWithTimeout BackpressurePolicy = "block"       // esperar lugar hasta block_timeout y luego descartar
+	DisconnectSlow   BackpressurePolicy = "disconnect"  // desconectar al cliente lento
+)
+
+// validBackpressurePolicies son las políticas aceptadas en la configuración
+var validBackpressurePolicies = map[BackpressurePolicy]bool{
+	DropOldest: true, DropNewest: true, Coalesce: true, BlockWithTimeout: true, DisconnectSlow: true,
+}
+
+// BackpressureConfig configura la cola de un suscriptor
+type BackpressureConfig struct {
+	Policy       BackpressurePolicy `json:"policy"`
+	QueueSize    int                `json:"queue_size"`
+	BlockTimeout Duration           `json:"block_timeout"` // solo para la política block
+}
+
+// withDefaults completa los valores faltantes o inválidos
+func (bc BackpressureConfig) withDefaults(fallback BackpressureConfig) BackpressureConfig {
+	if !validBackpressurePolicies[bc.Policy] {
+		bc.Policy = fallback.Policy
+	}
+	if bc.QueueSize <= 0 {
+		bc.QueueSize = fallback.QueueSize
+	}
+	if bc.BlockTimeout <= 0 {
+		bc.BlockTimeout = fallback.BlockTimeout
+	}
+	return bc
+}
+
+// BackpressureSettings agrupa la configuración de todos los suscriptores
+type BackpressureSettings struct {
+	Connections       BackpressureConfig `json:"connections"`         // conexiones de usuarios
+	Moderators        BackpressureConfig `json:"moderators"`          // conexiones de moderadores
+	Publisher         BackpressureConfig `json:"publisher"`           // cola de entrada del publisher
+	AllowClientPolicy bool               `json:"allow_client_policy"` // permitir ?backpressure=... al conectarse
+}
+
+func DefaultBackpressureSettings() BackpressureSettings {
+	return BackpressureSettings{
+		Connections: BackpressureConfig{
+			Policy:       DropOldest,
+			QueueSize:    100,
+			BlockTimeout: Duration(100 * time.Millisecond),
+		},
+		Moderators: BackpressureConfig{
+			Policy:       BlockWithTimeout,
+			QueueSize:    500,
+			BlockTimeout: Duration(time.Second),
+		},
+		Publisher: BackpressureConfig{
+			Policy:       BlockWithTimeout,
+			QueueSize:    1000,
+			BlockTimeout: Duration(time.Second),
+		},
+		AllowClientPolicy: true,
+	}
+}
+
+// LoadBackpressureSettings carga BACKPRESSURE_CONFIG_FILE o usa la configuración por defecto
+func LoadBackpressureSettings() BackpressureSettings {
+	defaults := DefaultBackpressureSettings()
+	settings := defaults
+
+	path := os.Getenv("BACKPRESSURE_CONFIG_FILE")
+	if path == "" {
+		return settings
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		slog.Warn("could not read backpressure config", "path", path, "error", err)
+		return settings
+	}
+	if err := json.Unmarshal(data, &settings); err != nil {
+		slog.Warn("invalid backpressure config", "path", path, "error", err)
+		return defaults
+	}
+	settings.Connections = settings.Connections.withDefaults(defaults.Connections)
+	settings.Moderators = settings.Moderators.withDefaults(defaults.Moderators)
+	settings.Publisher = settings.Publisher.withDefaults(defaults.Publisher)
+	return settings
+}
+
+// ForConnection elige la configuración de una conexión nueva. requested es la
+// política pedida por el cliente, si se permite.
+func (bs BackpressureSettings) ForConnection(moderator bool, requested string) BackpressureConfig {
+	config := bs.Connections
+	if moderator {
+		config = bs.Moderators
+	}
+	if requested != "" && bs.AllowClientPolicy && validBackpressurePolicies[BackpressurePolicy(requested)] {
+		config.Policy = BackpressurePolicy(requested)
+	}
+	return config
+}
+
+// eventQueue es la cola acotada de eventos de un suscriptor
+type eventQueue struct {
+	config  BackpressureConfig
+	events  []Event
+	ready   chan struct{} // avisa que hay eventos
+	space   chan struct{} // avisa que se liberó lugar
+	done    chan struct{}
+	closed  bool
+	dropped int64 // eventos perdidos en total
+	missed  int64 // eventos perdidos desde el último aviso al cliente
+	resync  int64 // offset del primer evento perdido desde el último aviso (0 sin log)
+	merged  int64 // eventos reemplazados por coalesce
+	mutex   sync.Mutex
+}
+
+func newEventQueue(config BackpressureConfig) *eventQueue {
+	return &eventQueue{
+		config: config,
+		events: make([]Event, 0, config.QueueSize),
+		ready:  make(chan struct{}, 1),
+		space:  make(chan struct{}, 1),
+		done:   make(chan struct{}),
+	}
+}
+
+func signal(ch chan struct{}) {
+	select {
+	case ch <- struct{}{}:
+	default:
+	}
+}
+
+// Push encola un evento aplicando la política. Devuelve false si la política
+// es disconnect y el suscriptor debe desconectarse.
+func (q *eventQueue) Push(event Event) bool {
+	q.mutex.Lock()
+	if q.closed {
+		q.mutex.Unlock()
+		return true
+	}
+
+	if len(q.events) >= q.config.QueueSize && q.config.Policy == BlockWithTimeout {
+		if !q.waitForSpace() {
+			q.dropped++
+			q.miss(event)
+			q.mutex.Unlock()
+			return true
+		}
+	}
+
+	if len(q.events) < q.config.QueueSize {
+		q.events = append(q.events, event)
+		if len(q.events) < q.config.QueueSize {
+			// Si queda lugar, despertar a otro que esté esperando
+			signal(q.space)
+		}
+		q.mutex.Unlock()
+		signal(q.ready)
+		return true
+	}
+
+	defer q.mutex.Unlock()
+	switch q.config.Policy {
+	case DisconnectSlow:
+		q.dropped++
+		return false
+	case DropNewest:
+		q.dropped++
+		q.miss(event)
+	case Coalesce:
+		if i := q.coalesceIndex(event); i >= 0 {
+			q.miss(q.events[i])
+			q.events[i] = event
+			q.merged++
+			return true
+		}
+		fallthrough
+	default: // DropOldest
+		q.miss(q.events[0])
+		q.events = append(q.events[1:], event)
+		q.dropped++
+	}
+	return true
+}
+
+// miss cuenta un evento perdido y recuerda desde qué offset hay que releer
+func (q *eventQueue) miss(event Event) {
+	q.missed++
+	if event.Offset > 0 && (q.resync == 0 || event.Offset < q.resync) {
+		q.resync = event.Offset
+	}
+}
+
+// waitForSpace espera lugar en la cola hasta block_timeout. Se llama con el
+// lock tomado y vuelve con el lock tomado.
+func (q *eventQueue) waitForSpace() bool {
+	timer := time.NewTimer(time.Duration(q.config.BlockTimeout))
+	defer timer.Stop()
+	for len(q.events) >= q.config.QueueSize && !q.closed {
+		q.mutex.Unlock()
+		select {
+		case <-q.space:
+		case <-q.done:
+		case <-timer.C:
+			q.mutex.Lock()
+			return len(q.events) < q.config.QueueSize
+		}
+		q.mutex.Lock()
+	}
+	return !q.closed
+}
+
+// coalesceIndex busca el último evento encolado que el nuevo puede reemplazar
+func (q *eventQueue) coalesceIndex(event Event) int {
+	for i := len(q.events) - 1; i >= 0; i-- {
+		queued := q.events[i]
+		if queued.Type == event.Type && queued.Room == event.Room && queued.Username == event.Username {
+			return i
+		}
+	}
+	return -1
+}
+
+// Pop saca el próximo evento, si hay
+func (q *eventQueue) Pop() (Event, bool) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	if len(q.events) == 0 {
+		return Event{}, false
+	}
+	event := q.events[0]
+	q.events[0] = Event{}
+	q.events = q.events[1:]
+	signal(q.space)
+	return event, true
+}
+
+// TakeMissed devuelve los eventos perdidos desde la última llamada y el
+// offset del primero de ellos
+func (q *eventQueue) TakeMissed() (int64, int64) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	missed, resync := q.missed, q.resync
+	q.missed, q.resync = 0, 0
+	return missed, resync
+}
+
+// Close libera a los que esperan lugar y descarta los eventos nuevos
+func (q *eventQueue) Close() {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	if q.closed {
+		return
+	}
+	q.closed = true
+	q.events = nil
+	close(q.done)
+}
+
+// GetStats retorna el estado de la cola
+func (q *eventQueue) GetStats() map[string]interface{} {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	return map[string]interface{}{
+		"policy":     q.config.Policy,
+		"queue_size": q.config.QueueSize,
+		"queued":     len(q.events),
+		"dropped":    q.dropped,
+		"coalesced":  q.merged,
+	}
+}
+
+// missedEventsNotice es el aviso que recibe un cliente que perdió eventos,
+// para que vuelva a pedir el historial. Con el log de eventos activo incluye
+// resync_from, el offset desde el cual pedir GET /events.
+func missedEventsNotice(missed, resyncFrom int64) Event {
+	data := map[string]interface{}{
+		"missed_events": missed,
+		"resync":        true,
+	}
+	if resyncFrom > 0 {
+		data["resync_from"] = resyncFrom
+	}
+	return Event{
+		Type:      SystemEvent,
+		Message:   fmt.Sprintf("Te perdiste %d eventos porque la conexión iba lenta; recarga el historial", missed),
+		Data:      data,
+		Timestamp: time.Now(),
+	}
+}
+
+// handleConnections lista las conexiones activas con el estado de su cola
+func (s *Server) handleConnections(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Método no permitido", http.StatusMethodNotAllowed)
+		return
+	}
+
+	s.mutex.RLock()
+	connections := make([]map[string]interface{}, 0, len(s.observerMap))
+	for _, observer := range s.observerMap {
+		stats := observer.GetStats()
+		stats["id"] = observer.GetID()
+		stats["username"] = observer.GetUsername()
+		stats["room"] = observer.GetRoom()
+		connections = append(connections, stats)
+	}
+	s.mutex.RUnlock()
+	sort.Slice(connections, func(i, j int) bool {
+		return connections[i]["dropped"].(int64) > connections[j]["dropped"].(int64)
+	})
+
+	writeJSON(w, map[string]interface{}{
+		"publisher":   s.publisher.GetStats(),
+		"broker":      s.publisher.Broker().GetStats(),
+		"observers":   ObserverMetricsStats(),
+		"connections": connections,
+	})
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+func TestRoomChannel(t *testing.T) {
+	config := DefaultBrokerConfig()
+	config.Shards = 4
+
+	if channel := roomChannel(config, ""); channel != "go-chat:events:all" {
+		t.Errorf("los eventos sin sala van a %q, se esperaba go-chat:events:all", channel)
+	}
+
+	channels := map[string]bool{}
+	for _, channel := range brokerChannels(config) {
+		channels[channel] = true
+	}
+	if len(channels) != config.Shards+1 {
+		t.Fatalf("brokerChannels devolvió %d canales, se esperaban %d", len(channels), config.Shards+1)
+	}
+
+	used := map[string]bool{}
+	for i := 0; i < 20; i++ {
+		room := fmt.Sprintf("sala-%d", i)
+		channel := roomChannel(config, room)
+		if channel != roomChannel(config, room) {
+			t.Fatalf("la sala %s no siempre va al mismo canal", room)
+		}
+		if !channels[channel] {
+			t.Fatalf("la sala %s va a %q, que ninguna instancia escucha", room, channel)
+		}
+		used[channel] = true
+	}
+	if len(used) < 2 {
+		t.Errorf("20 salas quedaron en %d canal(es); deberían repartirse entre los shards", len(used))
+	}
+}
+
+// newTestRedisBroker conecta un broker al Redis de prueba y junta lo que recibe
+func newTestRedisBroker(t *testing.T, server *miniredis.Miniredis, nodeID string) (*RedisBroker, chan Event) {
+	t.Helper()
+	config := DefaultBrokerConfig()
+	config.Type = RedisBrokerType
+	config.URL = "redis://" + server.Addr()
+	config.NodeID = nodeID
+	config.Shards = 4
+
+	broker, err := NewRedisBroker(config)
+	if err != nil {
+		t.Fatalf("NewRedisBroker: %v", err)
+	}
+	t.Cleanup(func() { broker.Close() })
+
+	received := make(chan Event, 10)
+	if err := broker.Subscribe(func(event Event) { received <- event }); err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+	return broker, received
+}
+
+func expectEvent(t *testing.T, events chan Event, message string) Event {
+	t.Helper()
+	select {
+	case event := <-events:
+		if event.Message != message {
+			t.Fatalf("llegó %q, se esperaba %q", event.Message, message)
+		}
+		return event
+	case <-time.After(2 * time.Second):
+		t.Fatalf("no llegó el evento %q", message)
+	}
+	return Event{}
+}
+
+func expectNoEvent(t *testing.T, events chan Event) {
+	t.Helper()
+	select {
+	case event := <-events:
+		t.Fatalf("llegó un evento inesperado: %+v", event)
+	case <-time.After(200 * time.Millisecond):
+	}
+}
+
+func TestRedisBrokerSuppressesOwnEvents(t *testing.T) {
+	server := miniredis.RunT(t)
+	first, firstEvents := newTestRedisBroker(t, server, "nodo-a")
+	second, secondEvents := newTestRedisBroker(t, server, "nodo-b")
+
+	if err := first.Publish(Event{Type: MessageEvent, Room: "general", Message: "desde a"}); err != nil {
+		t.Fatalf("Publish: %v", err)
+	}
+	expectEvent(t, secondEvents, "desde a")
+	expectNoEvent(t, firstEvents)
+
+	second.Publish(Event{Type: SystemEvent, Message: "aviso desde b"})
+	expectEvent(t, firstEvents, "aviso desde b")
+	expectNoEvent(t, secondEvents)
+
+	// Cada instancia cuenta como ignorado el eco de su propio evento
+	for _, broker := range []*RedisBroker{first, second} {
+		stats := broker.GetStats()
+		if stats["published"] != int64(1) || stats["received"] != int64(1) || stats["ignored"] != int64(1) {
+			t.Errorf("%s: estadísticas inesperadas %v", broker.NodeID(), stats)
+		}
+	}
+}
+
+func TestRedisBrokerRoutesRoomsToShards(t *testing.T) {
+	server := miniredis.RunT(t)
+	broker, _ := newTestRedisBroker(t, server, "nodo-a")
+
+	// Buscar dos salas que caigan en shards distintos
+	room, other := "general", ""
+	for i := 0; other == ""; i++ {
+		candidate := fmt.Sprintf("sala-%d", i)
+		if roomChannel(broker.config, candidate) != roomChannel(broker.config, room) {
+			other = candidate
+		}
+	}
+
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	t.Cleanup(func() { client.Close() })
+	ctx := context.Background()
+	pubsub := client.Subscribe(ctx, roomChannel(broker.config, room))
+	t.Cleanup(func() { pubsub.Close() })
+	if _, err := pubsub.Receive(ctx); err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+
+	var published int64
+	for _, event := range []Event{
+		{Type: MessageEvent, Room: other, Message: "otra sala"},
+		{Type: MessageEvent, Room: room, Message: "uno"},
+		{Type: MessageEvent, Room: room, Message: "dos"},
+	} {
+		broker.Publish(event)
+		published++
+	}
+
+	// Solo llegan los eventos de la sala, en orden y con el nodo de origen
+	for _, expected := range []string{"uno", "dos"} {
+		select {
+		case msg := <-pubsub.Channel():
+			var message brokerMessage
+			if err := json.Unmarshal([]byte(msg.Payload), &message); err != nil {
+				t.Fatalf("mensaje inválido en %s: %v", msg.Channel, err)
+			}
+			if message.Event.Message != expected || message.Node != "nodo-a" {
+				t.Fatalf("llegó %+v a %s, se esperaba %q de nodo-a", message, msg.Channel, expected)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("no llegó %q al canal de la sala", expected)
+		}
+	}
+
+	// El evento de la otra sala también se publicó, en su propio canal
+	deadline := time.Now().Add(2 * time.Second)
+	for atomic.LoadInt64(&broker.published) < published && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if got := atomic.LoadInt64(&broker.published); got != published {
+		t.Errorf("published = %d, se esperaban %d", got, published)
+	}
+}
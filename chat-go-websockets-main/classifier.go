@@ -0,0 +1,344 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"math"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// ClassifierModel es un clasificador naive Bayes multinomial sobre n-gramas de
+// caracteres. Se entrena con "go-chat train-classifier" y se guarda como JSON.
+type ClassifierModel struct {
+	NgramMin      int                       `json:"ngram_min"`
+	NgramMax      int                       `json:"ngram_max"`
+	Alpha         float64                   `json:"alpha"`       // suavizado de Laplace
+	Temperature   float64                   `json:"temperature"` // calibración de las probabilidades
+	Labels        []string                  `json:"labels"`
+	DocCounts     map[string]int            `json:"doc_counts"`
+	FeatureCounts map[string]map[string]int `json:"feature_counts"`
+	TotalFeatures map[string]int            `json:"total_features"`
+	Vocabulary    int                       `json:"vocabulary"`
+	TrainedAt     time.Time                 `json:"trained_at"`
+}
+
+// ngrams extrae los n-gramas de caracteres del texto normalizado
+func (m *ClassifierModel) ngrams(text string) []string {
+	runes := []rune(" " + strings.Join(strings.Fields(strings.ToLower(text)), " ") + " ")
+	grams := []string{}
+	for n := m.NgramMin; n <= m.NgramMax; n++ {
+		for i := 0; i+n <= len(runes); i++ {
+			grams = append(grams, string(runes[i:i+n]))
+		}
+	}
+	return grams
+}
+
+// TrainClassifier entrena un modelo con las muestras etiquetadas
+func TrainClassifier(samples []EvalSample, ngramMin, ngramMax int, alpha float64) *ClassifierModel {
+	model := &ClassifierModel{
+		NgramMin:      ngramMin,
+		NgramMax:      ngramMax,
+		Alpha:         alpha,
+		Temperature:   1,
+		DocCounts:     make(map[string]int),
+		FeatureCounts: make(map[string]map[string]int),
+		TotalFeatures: make(map[string]int),
+		TrainedAt:     time.Now(),
+	}
+
+	vocabulary := map[string]bool{}
+	for _, sample := range samples {
+		label := sample.Expected
+		if model.FeatureCounts[label] == nil {
+			model.FeatureCounts[label] = make(map[string]int)
+			model.Labels = append(model.Labels, label)
+		}
+		model.DocCounts[label]++
+		for _, gram := range model.ngrams(sample.Message) {
+			model.FeatureCounts[label][gram]++
+			model.TotalFeatures[label]++
+			vocabulary[gram] = true
+		}
+	}
+	sort.Strings(model.Labels)
+	model.Vocabulary = len(vocabulary)
+	return model
+}
+
+// Predict devuelve la probabilidad de cada etiqueta para el texto
+func (m *ClassifierModel) Predict(text string) map[string]float64 {
+	totalDocs := 0
+	for _, count := range m.DocCounts {
+		totalDocs += count
+	}
+
+	grams := m.ngrams(text)
+	scores := make(map[string]float64, len(m.Labels))
+	best := math.Inf(-1)
+	for _, label := range m.Labels {
+		score := math.Log(float64(m.DocCounts[label]) / float64(totalDocs))
+		denominator := float64(m.TotalFeatures[label]) + m.Alpha*float64(m.Vocabulary+1)
+		for _, gram := range grams {
+			score += math.Log((float64(m.FeatureCounts[label][gram]) + m.Alpha) / denominator)
+		}
+		// Normalizar por la cantidad de n-gramas para que los mensajes largos
+		// no produzcan probabilidades extremas, y aplicar la temperatura calibrada
+		if len(grams) > 0 {
+			score /= float64(len(grams))
+		}
+		score /= m.Temperature
+		scores[label] = score
+		best = math.Max(best, score)
+	}
+
+	// Softmax estable
+	sum := 0.0
+	for label, score := range scores {
+		scores[label] = math.Exp(score - best)
+		sum += scores[label]
+	}
+	for label := range scores {
+		scores[label] /= sum
+	}
+	return scores
+}
+
+// Calibrate ajusta la temperatura que minimiza la log-loss sobre las muestras
+func (m *ClassifierModel) Calibrate(samples []EvalSample) float64 {
+	bestTemperature, bestLoss := 1.0, math.Inf(1)
+	for temperature := 0.002; temperature <= 2; temperature *= 1.15 {
+		m.Temperature = temperature
+		loss := 0.0
+		for _, sample := range samples {
+			probability := m.Predict(sample.Message)[sample.Expected]
+			loss -= math.Log(math.Max(probability, 1e-12))
+		}
+		if loss < bestLoss {
+			bestTemperature, bestLoss = temperature, loss
+		}
+	}
+	m.Temperature = bestTemperature
+	return bestLoss / float64(len(samples))
+}
+
+// LoadClassifierModel lee un modelo entrenado
+func LoadClassifierModel(path string) (*ClassifierModel, error) {
+	if _, err := os.Stat(path); err != nil {
+		return nil, err
+	}
+	model := &ClassifierModel{}
+	if err := readJSONFile(path, model); err != nil {
+		return nil, err
+	}
+	if len(model.Labels) == 0 {
+		return nil, errors.New("el modelo no tiene etiquetas: " + path)
+	}
+	if model.Temperature <= 0 {
+		model.Temperature = 1
+	}
+	return model, nil
+}
+
+// ClassifierStrategy modera usando el clasificador estadístico local
+type ClassifierStrategy struct {
+	model      *ClassifierModel
+	thresholds map[string]float64 // probabilidad mínima para aplicar cada acción
+}
+
+// DefaultClassifierThresholds son los umbrales por acción si no se configuran otros
+func DefaultClassifierThresholds() map[string]float64 {
+	return map[string]float64{
+		"block":  0.85,
+		"modify": 0.7,
+		"warn":   0.6,
+	}
+}
+
+// ParseClassifierThresholds interpreta "block=0.9,modify=0.7,warn=0.5"
+func ParseClassifierThresholds(value string) (map[string]float64, error) {
+	thresholds := DefaultClassifierThresholds()
+	if value == "" {
+		return thresholds, nil
+	}
+	for _, pair := range strings.Split(value, ",") {
+		parts := strings.SplitN(strings.TrimSpace(pair), "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("umbral inválido: %q", pair)
+		}
+		threshold, err := strconv.ParseFloat(parts[1], 64)
+		if err != nil {
+			return nil, fmt.Errorf("umbral inválido para %s: %v", parts[0], err)
+		}
+		thresholds[strings.ToLower(parts[0])] = threshold
+	}
+	return thresholds, nil
+}
+
+func NewClassifierStrategy(model *ClassifierModel, thresholds map[string]float64) *ClassifierStrategy {
+	return &ClassifierStrategy{
+		model:      model,
+		thresholds: thresholds,
+	}
+}
+
+// classifierSeverity es el orden en que se evalúan las acciones
+var classifierSeverity = []string{"block", "modify", "warn"}
+
+var wordPattern = regexp.MustCompile(`[\p{L}\p{N}']+`)
+
+func (cs *ClassifierStrategy) Moderate(message string) ModerationResult {
+	probabilities := cs.model.Predict(message)
+
+	for _, action := range classifierSeverity {
+		threshold, configured := cs.thresholds[action]
+		probability, known := probabilities[action]
+		if !configured || !known || probability < threshold {
+			continue
+		}
+
+		result := ModerationResult{
+			OriginalMessage: message,
+			ModifiedMessage: message,
+			Action:          action,
+			Reason:          fmt.Sprintf("Classifier predicted %s with probability %.2f", action, probability),
+			Confidence:      probability,
+			Timestamp:       time.Now(),
+			StrategyUsed:    cs.GetName(),
+		}
+		switch action {
+		case "block":
+			result.ModifiedMessage = ""
+		case "modify":
+			result.ModifiedMessage, result.MatchedTerms = cs.maskToxicWords(message)
+		}
+		return result
+	}
+
+	return ModerationResult{
+		OriginalMessage: message,
+		ModifiedMessage: message,
+		Action:          "allow",
+		Reason:          "Classifier found no inappropriate content",
+		Confidence:      probabilities["allow"],
+		Timestamp:       time.Now(),
+		StrategyUsed:    cs.GetName(),
+	}
+}
+
+// maskToxicWords reemplaza las palabras que por sí solas también superan el umbral de "modify"
+func (cs *ClassifierStrategy) maskToxicWords(message string) (string, []string) {
+	masked := []string{}
+	modified := wordPattern.ReplaceAllStringFunc(message, func(word string) string {
+		if !unicode.IsLetter([]rune(word)[0]) {
+			return word
+		}
+		if cs.model.Predict(word)["modify"] >= cs.thresholds["modify"] {
+			masked = append(masked, word)
+			return "***"
+		}
+		return word
+	})
+	return modified, masked
+}
+
+func (cs *ClassifierStrategy) GetName() string {
+	return "Classifier"
+}
+
+// registerClassifierStrategy carga el modelo de CLASSIFIER_MODEL (por defecto
+// data/classifier.json) y, si existe, registra la estrategia "classifier"
+func registerClassifierStrategy() {
+	path := os.Getenv("CLASSIFIER_MODEL")
+	if path == "" {
+		path = dataPath("classifier.json")
+	}
+	model, err := LoadClassifierModel(path)
+	if os.IsNotExist(err) {
+		return
+	}
+	if err != nil {
+		componentLog("classifier").Warn("could not load classifier model", "path", path, "error", err)
+		return
+	}
+
+	thresholds, err := ParseClassifierThresholds(os.Getenv("CLASSIFIER_THRESHOLDS"))
+	if err != nil {
+		componentLog("classifier").Warn("invalid classifier thresholds, using defaults", "error", err)
+		thresholds = DefaultClassifierThresholds()
+	}
+
+	strategies.Register("classifier", func() ModerationStrategy {
+		return NewClassifierStrategy(model, thresholds)
+	})
+}
+
+// runTrainClassifier implementa el subcomando "go-chat train-classifier"
+func runTrainClassifier(args []string) int {
+	flags := flag.NewFlagSet("train-classifier", flag.ContinueOnError)
+	dataFile := flags.String("data", "", "dataset etiquetado (.csv con message,expected o .jsonl)")
+	output := flags.String("out", dataPath("classifier.json"), "archivo donde guardar el modelo")
+	ngramMin := flags.Int("ngram-min", 2, "tamaño mínimo de los n-gramas de caracteres")
+	ngramMax := flags.Int("ngram-max", 4, "tamaño máximo de los n-gramas de caracteres")
+	alpha := flags.Float64("alpha", 1, "suavizado de Laplace")
+	holdout := flags.Float64("holdout", 0.2, "fracción del dataset reservada para calibrar y validar")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if *dataFile == "" || *ngramMin < 1 || *ngramMax < *ngramMin {
+		fmt.Fprintln(os.Stderr, "Uso: go-chat train-classifier -data dataset.csv [-out modelo.json]")
+		flags.PrintDefaults()
+		return 2
+	}
+
+	samples, err := LoadCorpus(*dataFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error leyendo dataset: %v\n", err)
+		return 2
+	}
+
+	// Separar de forma determinística una muestra de cada 1/holdout para validar
+	train, validation := []EvalSample{}, []EvalSample{}
+	every := 0
+	if *holdout > 0 {
+		every = int(math.Round(1 / *holdout))
+	}
+	for i, sample := range samples {
+		if every > 0 && i%every == every-1 {
+			validation = append(validation, sample)
+		} else {
+			train = append(train, sample)
+		}
+	}
+	if len(validation) == 0 {
+		validation = train
+	}
+
+	model := TrainClassifier(train, *ngramMin, *ngramMax, *alpha)
+	logLoss := model.Calibrate(validation)
+	temperature := model.Temperature
+	fmt.Printf("Temperatura calibrada: %.4f (log-loss de validación %.4f)\n", temperature, logLoss)
+
+	thresholds, err := ParseClassifierThresholds(os.Getenv("CLASSIFIER_THRESHOLDS"))
+	if err != nil {
+		thresholds = DefaultClassifierThresholds()
+	}
+	EvaluateStrategy(NewClassifierStrategy(model, thresholds), validation, 5).Print(os.Stdout)
+
+	// Reentrenar con todo el dataset conservando la temperatura calibrada
+	model = TrainClassifier(samples, *ngramMin, *ngramMax, *alpha)
+	model.Temperature = temperature
+	if err := writeJSONFile(*output, model); err != nil {
+		fmt.Fprintf(os.Stderr, "Error guardando modelo: %v\n", err)
+		return 2
+	}
+	fmt.Printf("Modelo guardado en %s (%d muestras, %d n-gramas)\n", *output, len(samples), model.Vocabulary)
+	return 0
+}
@@ -0,0 +1,730 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// PeerBrokerType es el broker sin servidor externo: los nodos se conectan entre sí
+const PeerBrokerType = "peers"
+
+// ClusterConfig configura el clúster de nodos sin broker externo
+type ClusterConfig struct {
+	Peers             []string `json:"peers"`     // URLs de los otros nodos, por ejemplo http://10.0.0.2:8080
+	Advertise         string   `json:"advertise"` // URL con la que los demás llegan a este nodo
+	Secret            string   `json:"secret"`    // clave compartida para autenticar los enlaces
+	HeartbeatInterval Duration `json:"heartbeat_interval"`
+	PeerTimeout       Duration `json:"peer_timeout"` // sin noticias de un nodo en este tiempo, se lo da por caído
+	BufferSize        int      `json:"buffer_size"`  // mensajes pendientes por enlace antes de descartar
+}
+
+func DefaultClusterConfig() ClusterConfig {
+	return ClusterConfig{
+		HeartbeatInterval: Duration(2 * time.Second),
+		PeerTimeout:       Duration(10 * time.Second),
+		BufferSize:        1000,
+	}
+}
+
+// LoadClusterConfig carga CLUSTER_CONFIG_FILE. CLUSTER_PEERS (separados por
+// comas), CLUSTER_ADVERTISE y CLUSTER_SECRET reemplazan los valores del archivo.
+func LoadClusterConfig() ClusterConfig {
+	config := DefaultClusterConfig()
+
+	if path := os.Getenv("CLUSTER_CONFIG_FILE"); path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			slog.Warn("could not read cluster config", "path", path, "error", err)
+		} else if err := json.Unmarshal(data, &config); err != nil {
+			slog.Warn("invalid cluster config", "path", path, "error", err)
+			config = DefaultClusterConfig()
+		}
+	}
+	if peers := os.Getenv("CLUSTER_PEERS"); peers != "" {
+		config.Peers = strings.Split(peers, ",")
+	}
+	if advertise := os.Getenv("CLUSTER_ADVERTISE"); advertise != "" {
+		config.Advertise = advertise
+	}
+	if secret := os.Getenv("CLUSTER_SECRET"); secret != "" {
+		config.Secret = secret
+	}
+	if config.BufferSize <= 0 {
+		config.BufferSize = DefaultClusterConfig().BufferSize
+	}
+	config.Advertise = normalizePeerURL(config.Advertise)
+	return config
+}
+
+func normalizePeerURL(url string) string {
+	return strings.TrimRight(strings.TrimSpace(url), "/")
+}
+
+// Cabeceras con las que un nodo se autentica al abrir un enlace
+const (
+	clusterNodeHeader      = "X-Cluster-Node"
+	clusterTimestampHeader = "X-Cluster-Timestamp"
+	clusterTargetHeader    = "X-Cluster-Target" // URL del nodo al que va el enlace
+	clusterNonceHeader     = "X-Cluster-Nonce"  // valor de un solo uso contra la repetición
+	clusterSignatureHeader = "X-Cluster-Signature"
+	clusterMaxClockSkew    = time.Minute
+)
+
+// Tipos de mensaje entre nodos
+const (
+	clusterEventMessage     = "event"     // un evento publicado en el nodo de origen
+	clusterHeartbeatMessage = "heartbeat" // estado del nodo: miembros conocidos, presencia y estrategia
+	clusterStrategyMessage  = "strategy"  // cambio de estrategia de moderación
+)
+
+type clusterMessage struct {
+	Type      string           `json:"type"`
+	Node      string           `json:"node"`
+	Advertise string           `json:"advertise,omitempty"`
+	Event     *Event           `json:"event,omitempty"`
+	Members   []string         `json:"members,omitempty"`
+	Presence  []PresenceEntry  `json:"presence,omitempty"`
+	Strategy  *strategyVersion `json:"strategy,omitempty"`
+}
+
+// strategyVersion identifica un cambio de estrategia. Si dos nodos la cambian
+// a la vez, gana el cambio más nuevo (y, a igual hora, el del ID mayor), así
+// todos terminan con la misma.
+type strategyVersion struct {
+	Name    string `json:"name"`
+	Version int64  `json:"version"` // hora del cambio en nanosegundos
+	Node    string `json:"node"`
+}
+
+func (sv strategyVersion) newerThan(other strategyVersion) bool {
+	if sv.Version != other.Version {
+		return sv.Version > other.Version
+	}
+	return sv.Node > other.Node
+}
+
+// PresenceEntry es un usuario conectado en algún nodo
+type PresenceEntry struct {
+	Username string `json:"username"`
+	Room     string `json:"room"`
+	Node     string `json:"node,omitempty"`
+}
+
+// clusterMember es lo que un nodo sabe de otro por sus mensajes
+type clusterMember struct {
+	ID       string
+	URL      string
+	LastSeen time.Time
+	Up       bool
+	Presence []PresenceEntry
+}
+
+// peerLink es el enlace saliente hacia otro nodo. Cada nodo envía por sus
+// enlaces salientes y recibe por los entrantes.
+type peerLink struct {
+	url       string
+	outbox    chan clusterMessage
+	connected int32
+	self      bool // la URL es la de este mismo nodo
+	lastError string
+	sent      int64
+	dropped   int64
+	mutex     sync.Mutex
+}
+
+// errPeerIsSelf indica que la URL del peer apunta a este mismo nodo
+var errPeerIsSelf = errors.New("el peer es este mismo nodo")
+
+// PeerBroker implementa Broker conectando los nodos directamente. Además de
+// los eventos, los nodos intercambian presencia, los miembros que conocen
+// y los cambios de estrategia de moderación.
+type PeerBroker struct {
+	nodeID     string
+	config     ClusterConfig
+	handler    func(Event)
+	links      map[string]*peerLink      // URL -> enlace saliente
+	members    map[string]*clusterMember // ID de nodo -> estado
+	strategy   strategyVersion
+	presence   func() []PresenceEntry
+	onStrategy func(name, node string)
+	nonces     map[string]time.Time // nonces ya usados -> hasta cuándo recordarlos
+	upgrader   websocket.Upgrader
+	done       chan struct{}
+	brokerCounters
+	mutex sync.RWMutex
+}
+
+// NewPeerBroker empieza a conectarse con los peers configurados
+func NewPeerBroker(nodeID string, config ClusterConfig) (*PeerBroker, error) {
+	if config.Secret == "" {
+		return nil, errors.New("el clúster necesita CLUSTER_SECRET")
+	}
+	pb := &PeerBroker{
+		nodeID:  nodeID,
+		config:  config,
+		links:   make(map[string]*peerLink),
+		members: make(map[string]*clusterMember),
+		nonces:  make(map[string]time.Time),
+		done:    make(chan struct{}),
+	}
+	for _, peer := range config.Peers {
+		pb.addPeer(peer)
+	}
+	go pb.sweep()
+	componentLog("cluster").Info("cluster node started", "node", nodeID, "peers", len(pb.links))
+	return pb, nil
+}
+
+func (pb *PeerBroker) NodeID() string {
+	return pb.nodeID
+}
+
+// SetPresenceSource indica cómo obtener los usuarios conectados a este nodo
+func (pb *PeerBroker) SetPresenceSource(presence func() []PresenceEntry) {
+	pb.mutex.Lock()
+	defer pb.mutex.Unlock()
+	pb.presence = presence
+}
+
+// OnStrategyChange registra qué hacer cuando otro nodo cambia la estrategia
+func (pb *PeerBroker) OnStrategyChange(fn func(name, node string)) {
+	pb.mutex.Lock()
+	defer pb.mutex.Unlock()
+	pb.onStrategy = fn
+}
+
+// addPeer empieza a conectarse con un nodo, si todavía no se conoce su URL
+func (pb *PeerBroker) addPeer(url string) {
+	url = normalizePeerURL(url)
+	if url == "" || url == pb.config.Advertise {
+		return
+	}
+	pb.mutex.Lock()
+	if _, exists := pb.links[url]; exists {
+		pb.mutex.Unlock()
+		return
+	}
+	link := &peerLink{url: url, outbox: make(chan clusterMessage, pb.config.BufferSize)}
+	pb.links[url] = link
+	pb.mutex.Unlock()
+	go pb.dialLoop(link)
+}
+
+// dialLoop mantiene el enlace con un peer, reconectando con espera creciente
+func (pb *PeerBroker) dialLoop(link *peerLink) {
+	backoff := time.Second
+	for {
+		started := time.Now()
+		err := pb.runLink(link)
+		if err == errPeerIsSelf {
+			link.mutex.Lock()
+			link.self = true
+			link.mutex.Unlock()
+			return
+		}
+		select {
+		case <-pb.done:
+			return
+		default:
+		}
+
+		link.mutex.Lock()
+		if err != nil && link.lastError != err.Error() {
+			componentLog("cluster").Warn("peer unreachable", "peer", link.url, "error", err)
+		}
+		if err != nil {
+			link.lastError = err.Error()
+		}
+		link.mutex.Unlock()
+
+		if time.Since(started) > 30*time.Second {
+			backoff = time.Second
+		}
+		select {
+		case <-time.After(backoff):
+		case <-pb.done:
+			return
+		}
+		if backoff < 30*time.Second {
+			backoff *= 2
+		}
+	}
+}
+
+// runLink abre el enlace y envía mensajes hasta que se corte
+func (pb *PeerBroker) runLink(link *peerLink) error {
+	target := "ws" + strings.TrimPrefix(link.url, "http") + "/cluster/link"
+	conn, resp, err := websocket.DefaultDialer.Dial(target, pb.authHeader(link.url))
+	if err != nil {
+		if resp != nil && resp.StatusCode == http.StatusConflict {
+			return errPeerIsSelf
+		}
+		return err
+	}
+	defer conn.Close()
+
+	// Por este enlace no llegan mensajes, pero hay que leer para detectar el cierre
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	atomic.StoreInt32(&link.connected, 1)
+	defer atomic.StoreInt32(&link.connected, 0)
+	link.mutex.Lock()
+	link.lastError = ""
+	link.mutex.Unlock()
+	componentLog("cluster").Info("connected to peer", "peer", link.url)
+
+	heartbeat := time.NewTicker(time.Duration(pb.config.HeartbeatInterval))
+	defer heartbeat.Stop()
+	message := pb.heartbeat()
+	for {
+		conn.SetWriteDeadline(time.Now().Add(5 * time.Second))
+		if err := conn.WriteJSON(message); err != nil {
+			return err
+		}
+		atomic.AddInt64(&link.sent, 1)
+
+		select {
+		case message = <-link.outbox:
+		case <-heartbeat.C:
+			message = pb.heartbeat()
+		case <-closed:
+			return errors.New("el peer cerró el enlace")
+		case <-pb.done:
+			return nil
+		}
+	}
+}
+
+// authHeader firma el ID del nodo, el nodo de destino, la hora y un nonce
+// con la clave compartida. Así una cabecera capturada no sirve para abrir un
+// enlace con otro nodo ni para abrir otro con el mismo.
+func (pb *PeerBroker) authHeader(target string) http.Header {
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	random := make([]byte, 16)
+	rand.Read(random)
+	nonce := hex.EncodeToString(random)
+
+	header := http.Header{}
+	header.Set(clusterNodeHeader, pb.nodeID)
+	header.Set(clusterTargetHeader, target)
+	header.Set(clusterTimestampHeader, timestamp)
+	header.Set(clusterNonceHeader, nonce)
+	header.Set(clusterSignatureHeader, pb.sign(pb.nodeID, target, timestamp, nonce))
+	return header
+}
+
+func (pb *PeerBroker) sign(node, target, timestamp, nonce string) string {
+	mac := hmac.New(sha256.New, []byte(pb.config.Secret))
+	mac.Write([]byte(strings.Join([]string{node, target, timestamp, nonce}, "\n")))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// verify comprueba la firma de un enlace entrante, que no sea vieja y que su
+// nonce no se haya usado antes
+func (pb *PeerBroker) verify(header http.Header) bool {
+	node := header.Get(clusterNodeHeader)
+	target := header.Get(clusterTargetHeader)
+	timestamp := header.Get(clusterTimestampHeader)
+	nonce := header.Get(clusterNonceHeader)
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if node == "" || target == "" || nonce == "" || err != nil {
+		return false
+	}
+	signedAt := time.Unix(seconds, 0)
+	skew := time.Since(signedAt)
+	if skew > clusterMaxClockSkew || skew < -clusterMaxClockSkew {
+		return false
+	}
+	expected := pb.sign(node, target, timestamp, nonce)
+	if !hmac.Equal([]byte(expected), []byte(header.Get(clusterSignatureHeader))) {
+		return false
+	}
+
+	// Un nonce se recuerda mientras su hora siga siendo aceptable
+	pb.mutex.Lock()
+	defer pb.mutex.Unlock()
+	now := time.Now()
+	for used, until := range pb.nonces {
+		if now.After(until) {
+			delete(pb.nonces, used)
+		}
+	}
+	if _, used := pb.nonces[nonce]; used {
+		return false
+	}
+	pb.nonces[nonce] = signedAt.Add(clusterMaxClockSkew)
+	return true
+}
+
+// isTarget indica si el enlace iba dirigido a este nodo. Con advertise se
+// compara con esa URL; sin ella solo se puede comparar con el host del pedido.
+func (pb *PeerBroker) isTarget(target string, r *http.Request) bool {
+	target = normalizePeerURL(target)
+	if pb.config.Advertise != "" {
+		return target == pb.config.Advertise
+	}
+	parsed, err := url.Parse(target)
+	return err == nil && parsed.Host == r.Host
+}
+
+// handleLink recibe el enlace de otro nodo y procesa sus mensajes
+func (pb *PeerBroker) handleLink(w http.ResponseWriter, r *http.Request) {
+	if !pb.verify(r.Header) {
+		http.Error(w, "Firma de clúster inválida", http.StatusUnauthorized)
+		return
+	}
+	node := r.Header.Get(clusterNodeHeader)
+	if node == pb.nodeID {
+		http.Error(w, "Es este mismo nodo", http.StatusConflict)
+		return
+	}
+	if !pb.isTarget(r.Header.Get(clusterTargetHeader), r) {
+		http.Error(w, "El enlace es para otro nodo", http.StatusUnauthorized)
+		return
+	}
+	conn, err := pb.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	for {
+		var message clusterMessage
+		if err := conn.ReadJSON(&message); err != nil {
+			return
+		}
+		if message.Node != node {
+			continue // un nodo solo habla por sí mismo
+		}
+		pb.receive(message)
+	}
+}
+
+// receive procesa un mensaje de otro nodo
+func (pb *PeerBroker) receive(message clusterMessage) {
+	pb.touch(message)
+
+	switch message.Type {
+	case clusterEventMessage:
+		pb.mutex.RLock()
+		handler := pb.handler
+		pb.mutex.RUnlock()
+		if message.Event != nil && handler != nil {
+			atomic.AddInt64(&pb.received, 1)
+			handler(*message.Event)
+		}
+	case clusterHeartbeatMessage:
+		// Los nodos se cuentan los miembros que conocen, así uno nuevo
+		// alcanza con que figure en la lista de otro
+		for _, url := range message.Members {
+			pb.addPeer(url)
+		}
+		pb.mutex.Lock()
+		pb.members[message.Node].Presence = message.Presence
+		pb.mutex.Unlock()
+		if message.Strategy != nil {
+			pb.applyStrategy(*message.Strategy)
+		}
+	case clusterStrategyMessage:
+		if message.Strategy != nil {
+			pb.applyStrategy(*message.Strategy)
+		}
+	}
+}
+
+// touch marca al nodo como activo
+func (pb *PeerBroker) touch(message clusterMessage) {
+	pb.mutex.Lock()
+	member, exists := pb.members[message.Node]
+	if !exists {
+		member = &clusterMember{ID: message.Node}
+		pb.members[message.Node] = member
+	}
+	rejoined := !member.Up
+	member.Up = true
+	member.LastSeen = time.Now()
+	if message.Advertise != "" {
+		member.URL = message.Advertise
+	}
+	pb.mutex.Unlock()
+
+	if rejoined {
+		componentLog("cluster").Info("node joined the cluster", "node", message.Node)
+	}
+	if message.Advertise != "" {
+		pb.addPeer(message.Advertise)
+	}
+}
+
+// sweep da por caídos a los nodos que dejaron de enviar mensajes y descarta su presencia
+func (pb *PeerBroker) sweep() {
+	ticker := time.NewTicker(time.Duration(pb.config.HeartbeatInterval))
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-pb.done:
+			return
+		}
+		pb.mutex.Lock()
+		for _, member := range pb.members {
+			if member.Up && time.Since(member.LastSeen) > time.Duration(pb.config.PeerTimeout) {
+				member.Up = false
+				member.Presence = nil
+				componentLog("cluster").Warn("node not responding, removing its presence", "node", member.ID)
+			}
+		}
+		pb.mutex.Unlock()
+	}
+}
+
+// applyStrategy adopta un cambio de estrategia si es más nuevo que el actual
+func (pb *PeerBroker) applyStrategy(version strategyVersion) {
+	pb.mutex.Lock()
+	if !version.newerThan(pb.strategy) {
+		pb.mutex.Unlock()
+		return
+	}
+	pb.strategy = version
+	onStrategy := pb.onStrategy
+	pb.mutex.Unlock()
+
+	if onStrategy != nil && version.Node != pb.nodeID {
+		onStrategy(version.Name, version.Node)
+	}
+}
+
+// BroadcastStrategy avisa a los demás nodos que este cambió la estrategia
+func (pb *PeerBroker) BroadcastStrategy(name string) {
+	version := strategyVersion{Name: name, Version: time.Now().UnixNano(), Node: pb.nodeID}
+	pb.mutex.Lock()
+	pb.strategy = version
+	pb.mutex.Unlock()
+	pb.broadcast(clusterMessage{Type: clusterStrategyMessage, Node: pb.nodeID, Strategy: &version})
+}
+
+// heartbeat arma el mensaje periódico con el estado de este nodo
+func (pb *PeerBroker) heartbeat() clusterMessage {
+	pb.mutex.RLock()
+	members := []string{}
+	for url, link := range pb.links {
+		link.mutex.Lock()
+		if !link.self {
+			members = append(members, url)
+		}
+		link.mutex.Unlock()
+	}
+	presence := pb.presence
+	message := clusterMessage{
+		Type:      clusterHeartbeatMessage,
+		Node:      pb.nodeID,
+		Advertise: pb.config.Advertise,
+		Members:   members,
+	}
+	if pb.strategy.Name != "" {
+		strategy := pb.strategy
+		message.Strategy = &strategy
+	}
+	pb.mutex.RUnlock()
+
+	if presence != nil {
+		message.Presence = presence()
+	}
+	return message
+}
+
+// broadcast encola un mensaje en los enlaces conectados. Mientras un enlace
+// está caído no se le encola nada, para no mandarle eventos viejos al volver.
+func (pb *PeerBroker) broadcast(message clusterMessage) {
+	pb.mutex.RLock()
+	defer pb.mutex.RUnlock()
+	for _, link := range pb.links {
+		if atomic.LoadInt32(&link.connected) == 0 {
+			continue
+		}
+		select {
+		case link.outbox <- message:
+		default:
+			atomic.AddInt64(&link.dropped, 1)
+			atomic.AddInt64(&pb.dropped, 1)
+		}
+	}
+}
+
+func (pb *PeerBroker) Publish(event Event) error {
+	atomic.AddInt64(&pb.published, 1)
+	pb.broadcast(clusterMessage{Type: clusterEventMessage, Node: pb.nodeID, Event: &event})
+	return nil
+}
+
+func (pb *PeerBroker) Subscribe(handler func(Event)) error {
+	pb.mutex.Lock()
+	defer pb.mutex.Unlock()
+	pb.handler = handler
+	return nil
+}
+
+func (pb *PeerBroker) Close() error {
+	close(pb.done)
+	return nil
+}
+
+// Presence devuelve los usuarios conectados en todo el clúster
+func (pb *PeerBroker) Presence() []PresenceEntry {
+	pb.mutex.RLock()
+	presence := pb.presence
+	entries := []PresenceEntry{}
+	for _, member := range pb.members {
+		if member.Up {
+			for _, entry := range member.Presence {
+				entry.Node = member.ID
+				entries = append(entries, entry)
+			}
+		}
+	}
+	pb.mutex.RUnlock()
+
+	if presence != nil {
+		for _, entry := range presence() {
+			entry.Node = pb.nodeID
+			entries = append(entries, entry)
+		}
+	}
+	return entries
+}
+
+func (pb *PeerBroker) GetStats() map[string]interface{} {
+	stats := pb.stats(PeerBrokerType, pb.nodeID)
+
+	pb.mutex.RLock()
+	defer pb.mutex.RUnlock()
+	links := []map[string]interface{}{}
+	for _, link := range pb.links {
+		link.mutex.Lock()
+		if !link.self {
+			links = append(links, map[string]interface{}{
+				"url":        link.url,
+				"connected":  atomic.LoadInt32(&link.connected) == 1,
+				"sent":       atomic.LoadInt64(&link.sent),
+				"dropped":    atomic.LoadInt64(&link.dropped),
+				"last_error": link.lastError,
+			})
+		}
+		link.mutex.Unlock()
+	}
+	sort.Slice(links, func(i, j int) bool { return links[i]["url"].(string) < links[j]["url"].(string) })
+
+	members := []map[string]interface{}{}
+	for _, member := range pb.members {
+		members = append(members, map[string]interface{}{
+			"id":        member.ID,
+			"url":       member.URL,
+			"up":        member.Up,
+			"last_seen": member.LastSeen,
+			"users":     len(member.Presence),
+		})
+	}
+	sort.Slice(members, func(i, j int) bool { return members[i]["id"].(string) < members[j]["id"].(string) })
+
+	stats["links"] = links
+	stats["members"] = members
+	stats["strategy"] = pb.strategy
+	return stats
+}
+
+// localPresence lista los usuarios conectados a este nodo
+func (s *Server) localPresence() []PresenceEntry {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	entries := make([]PresenceEntry, 0, len(s.observerMap))
+	for _, observer := range s.observerMap {
+		entries = append(entries, PresenceEntry{Username: observer.GetUsername(), Room: observer.GetRoom()})
+	}
+	return entries
+}
+
+// applyClusterStrategy aplica la estrategia que eligió otro nodo
+func (s *Server) applyClusterStrategy(name, node string) {
+	strategy, err := strategies.Create(name)
+	if err != nil {
+		componentLog("cluster").Warn("node switched to a strategy that is not available here", "node", node, "strategy", name, "error", err)
+		return
+	}
+	s.setModerationStrategy(strategy, "cluster:"+node)
+}
+
+// handleClusterLink recibe los enlaces de los demás nodos
+func (s *Server) handleClusterLink(w http.ResponseWriter, r *http.Request) {
+	if s.cluster == nil {
+		http.Error(w, "El clúster no está configurado", http.StatusNotFound)
+		return
+	}
+	s.cluster.handleLink(w, r)
+}
+
+// handleCluster muestra el estado del clúster: enlaces, miembros y estrategia
+func (s *Server) handleCluster(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Método no permitido", http.StatusMethodNotAllowed)
+		return
+	}
+	if s.cluster == nil {
+		http.Error(w, "El clúster no está configurado", http.StatusNotFound)
+		return
+	}
+	writeJSON(w, s.cluster.GetStats())
+}
+
+// handlePresence lista los usuarios conectados, en todos los nodos si hay
+// clúster. Con ?room=... se limita a una sala.
+func (s *Server) handlePresence(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Método no permitido", http.StatusMethodNotAllowed)
+		return
+	}
+	entries := s.localPresence()
+	if s.cluster != nil {
+		entries = s.cluster.Presence()
+	}
+	room := r.URL.Query().Get("room")
+	users := []PresenceEntry{}
+	for _, entry := range entries {
+		if room == "" || entry.Room == room {
+			users = append(users, entry)
+		}
+	}
+	sort.Slice(users, func(i, j int) bool {
+		if users[i].Room != users[j].Room {
+			return users[i].Room < users[j].Room
+		}
+		return users[i].Username < users[j].Username
+	})
+	writeJSON(w, map[string]interface{}{
+		"users": users,
+		"count": len(users),
+	})
+}
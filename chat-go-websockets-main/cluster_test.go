@@ -0,0 +1,46 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestClusterLinkSignature(t *testing.T) {
+	config := DefaultClusterConfig()
+	config.Secret = "secreto"
+	config.Advertise = "http://10.0.0.2:8080"
+	receiver, err := NewPeerBroker("nodo-b", config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer receiver.Close()
+
+	config.Advertise = "http://10.0.0.1:8080"
+	sender, err := NewPeerBroker("nodo-a", config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sender.Close()
+
+	header := sender.authHeader("http://10.0.0.2:8080")
+	if !receiver.verify(header) {
+		t.Fatal("la firma válida fue rechazada")
+	}
+	if receiver.verify(header) {
+		t.Error("repetir las mismas cabeceras debería rechazarse")
+	}
+
+	request := httptest.NewRequest("GET", "/cluster/link", nil)
+	if !receiver.isTarget(header.Get(clusterTargetHeader), request) {
+		t.Error("el enlace iba dirigido a este nodo")
+	}
+	other := sender.authHeader("http://10.0.0.3:8080")
+	if receiver.isTarget(other.Get(clusterTargetHeader), request) {
+		t.Error("un enlace firmado para otro nodo no debería aceptarse")
+	}
+
+	other.Set(clusterTargetHeader, "http://10.0.0.2:8080")
+	if receiver.verify(other) {
+		t.Error("cambiar el destino debería invalidar la firma")
+	}
+}
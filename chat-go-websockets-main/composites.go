@@ -0,0 +1,244 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CompositeDefinition describe una estrategia compuesta con nombre, definida en tiempo de ejecución
+//
+//	{
+//	    "name": "estricta-con-spam",
+//	    "steps": [
+//	        {"strategy": "spam", "stop_on": ["block"]},
+//	        {"strategy": "badword", "weight": 2},
+//	        {"strategy": "warning"}
+//	    ],
+//	    "merge": "most_severe",
+//	    "confidence": "max"
+//	}
+type CompositeDefinition struct {
+	Name       string          `json:"name"`
+	Steps      []CompositeStep `json:"steps"`
+	Merge      string          `json:"merge,omitempty"`      // most_severe | first
+	Confidence string          `json:"confidence,omitempty"` // max | mean
+	CreatedBy  string          `json:"created_by,omitempty"`
+	UpdatedAt  time.Time       `json:"updated_at"`
+}
+
+// Build instancia los pasos desde el registro de estrategias. Si una
+// estrategia fue quitada del registro, se omite ese paso.
+func (cd CompositeDefinition) Build() ModerationStrategy {
+	chain := make([]ModerationStrategy, 0, len(cd.Steps))
+	steps := make([]CompositeStep, 0, len(cd.Steps))
+	for _, step := range cd.Steps {
+		strategy, err := strategies.Create(step.Strategy)
+		if err != nil {
+			componentLog("composites").Warn("skipping composite step", "composite", cd.Name, "strategy", step.Strategy, "error", err)
+			continue
+		}
+		chain = append(chain, strategy)
+		steps = append(steps, step)
+	}
+	return NewConfiguredCompositeStrategy(cd.Name, chain, steps, cd.Merge, cd.Confidence)
+}
+
+// CompositeStore guarda las compuestas definidas por los moderadores en
+// data/composites.json y las registra en el registro de estrategias
+type CompositeStore struct {
+	path        string
+	definitions map[string]CompositeDefinition
+	mutex       sync.RWMutex
+}
+
+// NewCompositeStore carga las compuestas guardadas y las registra
+func NewCompositeStore(path string) *CompositeStore {
+	cs := &CompositeStore{
+		path:        path,
+		definitions: make(map[string]CompositeDefinition),
+	}
+	if err := readJSONFile(path, &cs.definitions); err != nil {
+		componentLog("composites").Error("could not load file", "path", path, "error", err)
+	}
+	for key, definition := range cs.definitions {
+		cs.register(key, definition)
+	}
+	return cs
+}
+
+func (cs *CompositeStore) register(key string, definition CompositeDefinition) {
+	strategies.Register(key, definition.Build)
+}
+
+// Define crea o reemplaza una compuesta después de validarla
+func (cs *CompositeStore) Define(definition CompositeDefinition) error {
+	definition.Name = strings.TrimSpace(definition.Name)
+	key := strings.ToLower(definition.Name)
+	if key == "" {
+		return errors.New("la compuesta necesita un nombre")
+	}
+	if len(definition.Steps) == 0 {
+		return errors.New("la compuesta necesita al menos un paso")
+	}
+	if definition.Merge != "" && definition.Merge != MergeMostSevere && definition.Merge != MergeFirst {
+		return fmt.Errorf("merge inválido: %s (most_severe|first)", definition.Merge)
+	}
+	if definition.Confidence != "" && definition.Confidence != ConfidenceMax && definition.Confidence != ConfidenceMean {
+		return fmt.Errorf("confidence inválido: %s (max|mean)", definition.Confidence)
+	}
+
+	cs.mutex.Lock()
+	defer cs.mutex.Unlock()
+
+	// No pisar estrategias que no sean compuestas definidas aquí
+	if _, defined := cs.definitions[key]; !defined && containsFold(strategies.Names(), key) {
+		return errors.New("ya existe una estrategia con ese nombre: " + definition.Name)
+	}
+	for _, step := range definition.Steps {
+		if !containsFold(strategies.Names(), step.Strategy) && !strings.EqualFold(step.Strategy, key) {
+			return errors.New("estrategia desconocida: " + step.Strategy)
+		}
+	}
+	if cs.hasCycle(key, definition, map[string]bool{}) {
+		return errors.New("la compuesta se incluye a sí misma: " + definition.Name)
+	}
+
+	definition.UpdatedAt = time.Now()
+	cs.definitions[key] = definition
+	cs.register(key, definition)
+	cs.save()
+	return nil
+}
+
+// hasCycle busca si la definición termina incluyéndose a sí misma
+func (cs *CompositeStore) hasCycle(root string, definition CompositeDefinition, visiting map[string]bool) bool {
+	for _, step := range definition.Steps {
+		key := strings.ToLower(step.Strategy)
+		if key == root {
+			return true
+		}
+		nested, isComposite := cs.definitions[key]
+		if !isComposite || visiting[key] {
+			continue
+		}
+		visiting[key] = true
+		if cs.hasCycle(root, nested, visiting) {
+			return true
+		}
+	}
+	return false
+}
+
+// Remove borra una compuesta y la quita del registro
+func (cs *CompositeStore) Remove(name string) bool {
+	cs.mutex.Lock()
+	defer cs.mutex.Unlock()
+	key := strings.ToLower(name)
+	if _, exists := cs.definitions[key]; !exists {
+		return false
+	}
+	delete(cs.definitions, key)
+	strategies.Unregister(key)
+	cs.save()
+	return true
+}
+
+// List devuelve las compuestas definidas ordenadas por nombre
+func (cs *CompositeStore) List() []CompositeDefinition {
+	cs.mutex.RLock()
+	defer cs.mutex.RUnlock()
+	definitions := make([]CompositeDefinition, 0, len(cs.definitions))
+	for _, definition := range cs.definitions {
+		definitions = append(definitions, definition)
+	}
+	sort.Slice(definitions, func(i, j int) bool { return definitions[i].Name < definitions[j].Name })
+	return definitions
+}
+
+// save persiste las definiciones; se llama con el lock tomado
+func (cs *CompositeStore) save() {
+	if err := writeJSONFile(cs.path, cs.definitions); err != nil {
+		componentLog("composites").Error("could not save file", "path", cs.path, "error", err)
+	}
+}
+
+// handleComposites administra las compuestas con nombre:
+// GET las lista, POST define o reemplaza una y DELETE ?name=... la borra
+func (s *Server) handleComposites(w http.ResponseWriter, r *http.Request) {
+	moderator := r.Header.Get("X-Moderator")
+	if moderator == "" {
+		moderator = "admin"
+	}
+
+	switch r.Method {
+	case "GET":
+		writeJSON(w, s.composites.List())
+	case "POST":
+		var definition CompositeDefinition
+		if err := json.NewDecoder(r.Body).Decode(&definition); err != nil {
+			http.Error(w, "JSON inválido: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		definition.CreatedBy = moderator
+		if err := s.composites.Define(definition); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		s.audit.Record(AuditEntry{
+			Kind:     AuditModeratorAction,
+			Actor:    moderator,
+			Action:   "define_composite",
+			Strategy: definition.Name,
+			Data: map[string]interface{}{
+				"steps":      definition.Steps,
+				"merge":      definition.Merge,
+				"confidence": definition.Confidence,
+			},
+		})
+		w.WriteHeader(http.StatusCreated)
+		writeJSON(w, s.composites.List())
+	case "DELETE":
+		name := r.URL.Query().Get("name")
+		if !s.composites.Remove(name) {
+			http.Error(w, "Compuesta no encontrada", http.StatusNotFound)
+			return
+		}
+		s.audit.Record(AuditEntry{
+			Kind:     AuditModeratorAction,
+			Actor:    moderator,
+			Action:   "delete_composite",
+			Strategy: name,
+		})
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "Método no permitido", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleCompositeActivate activa una compuesta definida: POST {"name": "..."}
+func (s *Server) handleCompositeActivate(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Método no permitido", http.StatusMethodNotAllowed)
+		return
+	}
+	var req struct {
+		Name string `json:"name"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "JSON inválido: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	strategy, err := strategies.Create(req.Name)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	s.SetModerationStrategy(strategy)
+	w.Write([]byte("Estrategia cambiada a " + strategy.GetName()))
+}
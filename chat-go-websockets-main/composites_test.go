@@ -0,0 +1,45 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestCompositeStopOnSurvivesReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "composites.json")
+	store := NewCompositeStore(path)
+	err := store.Define(CompositeDefinition{
+		Name: "prueba-stop-on",
+		Steps: []CompositeStep{
+			{Strategy: "strict", StopOn: []string{}},
+			{Strategy: "warning"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("Define: %v", err)
+	}
+	t.Cleanup(func() { store.Remove("prueba-stop-on") })
+
+	// Al recargar el archivo, stop_on vacío sigue sin cortar la cadena y el
+	// paso sin stop_on sigue usando el valor por defecto
+	reloaded := NewCompositeStore(path)
+	steps := reloaded.List()[0].Steps
+	if steps[0].StopOn == nil || len(steps[0].StopOn) != 0 {
+		t.Fatalf("stop_on del primer paso = %#v, se esperaba []", steps[0].StopOn)
+	}
+	if steps[1].StopOn != nil {
+		t.Fatalf("stop_on del segundo paso = %#v, se esperaba nil", steps[1].StopOn)
+	}
+
+	strategy, err := strategies.Create("prueba-stop-on")
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	result := strategy.Moderate("esto es spam")
+	if result.Action != "block" {
+		t.Fatalf("Action = %q, se esperaba block", result.Action)
+	}
+	if len(result.Trace) != 2 || result.Trace[0].Stopped {
+		t.Errorf("con stop_on vacío deberían correr los dos pasos: %+v", result.Trace)
+	}
+}
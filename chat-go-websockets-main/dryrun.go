@@ -0,0 +1,193 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// ModerationTestRequest es el cuerpo de POST /moderation/test
+type ModerationTestRequest struct {
+	Message  string            `json:"message"`
+	Strategy string            `json:"strategy,omitempty"` // nombre en el registro; vacío = estrategia activa
+	Policy   *ModerationPolicy `json:"policy,omitempty"`   // política en línea, en lugar de una estrategia
+	Username string            `json:"username,omitempty"`
+	Room     string            `json:"room,omitempty"`
+}
+
+// TextSpan es la posición de un término detectado dentro del mensaje original.
+// Start y End se cuentan en caracteres, no en bytes.
+type TextSpan struct {
+	Term     string `json:"term"`
+	Strategy string `json:"strategy"`
+	Start    int    `json:"start"`
+	End      int    `json:"end"`
+}
+
+// ModerationTestResponse es el resultado completo de una prueba de moderación
+type ModerationTestResponse struct {
+	Message        string           `json:"message"`
+	NormalizedText string           `json:"normalized_text"`
+	Strategy       string           `json:"strategy"`
+	Result         ModerationResult `json:"result"`
+	Rules          []ModerationStep `json:"rules"` // pasos que no dieron allow
+	Spans          []TextSpan       `json:"spans"`
+	ReviewRule     *ReviewRule      `json:"review_rule,omitempty"` // regla de revisión que se aplicaría
+}
+
+// normalizeForModeration es el texto tal como lo comparan las estrategias de palabras
+func normalizeForModeration(message string) string {
+	return strings.Join(strings.Fields(strings.ToLower(message)), " ")
+}
+
+// findSpans ubica cada término en el mensaje original comparando igual que la
+// estrategia que lo detectó: BadWordReplacement por palabra completa, el
+// clasificador por palabra y las demás por subcadena. Las señales de spam
+// ("burst", "links"...) no son palabras del texto y no tienen posición.
+func findSpans(message, term, strategy string) []TextSpan {
+	if term == "" {
+		return nil
+	}
+	var matches [][]int
+	switch strategy {
+	case "SpamBehavior":
+		return nil
+	case "Classifier":
+		for _, match := range wordPattern.FindAllStringIndex(message, -1) {
+			if strings.EqualFold(message[match[0]:match[1]], term) {
+				matches = append(matches, match)
+			}
+		}
+	case "BadWordReplacement":
+		pattern, err := regexp.Compile(`(?i)\b` + regexp.QuoteMeta(term) + `\b`)
+		if err != nil {
+			return nil
+		}
+		matches = pattern.FindAllStringIndex(message, -1)
+	default:
+		pattern, err := regexp.Compile(`(?i)` + regexp.QuoteMeta(term))
+		if err != nil {
+			return nil
+		}
+		matches = pattern.FindAllStringIndex(message, -1)
+	}
+
+	spans := []TextSpan{}
+	for _, match := range matches {
+		spans = append(spans, TextSpan{
+			Term:     term,
+			Strategy: strategy,
+			Start:    utf8.RuneCountInString(message[:match[0]]),
+			End:      utf8.RuneCountInString(message[:match[1]]),
+		})
+	}
+	return spans
+}
+
+// TestModeration modera un mensaje sin publicarlo ni registrar strikes, auditoría ni historial
+func (s *Server) TestModeration(req ModerationTestRequest) (ModerationTestResponse, error) {
+	var strategy ModerationStrategy
+	var err error
+	switch {
+	case req.Policy != nil:
+		strategy, err = req.Policy.Build()
+	case req.Strategy != "":
+		strategy, err = strategies.Create(req.Strategy)
+	default:
+		s.mutex.RLock()
+		strategy = s.moderationObserver.Moderator.strategy
+		s.mutex.RUnlock()
+	}
+	if err != nil {
+		return ModerationTestResponse{}, err
+	}
+
+	room := req.Room
+	if room == "" {
+		room = DefaultRoom
+	}
+	now := time.Now()
+	ctx := MessageContext{
+		Username:  req.Username,
+		Room:      room,
+		Timestamp: now,
+		DryRun:    true,
+	}
+	if req.Username != "" {
+		ctx.FirstSeen = now
+		if first, exists := s.firstSeen.Get(req.Username); exists {
+			ctx.FirstSeen = first
+		}
+	}
+
+	result := moderateWithContext(strategy, req.Message, ctx)
+
+	// Un resultado simple se muestra como un único paso
+	steps := result.Trace
+	if len(steps) == 0 {
+		steps = []ModerationStep{{
+			Strategy:        result.StrategyUsed,
+			Action:          result.Action,
+			Reason:          result.Reason,
+			Confidence:      result.Confidence,
+			ModifiedMessage: result.ModifiedMessage,
+			MatchedTerms:    result.MatchedTerms,
+		}}
+	}
+
+	response := ModerationTestResponse{
+		Message:        req.Message,
+		NormalizedText: normalizeForModeration(req.Message),
+		Strategy:       strategy.GetName(),
+		Result:         result,
+		Rules:          []ModerationStep{},
+		Spans:          []TextSpan{},
+	}
+	for _, step := range steps {
+		if step.Action == "allow" {
+			continue
+		}
+		response.Rules = append(response.Rules, step)
+		for _, term := range step.MatchedTerms {
+			response.Spans = append(response.Spans, findSpans(req.Message, term, step.Strategy)...)
+		}
+	}
+	sort.Slice(response.Spans, func(i, j int) bool { return response.Spans[i].Start < response.Spans[j].Start })
+
+	if rule, matched := s.review.Match(result); matched {
+		response.ReviewRule = &rule
+	}
+	return response, nil
+}
+
+// handleModerationTest implementa POST /moderation/test; GET lista las estrategias disponibles
+func (s *Server) handleModerationTest(w http.ResponseWriter, r *http.Request) {
+	if r.Method == "GET" {
+		writeJSON(w, map[string]interface{}{"strategies": strategies.Names()})
+		return
+	}
+	if r.Method != "POST" {
+		http.Error(w, "Método no permitido", http.StatusMethodNotAllowed)
+		return
+	}
+	var req ModerationTestRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "JSON inválido: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.Message == "" {
+		http.Error(w, "Falta el mensaje", http.StatusBadRequest)
+		return
+	}
+
+	response, err := s.TestModeration(req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	writeJSON(w, response)
+}
@@ -0,0 +1,345 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// EvalSample es un mensaje etiquetado del corpus de evaluación
+type EvalSample struct {
+	Message  string `json:"message"`
+	Expected string `json:"expected"`
+}
+
+// EvalExample es un mensaje clasificado distinto de lo esperado
+type EvalExample struct {
+	Message   string `json:"message"`
+	Expected  string `json:"expected"`
+	Predicted string `json:"predicted"`
+	Reason    string `json:"reason"`
+}
+
+// ActionMetrics son las métricas de una acción de moderación
+type ActionMetrics struct {
+	Precision float64 `json:"precision"`
+	Recall    float64 `json:"recall"`
+	F1        float64 `json:"f1"`
+	Support   int     `json:"support"`   // mensajes con esa acción esperada
+	Predicted int     `json:"predicted"` // mensajes con esa acción predicha
+}
+
+// LatencyStats resume el tiempo de moderación por mensaje
+type LatencyStats struct {
+	Mean time.Duration `json:"mean"`
+	P50  time.Duration `json:"p50"`
+	P95  time.Duration `json:"p95"`
+	Max  time.Duration `json:"max"`
+}
+
+// EvalReport es el resultado de evaluar una estrategia sobre un corpus
+type EvalReport struct {
+	Strategy       string                    `json:"strategy"`
+	Samples        int                       `json:"samples"`
+	Accuracy       float64                   `json:"accuracy"`
+	Actions        []string                  `json:"actions"`
+	PerAction      map[string]ActionMetrics  `json:"per_action"`
+	Confusion      map[string]map[string]int `json:"confusion"` // esperada -> predicha
+	FalsePositives []EvalExample             `json:"false_positives"`
+	Latency        LatencyStats              `json:"latency"`
+}
+
+// LoadCorpus lee un corpus CSV (message,expected) o JSON Lines
+func LoadCorpus(path string) ([]EvalSample, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".csv":
+		return loadCSVCorpus(file)
+	case ".jsonl", ".ndjson":
+		return loadJSONLCorpus(file)
+	}
+	return nil, errors.New("formato de corpus no soportado (usar .csv o .jsonl): " + path)
+}
+
+func loadCSVCorpus(r io.Reader) ([]EvalSample, error) {
+	records, err := csv.NewReader(r).ReadAll()
+	if err != nil {
+		return nil, err
+	}
+
+	messageCol, expectedCol := 0, 1
+	if len(records) > 0 {
+		// Si la primera fila es un encabezado, usarlo para ubicar las columnas
+		header := records[0]
+		for i, name := range header {
+			switch strings.ToLower(strings.TrimSpace(name)) {
+			case "message":
+				messageCol = i
+			case "expected", "expected_action", "action":
+				expectedCol = i
+			}
+		}
+		if containsFold(header, "message") {
+			records = records[1:]
+		}
+	}
+
+	samples := []EvalSample{}
+	for line, record := range records {
+		if len(record) <= messageCol || len(record) <= expectedCol {
+			return nil, fmt.Errorf("fila %d incompleta", line+1)
+		}
+		samples = append(samples, EvalSample{
+			Message:  record[messageCol],
+			Expected: strings.ToLower(strings.TrimSpace(record[expectedCol])),
+		})
+	}
+	return samples, nil
+}
+
+func loadJSONLCorpus(r io.Reader) ([]EvalSample, error) {
+	samples := []EvalSample{}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	line := 0
+	for scanner.Scan() {
+		line++
+		if strings.TrimSpace(scanner.Text()) == "" {
+			continue
+		}
+		var raw struct {
+			Message        string `json:"message"`
+			Expected       string `json:"expected"`
+			ExpectedAction string `json:"expected_action"`
+		}
+		if err := json.Unmarshal(scanner.Bytes(), &raw); err != nil {
+			return nil, fmt.Errorf("línea %d: %v", line, err)
+		}
+		expected := raw.Expected
+		if expected == "" {
+			expected = raw.ExpectedAction
+		}
+		samples = append(samples, EvalSample{
+			Message:  raw.Message,
+			Expected: strings.ToLower(strings.TrimSpace(expected)),
+		})
+	}
+	return samples, scanner.Err()
+}
+
+// EvaluateStrategy modera cada mensaje del corpus y calcula las métricas
+func EvaluateStrategy(strategy ModerationStrategy, samples []EvalSample, maxExamples int) EvalReport {
+	report := EvalReport{
+		Strategy:       strategy.GetName(),
+		Samples:        len(samples),
+		PerAction:      make(map[string]ActionMetrics),
+		Confusion:      make(map[string]map[string]int),
+		FalsePositives: []EvalExample{},
+	}
+
+	actions := map[string]bool{}
+	latencies := make([]time.Duration, 0, len(samples))
+	correct := 0
+	var total time.Duration
+
+	for _, sample := range samples {
+		start := time.Now()
+		result := strategy.Moderate(sample.Message)
+		elapsed := time.Since(start)
+		latencies = append(latencies, elapsed)
+		total += elapsed
+
+		actions[sample.Expected] = true
+		actions[result.Action] = true
+		if report.Confusion[sample.Expected] == nil {
+			report.Confusion[sample.Expected] = make(map[string]int)
+		}
+		report.Confusion[sample.Expected][result.Action]++
+
+		if result.Action == sample.Expected {
+			correct++
+		} else if result.Action != "allow" && len(report.FalsePositives) < maxExamples {
+			report.FalsePositives = append(report.FalsePositives, EvalExample{
+				Message:   sample.Message,
+				Expected:  sample.Expected,
+				Predicted: result.Action,
+				Reason:    result.Reason,
+			})
+		}
+	}
+
+	for action := range actions {
+		report.Actions = append(report.Actions, action)
+	}
+	sort.Strings(report.Actions)
+
+	for _, action := range report.Actions {
+		metrics := ActionMetrics{}
+		truePositives := report.Confusion[action][action]
+		for _, other := range report.Actions {
+			metrics.Predicted += report.Confusion[other][action]
+			metrics.Support += report.Confusion[action][other]
+		}
+		if metrics.Predicted > 0 {
+			metrics.Precision = float64(truePositives) / float64(metrics.Predicted)
+		}
+		if metrics.Support > 0 {
+			metrics.Recall = float64(truePositives) / float64(metrics.Support)
+		}
+		if metrics.Precision+metrics.Recall > 0 {
+			metrics.F1 = 2 * metrics.Precision * metrics.Recall / (metrics.Precision + metrics.Recall)
+		}
+		report.PerAction[action] = metrics
+	}
+
+	if len(samples) > 0 {
+		report.Accuracy = float64(correct) / float64(len(samples))
+		sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+		report.Latency = LatencyStats{
+			Mean: total / time.Duration(len(samples)),
+			P50:  latencies[len(latencies)/2],
+			P95:  latencies[len(latencies)*95/100],
+			Max:  latencies[len(latencies)-1],
+		}
+	}
+	return report
+}
+
+// Print escribe el reporte en formato de texto
+func (r EvalReport) Print(w io.Writer) {
+	fmt.Fprintf(w, "=== %s (%d mensajes) ===\n", r.Strategy, r.Samples)
+	fmt.Fprintf(w, "Exactitud: %.3f\n\n", r.Accuracy)
+
+	fmt.Fprintf(w, "%-10s %9s %9s %9s %9s %9s\n", "acción", "precisión", "recall", "f1", "esperados", "predichos")
+	for _, action := range r.Actions {
+		m := r.PerAction[action]
+		fmt.Fprintf(w, "%-10s %9.3f %9.3f %9.3f %9d %9d\n", action, m.Precision, m.Recall, m.F1, m.Support, m.Predicted)
+	}
+
+	fmt.Fprintf(w, "\nMatriz de confusión (filas = esperada, columnas = predicha)\n%-10s", "")
+	for _, action := range r.Actions {
+		fmt.Fprintf(w, " %8s", action)
+	}
+	fmt.Fprintln(w)
+	for _, expected := range r.Actions {
+		fmt.Fprintf(w, "%-10s", expected)
+		for _, predicted := range r.Actions {
+			fmt.Fprintf(w, " %8d", r.Confusion[expected][predicted])
+		}
+		fmt.Fprintln(w)
+	}
+
+	if len(r.FalsePositives) > 0 {
+		fmt.Fprintln(w, "\nFalsos positivos:")
+		for _, example := range r.FalsePositives {
+			fmt.Fprintf(w, "  [%s → %s] %q (%s)\n", example.Expected, example.Predicted, example.Message, example.Reason)
+		}
+	}
+
+	fmt.Fprintf(w, "\nLatencia por mensaje: media %v, p50 %v, p95 %v, máx %v\n\n",
+		r.Latency.Mean, r.Latency.P50, r.Latency.P95, r.Latency.Max)
+}
+
+// runModerateEval implementa el subcomando "go-chat moderate-eval".
+// Devuelve 0 si todo salió bien, 1 si no se alcanzan los mínimos pedidos y 2 ante errores.
+func runModerateEval(args []string) int {
+	flags := flag.NewFlagSet("moderate-eval", flag.ContinueOnError)
+	corpusPath := flags.String("corpus", "", "corpus etiquetado (.csv con message,expected o .jsonl)")
+	strategyNames := flags.String("strategy", "", "estrategias registradas separadas por coma ("+strings.Join(strategies.Names(), ", ")+")")
+	policyPath := flags.String("policy", "", "archivo JSON de política a evaluar")
+	maxExamples := flags.Int("examples", 10, "cantidad máxima de falsos positivos a mostrar")
+	format := flags.String("format", "text", "formato de salida: text o json")
+	minAccuracy := flags.Float64("min-accuracy", 0, "exactitud mínima; por debajo el comando termina con código 1")
+	minPrecision := flags.Float64("min-precision", 0, "precisión mínima para cada acción distinta de allow")
+	minRecall := flags.Float64("min-recall", 0, "recall mínimo para cada acción distinta de allow")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	if *corpusPath == "" || (*strategyNames == "" && *policyPath == "") {
+		fmt.Fprintln(os.Stderr, "Uso: go-chat moderate-eval -corpus archivo [-strategy nombres] [-policy archivo.json]")
+		flags.PrintDefaults()
+		return 2
+	}
+
+	samples, err := LoadCorpus(*corpusPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error leyendo corpus: %v\n", err)
+		return 2
+	}
+
+	toEvaluate := []ModerationStrategy{}
+	if *strategyNames != "" {
+		for _, name := range strings.Split(*strategyNames, ",") {
+			strategy, err := strategies.Create(strings.TrimSpace(name))
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				return 2
+			}
+			toEvaluate = append(toEvaluate, strategy)
+		}
+	}
+	if *policyPath != "" {
+		strategy, err := LoadPolicyFile(*policyPath)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error leyendo política: %v\n", err)
+			return 2
+		}
+		toEvaluate = append(toEvaluate, strategy)
+	}
+
+	reports := []EvalReport{}
+	failed := false
+	for _, strategy := range toEvaluate {
+		report := EvaluateStrategy(strategy, samples, *maxExamples)
+		reports = append(reports, report)
+
+		if report.Accuracy < *minAccuracy {
+			fmt.Fprintf(os.Stderr, "%s: exactitud %.3f menor al mínimo %.3f\n", report.Strategy, report.Accuracy, *minAccuracy)
+			failed = true
+		}
+		for _, action := range report.Actions {
+			metrics := report.PerAction[action]
+			if action == "allow" {
+				continue
+			}
+			if metrics.Predicted > 0 && metrics.Precision < *minPrecision {
+				fmt.Fprintf(os.Stderr, "%s: precisión de %s %.3f menor al mínimo %.3f\n", report.Strategy, action, metrics.Precision, *minPrecision)
+				failed = true
+			}
+			if metrics.Support > 0 && metrics.Recall < *minRecall {
+				fmt.Fprintf(os.Stderr, "%s: recall de %s %.3f menor al mínimo %.3f\n", report.Strategy, action, metrics.Recall, *minRecall)
+				failed = true
+			}
+		}
+	}
+
+	if *format == "json" {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		encoder.Encode(reports)
+	} else {
+		for _, report := range reports {
+			report.Print(os.Stdout)
+		}
+	}
+
+	if failed {
+		return 1
+	}
+	return 0
+}
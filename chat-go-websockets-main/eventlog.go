@@ -0,0 +1,964 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// EventLogConfig configura el log de eventos en disco
+type EventLogConfig struct {
+	Disabled      bool        `json:"disabled"`
+	Dir           string      `json:"dir"`
+	SegmentBytes  int64       `json:"segment_bytes"`  // tamaño a partir del cual se abre un segmento nuevo
+	Retention     Duration    `json:"retention"`      // se borran los segmentos cuyo último evento es más viejo
+	MaxBytes      int64       `json:"max_bytes"`      // tamaño total máximo; se borran los segmentos más viejos
+	CompactAfter  Duration    `json:"compact_after"`  // edad a partir de la cual se compacta un segmento cerrado
+	CompactTypes  []EventType `json:"compact_types"`  // tipos de evento que se descartan al compactar
+	Sync          bool        `json:"sync"`           // fsync después de cada evento
+	CheckInterval Duration    `json:"check_interval"` // cada cuánto se aplican retención y compactación
+}
+
+func DefaultEventLogConfig() EventLogConfig {
+	return EventLogConfig{
+		Dir:           dataPath("events"),
+		SegmentBytes:  4 << 20,
+		Retention:     Duration(7 * 24 * time.Hour),
+		MaxBytes:      512 << 20,
+		CompactAfter:  Duration(time.Hour),
+		CompactTypes:  []EventType{UserJoinEvent, UserLeave},
+		CheckInterval: Duration(time.Minute),
+	}
+}
+
+// LoadEventLogConfig carga EVENT_LOG_CONFIG_FILE o usa la configuración por defecto
+func LoadEventLogConfig() EventLogConfig {
+	config := DefaultEventLogConfig()
+
+	path := os.Getenv("EVENT_LOG_CONFIG_FILE")
+	if path == "" {
+		return config
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		slog.Warn("could not read event log config", "path", path, "error", err)
+		return config
+	}
+	if err := json.Unmarshal(data, &config); err != nil {
+		slog.Warn("invalid event log config", "path", path, "error", err)
+		return DefaultEventLogConfig()
+	}
+	return config
+}
+
+// eventIndexEvery es cada cuántos eventos se guarda una posición en el índice de un segmento
+const eventIndexEvery = 256
+
+// indexEntry es la posición en bytes de un evento dentro de su segmento
+type indexEntry struct {
+	offset int64
+	pos    int64
+}
+
+// logSegment es un archivo del log. Su nombre es el offset del primer evento.
+type logSegment struct {
+	base      int64
+	last      int64 // último offset escrito; base-1 si está vacío
+	path      string
+	size      int64
+	firstTime time.Time
+	lastTime  time.Time
+	index     []indexEntry
+	count     int
+	compacted bool
+}
+
+func segmentPath(dir string, base int64) string {
+	return filepath.Join(dir, fmt.Sprintf("%020d.log", base))
+}
+
+// add actualiza los datos del segmento con un evento escrito en pos
+func (seg *logSegment) add(event Event, pos, length int64) {
+	if seg.count%eventIndexEvery == 0 {
+		seg.index = append(seg.index, indexEntry{offset: event.Offset, pos: pos})
+	}
+	if seg.count == 0 {
+		seg.firstTime = event.Timestamp
+	}
+	seg.count++
+	seg.last = event.Offset
+	seg.lastTime = event.Timestamp
+	seg.size = pos + length
+}
+
+// seekPos devuelve la posición desde la que conviene leer para llegar a offset
+func (seg *logSegment) seekPos(offset int64) int64 {
+	i := sort.Search(len(seg.index), func(i int) bool { return seg.index[i].offset > offset })
+	if i == 0 {
+		return 0
+	}
+	return seg.index[i-1].pos
+}
+
+// scanSegment lee un segmento y reconstruye sus datos. Devuelve el tamaño
+// válido: lo que sigue a la última línea completa es una escritura cortada.
+func scanSegment(seg *logSegment, removed map[string]bool) (int64, error) {
+	file, err := os.Open(seg.path)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	seg.last, seg.count, seg.index, seg.size = seg.base-1, 0, nil, 0
+	reader := bufio.NewReaderSize(file, 64*1024)
+	var pos int64
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			return pos, nil
+		}
+		if err != nil {
+			return pos, err
+		}
+		var event Event
+		if json.Unmarshal(line, &event) == nil && event.Offset > seg.last {
+			seg.add(event, pos, int64(len(line)))
+			if event.Type == MessageRemovedEvent && removed != nil {
+				if id, ok := event.Data["message_id"].(string); ok {
+					removed[id] = true
+				}
+			}
+		}
+		pos += int64(len(line))
+		seg.size = pos
+	}
+}
+
+// EventLog es un log de eventos de solo escritura al final, dividido en
+// segmentos. Cada evento recibe un offset creciente que no se reutiliza.
+type EventLog struct {
+	config   EventLogConfig
+	segments []*logSegment
+	active   *os.File
+	next     int64
+	removed  map[string]bool // mensajes eliminados, para compactar
+	changed  chan struct{}   // se cierra y reemplaza con cada evento nuevo
+	offsets  *OffsetStore
+	stopChan chan bool
+	mutex    sync.RWMutex
+}
+
+// OpenEventLog abre el log y lo recupera: carga los segmentos existentes y
+// descarta una escritura cortada al final del último
+func OpenEventLog(config EventLogConfig) (*EventLog, error) {
+	if err := os.MkdirAll(config.Dir, 0o755); err != nil {
+		return nil, err
+	}
+	el := &EventLog{
+		config:   config,
+		next:     1,
+		removed:  make(map[string]bool),
+		changed:  make(chan struct{}),
+		offsets:  NewOffsetStore(filepath.Join(config.Dir, "offsets.json")),
+		stopChan: make(chan bool),
+	}
+
+	paths, err := filepath.Glob(filepath.Join(config.Dir, "*.log"))
+	if err != nil {
+		return nil, err
+	}
+	for _, path := range paths {
+		base, err := strconv.ParseInt(strings.TrimSuffix(filepath.Base(path), ".log"), 10, 64)
+		if err != nil {
+			continue
+		}
+		el.segments = append(el.segments, &logSegment{base: base, path: path})
+	}
+	sort.Slice(el.segments, func(i, j int) bool { return el.segments[i].base < el.segments[j].base })
+
+	for i, seg := range el.segments {
+		valid, err := scanSegment(seg, el.removed)
+		if err != nil {
+			return nil, err
+		}
+		info, err := os.Stat(seg.path)
+		if err != nil {
+			return nil, err
+		}
+		if i == len(el.segments)-1 && info.Size() > valid {
+			componentLog("eventlog").Warn("discarding incomplete write at end of segment", "segment", filepath.Base(seg.path), "bytes", info.Size()-valid)
+			if err := os.Truncate(seg.path, valid); err != nil {
+				return nil, err
+			}
+			seg.size = valid
+		}
+		if seg.last >= el.next {
+			el.next = seg.last + 1
+		}
+	}
+	if err := el.openActive(); err != nil {
+		return nil, err
+	}
+	componentLog("eventlog").Info("event log opened", "segments", len(el.segments), "next_offset", el.next)
+	return el, nil
+}
+
+// openActive abre el último segmento para escribir, o crea uno
+func (el *EventLog) openActive() error {
+	if len(el.segments) == 0 {
+		el.segments = append(el.segments, &logSegment{base: el.next, last: el.next - 1, path: segmentPath(el.config.Dir, el.next)})
+	}
+	seg := el.segments[len(el.segments)-1]
+	file, err := os.OpenFile(seg.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	el.active = file
+	return nil
+}
+
+// roll cierra el segmento activo y abre uno nuevo; se llama con el lock tomado
+func (el *EventLog) roll() error {
+	if err := el.active.Close(); err != nil {
+		return err
+	}
+	el.segments = append(el.segments, &logSegment{base: el.next, last: el.next - 1, path: segmentPath(el.config.Dir, el.next)})
+	return el.openActive()
+}
+
+// Append escribe el evento y devuelve el evento con su offset
+func (el *EventLog) Append(event Event) (Event, error) {
+	el.mutex.Lock()
+	defer el.mutex.Unlock()
+
+	event.Offset = el.next
+	line, err := json.Marshal(event)
+	if err != nil {
+		return event, err
+	}
+	line = append(line, '\n')
+
+	seg := el.segments[len(el.segments)-1]
+	if seg.count > 0 && seg.size+int64(len(line)) > el.config.SegmentBytes {
+		if err := el.roll(); err != nil {
+			return event, err
+		}
+		seg = el.segments[len(el.segments)-1]
+	}
+	if _, err := el.active.Write(line); err != nil {
+		return event, err
+	}
+	if el.config.Sync {
+		el.active.Sync()
+	}
+
+	seg.add(event, seg.size, int64(len(line)))
+	el.next++
+	if event.Type == MessageRemovedEvent {
+		if id, ok := event.Data["message_id"].(string); ok {
+			el.removed[id] = true
+		}
+	}
+	close(el.changed)
+	el.changed = make(chan struct{})
+	return event, nil
+}
+
+// Changed devuelve un canal que se cierra cuando se escribe un evento nuevo
+func (el *EventLog) Changed() <-chan struct{} {
+	el.mutex.RLock()
+	defer el.mutex.RUnlock()
+	return el.changed
+}
+
+// Bounds devuelve el primer offset disponible y el próximo a escribir
+func (el *EventLog) Bounds() (int64, int64) {
+	el.mutex.RLock()
+	defer el.mutex.RUnlock()
+	for _, seg := range el.segments {
+		if seg.count > 0 {
+			return seg.index[0].offset, el.next
+		}
+	}
+	return el.next, el.next
+}
+
+// snapshot copia los datos de los segmentos para leer sin el lock
+func (el *EventLog) snapshot() []logSegment {
+	el.mutex.RLock()
+	defer el.mutex.RUnlock()
+	segments := make([]logSegment, len(el.segments))
+	for i, seg := range el.segments {
+		segments[i] = *seg
+	}
+	return segments
+}
+
+// Read devuelve hasta limit eventos con offset >= from que cumplen match (nil
+// = todos), y el offset desde el que seguir leyendo. Si from ya se borró por
+// retención, empieza por el primer evento disponible.
+func (el *EventLog) Read(from int64, limit int, match func(Event) bool) ([]Event, int64, error) {
+	events := []Event{}
+	next := from
+	for _, seg := range el.snapshot() {
+		if seg.count == 0 || seg.last < from {
+			continue
+		}
+		done, err := readSegment(seg, from, func(event Event) bool {
+			next = event.Offset + 1
+			if match == nil || match(event) {
+				events = append(events, event)
+			}
+			return limit <= 0 || len(events) < limit
+		})
+		if err != nil {
+			return events, next, err
+		}
+		if done {
+			break
+		}
+	}
+	return events, next, nil
+}
+
+// readSegment llama a fn con cada evento del segmento desde from, hasta que
+// fn devuelva false (done = true) o se termine el segmento
+func readSegment(seg logSegment, from int64, fn func(Event) bool) (bool, error) {
+	file, err := os.Open(seg.path)
+	if os.IsNotExist(err) {
+		return false, nil // borrado por retención mientras se leía
+	}
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
+
+	pos := seg.seekPos(from)
+	if _, err := file.Seek(pos, io.SeekStart); err != nil {
+		return false, err
+	}
+	// Solo se lee hasta el tamaño conocido: lo que siga puede estar a medio escribir
+	reader := bufio.NewReaderSize(io.LimitReader(file, seg.size-pos), 64*1024)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		var event Event
+		if json.Unmarshal(line, &event) != nil || event.Offset < from {
+			continue
+		}
+		if !fn(event) {
+			return true, nil
+		}
+	}
+}
+
+// OffsetAt devuelve el offset del primer evento con Timestamp >= since
+func (el *EventLog) OffsetAt(since time.Time) int64 {
+	for _, seg := range el.snapshot() {
+		if seg.count == 0 || seg.lastTime.Before(since) {
+			continue
+		}
+		offset := seg.last + 1
+		readSegment(seg, seg.base, func(event Event) bool {
+			if event.Timestamp.Before(since) {
+				return true
+			}
+			offset = event.Offset
+			return false
+		})
+		return offset
+	}
+	_, next := el.Bounds()
+	return next
+}
+
+// Tail devuelve los últimos n eventos que cumplen match, en orden
+func (el *EventLog) Tail(n int, match func(Event) bool) ([]Event, error) {
+	segments := el.snapshot()
+	result := []Event{}
+	for i := len(segments) - 1; i >= 0 && len(result) < n; i-- {
+		if segments[i].count == 0 {
+			continue
+		}
+		events := []Event{}
+		if _, err := readSegment(segments[i], segments[i].base, func(event Event) bool {
+			if match == nil || match(event) {
+				events = append(events, event)
+			}
+			return true
+		}); err != nil {
+			return result, err
+		}
+		result = append(events, result...)
+	}
+	if len(result) > n {
+		result = result[len(result)-n:]
+	}
+	return result, nil
+}
+
+// IsRemoved indica si un mensaje fue eliminado después de publicarse
+func (el *EventLog) IsRemoved(messageID string) bool {
+	el.mutex.RLock()
+	defer el.mutex.RUnlock()
+	return el.removed[messageID]
+}
+
+// Maintain aplica la retención y compacta los segmentos cerrados viejos
+func (el *EventLog) Maintain() {
+	now := time.Now()
+
+	el.mutex.Lock()
+	var total int64
+	for _, seg := range el.segments {
+		total += seg.size
+	}
+	kept := el.segments[:0]
+	for i, seg := range el.segments {
+		isActive := i == len(el.segments)-1
+		expired := el.config.Retention > 0 && seg.count > 0 && now.Sub(seg.lastTime) > time.Duration(el.config.Retention)
+		oversized := el.config.MaxBytes > 0 && total > el.config.MaxBytes
+		if !isActive && (expired || oversized || seg.count == 0) {
+			if err := os.Remove(seg.path); err != nil && !os.IsNotExist(err) {
+				componentLog("eventlog").Error("could not delete segment", "path", seg.path, "error", err)
+				kept = append(kept, seg)
+				continue
+			}
+			total -= seg.size
+			componentLog("eventlog").Info("segment deleted by retention", "segment", seg.base)
+			continue
+		}
+		kept = append(kept, seg)
+	}
+	el.segments = kept
+
+	// Los segmentos a compactar son cerrados, así que no cambian mientras se reescriben
+	pending := []*logSegment{}
+	for _, seg := range el.segments[:len(el.segments)-1] {
+		if !seg.compacted && el.config.CompactAfter > 0 && now.Sub(seg.lastTime) > time.Duration(el.config.CompactAfter) {
+			pending = append(pending, seg)
+		}
+	}
+	removed := make(map[string]bool, len(el.removed))
+	for id := range el.removed {
+		removed[id] = true
+	}
+	el.mutex.Unlock()
+
+	for _, seg := range pending {
+		if err := el.compact(seg, removed); err != nil {
+			componentLog("eventlog").Error("could not compact segment", "path", seg.path, "error", err)
+		}
+	}
+	el.offsets.Flush()
+}
+
+// compact reescribe un segmento cerrado sin los tipos de CompactTypes ni los
+// mensajes que después se eliminaron. Los offsets no cambian.
+func (el *EventLog) compact(seg *logSegment, removed map[string]bool) error {
+	tmp := seg.path + ".tmp"
+	out, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	writer := bufio.NewWriter(out)
+	compacted := &logSegment{base: seg.base, path: seg.path, compacted: true}
+	dropped := 0
+	_, err = readSegment(*seg, seg.base, func(event Event) bool {
+		if containsEventType(el.config.CompactTypes, event.Type) {
+			dropped++
+			return true
+		}
+		if event.Type == MessageEvent {
+			if id, ok := event.Data["message_id"].(string); ok && removed[id] {
+				dropped++
+				return true
+			}
+		}
+		line, _ := json.Marshal(event)
+		line = append(line, '\n')
+		compacted.add(event, compacted.size, int64(len(line)))
+		writer.Write(line)
+		return true
+	})
+	if err == nil {
+		err = writer.Flush()
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	// Se conservan el rango y la edad originales para la retención
+	compacted.last = seg.last
+	compacted.lastTime = seg.lastTime
+
+	el.mutex.Lock()
+	defer el.mutex.Unlock()
+	if err := os.Rename(tmp, seg.path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	*seg = *compacted
+	if dropped > 0 {
+		componentLog("eventlog").Info("segment compacted", "segment", seg.base, "dropped", dropped)
+	}
+	return nil
+}
+
+// StartMaintenance aplica retención y compactación periódicamente
+func (el *EventLog) StartMaintenance() {
+	interval := time.Duration(el.config.CheckInterval)
+	if interval <= 0 {
+		interval = time.Minute
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				el.Maintain()
+			case <-el.stopChan:
+				return
+			}
+		}
+	}()
+}
+
+// Close guarda los offsets y cierra el segmento activo
+func (el *EventLog) Close() error {
+	close(el.stopChan)
+	el.offsets.Flush()
+	el.mutex.Lock()
+	defer el.mutex.Unlock()
+	return el.active.Close()
+}
+
+// GetStats retorna el estado del log
+func (el *EventLog) GetStats() map[string]interface{} {
+	first, next := el.Bounds()
+	el.mutex.RLock()
+	defer el.mutex.RUnlock()
+	var size int64
+	for _, seg := range el.segments {
+		size += seg.size
+	}
+	return map[string]interface{}{
+		"segments":     len(el.segments),
+		"bytes":        size,
+		"first_offset": first,
+		"next_offset":  next,
+	}
+}
+
+// OffsetStore guarda el último offset procesado por cada suscriptor durable
+type OffsetStore struct {
+	path    string
+	offsets map[string]int64
+	dirty   bool
+	mutex   sync.Mutex
+}
+
+func NewOffsetStore(path string) *OffsetStore {
+	ofs := &OffsetStore{path: path, offsets: make(map[string]int64)}
+	if err := readJSONFile(path, &ofs.offsets); err != nil {
+		componentLog("eventlog").Error("could not load file", "path", path, "error", err)
+	}
+	return ofs
+}
+
+// Get devuelve el último offset procesado por el suscriptor
+func (ofs *OffsetStore) Get(id string) (int64, bool) {
+	ofs.mutex.Lock()
+	defer ofs.mutex.Unlock()
+	offset, exists := ofs.offsets[id]
+	return offset, exists
+}
+
+// Commit registra el último offset procesado; se guarda en disco en el próximo Flush
+func (ofs *OffsetStore) Commit(id string, offset int64) {
+	ofs.mutex.Lock()
+	defer ofs.mutex.Unlock()
+	if ofs.offsets[id] != offset {
+		ofs.offsets[id] = offset
+		ofs.dirty = true
+	}
+}
+
+// Remove olvida el offset de un suscriptor que ya no existe
+func (ofs *OffsetStore) Remove(id string) {
+	ofs.mutex.Lock()
+	defer ofs.mutex.Unlock()
+	if _, exists := ofs.offsets[id]; exists {
+		delete(ofs.offsets, id)
+		ofs.dirty = true
+	}
+}
+
+// All devuelve una copia de los offsets
+func (ofs *OffsetStore) All() map[string]int64 {
+	ofs.mutex.Lock()
+	defer ofs.mutex.Unlock()
+	all := make(map[string]int64, len(ofs.offsets))
+	for id, offset := range ofs.offsets {
+		all[id] = offset
+	}
+	return all
+}
+
+// Flush guarda los offsets si cambiaron
+func (ofs *OffsetStore) Flush() {
+	ofs.mutex.Lock()
+	defer ofs.mutex.Unlock()
+	if !ofs.dirty {
+		return
+	}
+	if err := writeJSONFile(ofs.path, ofs.offsets); err != nil {
+		componentLog("eventlog").Error("could not save file", "path", ofs.path, "error", err)
+		return
+	}
+	ofs.dirty = false
+}
+
+// durableSubscription es un observador que lee los eventos del log en lugar
+// de recibirlos en vivo. Recibe los eventos en orden, de a uno, y al volver a
+// suscribirse sigue desde el último offset procesado.
+type durableSubscription struct {
+	subscription
+	position int64      // próximo offset a leer
+	seek     chan int64 // pedidos para moverse a otro offset
+	stop     chan bool
+}
+
+// SubscribeDurable suscribe un observador que lee del log. Sigue desde su
+// último offset guardado; si es nuevo, empieza por el primer evento disponible.
+func (ep *EventPublisher) SubscribeDurable(observer Observer, filters ...EventFilter) error {
+	el := ep.EventLog()
+	if el == nil {
+		return errors.New("el log de eventos está desactivado")
+	}
+
+	start, _ := el.Bounds()
+	if committed, exists := el.offsets.Get(observer.GetID()); exists {
+		start = committed + 1
+	}
+	ds := &durableSubscription{
+		subscription: subscription{observer: observer, filters: filters},
+		position:     start,
+		seek:         make(chan int64, 1),
+		stop:         make(chan bool),
+	}
+
+	ep.mutex.Lock()
+	ep.remove(observer.GetID())
+	ep.durable[observer.GetID()] = ds
+	ep.mutex.Unlock()
+
+	go ds.run(el)
+	return nil
+}
+
+func (ds *durableSubscription) run(el *EventLog) {
+	id := ds.observer.GetID()
+	offset := atomic.LoadInt64(&ds.position)
+	for {
+		select {
+		case target := <-ds.seek:
+			offset = target
+		case <-ds.stop:
+			return
+		default:
+		}
+
+		changed := el.Changed()
+		events, next, err := el.Read(offset, 256, ds.matches)
+		if err != nil {
+			componentLog("eventlog").Error("could not read for durable subscriber", "subscriber", id, "error", err)
+		}
+		for _, event := range events {
+			// Al darse de baja no se entrega el resto del lote; los eventos
+			// entregados quedan confirmados de a uno
+			select {
+			case <-ds.stop:
+				return
+			default:
+			}
+			ds.observer.Update(event)
+			atomic.StoreInt64(&ds.position, event.Offset+1)
+			el.offsets.Commit(id, event.Offset)
+		}
+		if next > offset {
+			offset = next
+			atomic.StoreInt64(&ds.position, offset)
+			el.offsets.Commit(id, offset-1)
+			continue
+		}
+
+		select {
+		case <-changed:
+		case target := <-ds.seek:
+			offset = target
+			atomic.StoreInt64(&ds.position, offset)
+			el.offsets.Commit(id, offset-1)
+		case <-ds.stop:
+			return
+		}
+	}
+}
+
+// SetEventLog activa el log de eventos: cada evento se escribe en el log
+// antes de entregarse y recibe su offset
+func (ep *EventPublisher) SetEventLog(el *EventLog) {
+	ep.mutex.Lock()
+	defer ep.mutex.Unlock()
+	ep.eventLog = el
+}
+
+// EventLog devuelve el log de eventos, o nil si está desactivado
+func (ep *EventPublisher) EventLog() *EventLog {
+	ep.mutex.RLock()
+	defer ep.mutex.RUnlock()
+	return ep.eventLog
+}
+
+// Close detiene los suscriptores durables, se desconecta del broker y cierra
+// el log de eventos guardando los offsets. Se llama al apagar el servidor.
+func (ep *EventPublisher) Close() error {
+	ep.mutex.Lock()
+	for id, ds := range ep.durable {
+		close(ds.stop)
+		delete(ep.durable, id)
+	}
+	broker, el := ep.broker, ep.eventLog
+	ep.eventLog = nil
+	ep.mutex.Unlock()
+
+	if broker != nil {
+		if err := broker.Close(); err != nil {
+			componentLog("broker").Warn("could not close broker", "error", err)
+		}
+	}
+	if el == nil {
+		return nil
+	}
+	return el.Close()
+}
+
+// SeekDurable mueve un suscriptor durable a otro offset, para volver a procesar eventos
+func (ep *EventPublisher) SeekDurable(id string, offset int64) bool {
+	ep.mutex.RLock()
+	ds, exists := ep.durable[id]
+	ep.mutex.RUnlock()
+	if !exists {
+		return false
+	}
+	select {
+	case <-ds.seek: // reemplazar un pedido anterior que no se atendió
+	default:
+	}
+	ds.seek <- offset
+	return true
+}
+
+// DurableStats lista los suscriptores durables con su posición y atraso
+func (ep *EventPublisher) DurableStats() []map[string]interface{} {
+	el := ep.EventLog()
+	if el == nil {
+		return []map[string]interface{}{}
+	}
+	_, next := el.Bounds()
+	active := map[string]int64{}
+	connected := map[string]bool{}
+	ep.mutex.RLock()
+	for id, ds := range ep.durable {
+		active[id] = atomic.LoadInt64(&ds.position)
+		connected[id] = true
+	}
+	ep.mutex.RUnlock()
+
+	// También se listan los offsets guardados de suscriptores que no están conectados
+	stats := []map[string]interface{}{}
+	for id, committed := range el.offsets.All() {
+		if !connected[id] {
+			active[id] = committed + 1
+		}
+	}
+	for id, position := range active {
+		stats = append(stats, map[string]interface{}{
+			"id":        id,
+			"position":  position,
+			"lag":       next - position,
+			"connected": connected[id],
+		})
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i]["id"].(string) < stats[j]["id"].(string) })
+	return stats
+}
+
+// recoverMessages vuelve a cargar los últimos mensajes publicados desde el
+// log, para que se puedan seguir denunciando después de reiniciar
+func (s *Server) recoverMessages(el *EventLog) {
+	events, err := el.Tail(maxRecentMessages, EventFilter{Types: []EventType{MessageEvent}}.Matches)
+	if err != nil {
+		componentLog("eventlog").Warn("could not recover messages from event log", "error", err)
+	}
+	recovered := 0
+	for _, event := range events {
+		if id, _ := event.Data["message_id"].(string); el.IsRemoved(id) {
+			continue
+		}
+		if item, ok := reviewItemFromEvent(event); ok {
+			s.messages.Add(item)
+			recovered++
+		}
+	}
+	if recovered > 0 {
+		componentLog("eventlog").Info("messages recovered from event log", "messages", recovered)
+	}
+}
+
+// reviewItemFromEvent arma el ReviewItem de un mensaje publicado a partir de
+// su evento, que ya pasó por JSON (del log o de otra instancia)
+func reviewItemFromEvent(event Event) (ReviewItem, bool) {
+	id, _ := event.Data["message_id"].(string)
+	if id == "" {
+		return ReviewItem{}, false
+	}
+	data, err := json.Marshal(event.Data)
+	if err != nil {
+		return ReviewItem{}, false
+	}
+	var item ReviewItem
+	if err := json.Unmarshal(data, &item); err != nil {
+		return ReviewItem{}, false
+	}
+	item.ID = id
+	item.Username = event.Username
+	item.Room = event.Room
+	item.Message = event.Message
+	return item, true
+}
+
+// handleEvents devuelve eventos del log para que un cliente se ponga al día:
+// GET /events?room=general&from=120 o ?since=2024-01-01T10:00:00Z, con limit
+// y type opcionales. Solo incluye los eventos que vería una conexión de esa sala.
+func (s *Server) handleEvents(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Método no permitido", http.StatusMethodNotAllowed)
+		return
+	}
+	el := s.publisher.EventLog()
+	if el == nil {
+		http.Error(w, "El log de eventos está desactivado", http.StatusNotFound)
+		return
+	}
+
+	query := r.URL.Query()
+	from, _ := el.Bounds()
+	if value := query.Get("from"); value != "" {
+		offset, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			http.Error(w, "from inválido", http.StatusBadRequest)
+			return
+		}
+		from = offset
+	} else if value := query.Get("since"); value != "" {
+		since, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			http.Error(w, "since inválido (RFC3339)", http.StatusBadRequest)
+			return
+		}
+		from = el.OffsetAt(since)
+	}
+	limit := 100
+	if value, err := strconv.Atoi(query.Get("limit")); err == nil && value > 0 && value <= 1000 {
+		limit = value
+	}
+	room := query.Get("room")
+	if room == "" {
+		room = DefaultRoom
+	}
+	filter := EventFilter{Room: room}
+	if eventType := query.Get("type"); eventType != "" {
+		filter.Types = []EventType{EventType(eventType)}
+	}
+	// Los mensajes eliminados después de publicarse no se vuelven a entregar
+	visible := func(event Event) bool {
+		if !filter.Matches(event) {
+			return false
+		}
+		id, _ := event.Data["message_id"].(string)
+		return event.Type != MessageEvent || !el.IsRemoved(id)
+	}
+
+	events, next, err := el.Read(from, limit, visible)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	first, end := el.Bounds()
+	writeJSON(w, map[string]interface{}{
+		"events":       events,
+		"next_offset":  next,
+		"first_offset": first,
+		"end_offset":   end,
+	})
+}
+
+// handleEventSubscribers administra los suscriptores durables:
+// GET los lista y POST {"id": "...", "offset": 10} o {"id": "...", "since": "..."} los mueve
+func (s *Server) handleEventSubscribers(w http.ResponseWriter, r *http.Request) {
+	el := s.publisher.EventLog()
+	if el == nil {
+		http.Error(w, "El log de eventos está desactivado", http.StatusNotFound)
+		return
+	}
+	switch r.Method {
+	case "GET":
+		writeJSON(w, map[string]interface{}{
+			"log":         el.GetStats(),
+			"subscribers": s.publisher.DurableStats(),
+		})
+	case "POST":
+		var req struct {
+			ID     string    `json:"id"`
+			Offset int64     `json:"offset"`
+			Since  time.Time `json:"since"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "JSON inválido: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		offset := req.Offset
+		if !req.Since.IsZero() {
+			offset = el.OffsetAt(req.Since)
+		}
+		if !s.publisher.SeekDurable(req.ID, offset) {
+			http.Error(w, "Suscriptor no encontrado", http.StatusNotFound)
+			return
+		}
+		writeJSON(w, map[string]interface{}{"id": req.ID, "offset": offset})
+	default:
+		http.Error(w, "Método no permitido", http.StatusMethodNotAllowed)
+	}
+}
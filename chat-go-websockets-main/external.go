@@ -0,0 +1,382 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Políticas ante una falla del servicio externo
+const (
+	FailOpen   = "open"   // dejar pasar el mensaje
+	FailClosed = "closed" // bloquear el mensaje
+)
+
+// ExternalModerationConfig configura el servicio de clasificación externo
+type ExternalModerationConfig struct {
+	URL              string            `json:"url"`
+	Headers          map[string]string `json:"headers,omitempty"` // por ejemplo Authorization
+	Timeout          Duration          `json:"timeout"`
+	CacheTTL         Duration          `json:"cache_ttl"`         // 0 desactiva la caché
+	CacheSize        int               `json:"cache_size"`        // mensajes recordados
+	FailureThreshold int               `json:"failure_threshold"` // fallas seguidas para abrir el circuito
+	OpenDuration     Duration          `json:"open_duration"`     // tiempo con el circuito abierto antes de probar de nuevo
+	FailMode         string            `json:"fail_mode"`         // open | closed
+}
+
+func DefaultExternalModerationConfig() ExternalModerationConfig {
+	return ExternalModerationConfig{
+		Timeout:          Duration(300 * time.Millisecond),
+		CacheTTL:         Duration(5 * time.Minute),
+		CacheSize:        1000,
+		FailureThreshold: 5,
+		OpenDuration:     Duration(30 * time.Second),
+		FailMode:         FailOpen,
+	}
+}
+
+// LoadExternalModerationConfig carga EXTERNAL_MODERATION_FILE; EXTERNAL_MODERATION_URL
+// alcanza para usar los valores por defecto
+func LoadExternalModerationConfig() ExternalModerationConfig {
+	config := DefaultExternalModerationConfig()
+
+	if path := os.Getenv("EXTERNAL_MODERATION_FILE"); path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			slog.Warn("could not read external moderation config", "path", path, "error", err)
+		} else if err := json.Unmarshal(data, &config); err != nil {
+			slog.Warn("invalid external moderation config", "path", path, "error", err)
+			config = DefaultExternalModerationConfig()
+		}
+	}
+	if url := os.Getenv("EXTERNAL_MODERATION_URL"); url != "" {
+		config.URL = url
+	}
+	if config.FailMode != FailClosed {
+		config.FailMode = FailOpen
+	}
+	return config
+}
+
+// Estados del circuit breaker
+const (
+	CircuitClosed   = "closed"    // las llamadas pasan
+	CircuitOpen     = "open"      // las llamadas se cortan sin llamar al servicio
+	CircuitHalfOpen = "half_open" // se deja pasar una llamada de prueba
+)
+
+// CircuitBreaker corta las llamadas a un servicio que está fallando
+type CircuitBreaker struct {
+	threshold int
+	openFor   time.Duration
+	state     string
+	failures  int
+	openedAt  time.Time
+	probing   bool // hay una llamada de prueba en curso
+	mutex     sync.Mutex
+}
+
+func NewCircuitBreaker(threshold int, openFor time.Duration) *CircuitBreaker {
+	if threshold <= 0 {
+		threshold = 1
+	}
+	return &CircuitBreaker{
+		threshold: threshold,
+		openFor:   openFor,
+		state:     CircuitClosed,
+	}
+}
+
+// Allow dice si se puede llamar al servicio. Pasado openFor, deja pasar una
+// única llamada de prueba.
+func (cb *CircuitBreaker) Allow() bool {
+	cb.mutex.Lock()
+	defer cb.mutex.Unlock()
+	switch cb.state {
+	case CircuitOpen:
+		if time.Since(cb.openedAt) < cb.openFor {
+			return false
+		}
+		cb.state = CircuitHalfOpen
+		cb.probing = true
+		return true
+	case CircuitHalfOpen:
+		if cb.probing {
+			return false
+		}
+		cb.probing = true
+		return true
+	}
+	return true
+}
+
+// Success cierra el circuito
+func (cb *CircuitBreaker) Success() {
+	cb.mutex.Lock()
+	defer cb.mutex.Unlock()
+	if cb.state != CircuitClosed {
+		componentLog("external").Info("circuit closed, service responded")
+	}
+	cb.state = CircuitClosed
+	cb.failures = 0
+	cb.probing = false
+}
+
+// Failure suma una falla y abre el circuito al llegar al umbral o si falla la prueba
+func (cb *CircuitBreaker) Failure() {
+	cb.mutex.Lock()
+	defer cb.mutex.Unlock()
+	cb.failures++
+	cb.probing = false
+	if cb.state == CircuitHalfOpen || (cb.state == CircuitClosed && cb.failures >= cb.threshold) {
+		cb.state = CircuitOpen
+		cb.openedAt = time.Now()
+		componentLog("external").Warn("circuit opened", "failures", cb.failures)
+	}
+}
+
+// State devuelve el estado actual
+func (cb *CircuitBreaker) State() string {
+	cb.mutex.Lock()
+	defer cb.mutex.Unlock()
+	if cb.state == CircuitOpen && time.Since(cb.openedAt) >= cb.openFor {
+		return CircuitHalfOpen
+	}
+	return cb.state
+}
+
+// cachedResponse es una respuesta del servicio guardada en la caché
+type cachedResponse struct {
+	response  strategyResponse
+	expiresAt time.Time
+}
+
+// ExternalModerator llama al servicio externo. Es compartido por todas las
+// instancias de la estrategia para que la caché y el circuito no se pierdan al
+// cambiarla o evaluarla en modo sombra.
+type ExternalModerator struct {
+	config  ExternalModerationConfig
+	client  *http.Client
+	breaker *CircuitBreaker
+	cache   map[uint64]cachedResponse
+	order   []uint64 // orden de inserción, para descartar las más viejas
+
+	calls          int64
+	cacheHits      int64
+	failures       int64
+	shortCircuited int64
+	totalTime      time.Duration
+	mutex          sync.Mutex
+}
+
+func NewExternalModerator(config ExternalModerationConfig) *ExternalModerator {
+	return &ExternalModerator{
+		config:  config,
+		client:  &http.Client{Timeout: time.Duration(config.Timeout)},
+		breaker: NewCircuitBreaker(config.FailureThreshold, time.Duration(config.OpenDuration)),
+		cache:   make(map[uint64]cachedResponse),
+	}
+}
+
+var (
+	externalModerator     *ExternalModerator
+	externalModeratorOnce sync.Once
+)
+
+// sharedExternalModerator carga la configuración la primera vez
+func sharedExternalModerator() *ExternalModerator {
+	externalModeratorOnce.Do(func() {
+		externalModerator = NewExternalModerator(LoadExternalModerationConfig())
+	})
+	return externalModerator
+}
+
+// cacheKey identifica un mensaje repetido: mismo texto, sala e idioma
+func cacheKey(message string, ctx MessageContext) uint64 {
+	hash := fnv.New64a()
+	hash.Write([]byte(normalizeForModeration(message)))
+	hash.Write([]byte{0})
+	hash.Write([]byte(ctx.Room))
+	hash.Write([]byte{0})
+	hash.Write([]byte(ctx.Language))
+	return hash.Sum64()
+}
+
+func (em *ExternalModerator) cached(key uint64) (strategyResponse, bool) {
+	em.mutex.Lock()
+	defer em.mutex.Unlock()
+	entry, exists := em.cache[key]
+	if !exists || time.Now().After(entry.expiresAt) {
+		return strategyResponse{}, false
+	}
+	em.cacheHits++
+	return entry.response, true
+}
+
+func (em *ExternalModerator) store(key uint64, response strategyResponse) {
+	if em.config.CacheTTL <= 0 || em.config.CacheSize <= 0 {
+		return
+	}
+	em.mutex.Lock()
+	defer em.mutex.Unlock()
+	if _, exists := em.cache[key]; !exists {
+		em.order = append(em.order, key)
+	}
+	em.cache[key] = cachedResponse{
+		response:  response,
+		expiresAt: time.Now().Add(time.Duration(em.config.CacheTTL)),
+	}
+	for len(em.order) > em.config.CacheSize {
+		delete(em.cache, em.order[0])
+		em.order = em.order[1:]
+	}
+}
+
+// Classify devuelve la respuesta del servicio, de la caché si el mensaje ya se
+// clasificó. Si el circuito está abierto no llama al servicio.
+func (em *ExternalModerator) Classify(message string, ctx MessageContext) (strategyResponse, error) {
+	key := cacheKey(message, ctx)
+	if response, hit := em.cached(key); hit {
+		return response, nil
+	}
+	if !em.breaker.Allow() {
+		em.mutex.Lock()
+		em.shortCircuited++
+		em.mutex.Unlock()
+		return strategyResponse{}, errors.New("circuito abierto")
+	}
+
+	start := time.Now()
+	response, err := em.call(message, ctx)
+	em.mutex.Lock()
+	em.calls++
+	em.totalTime += time.Since(start)
+	if err != nil {
+		em.failures++
+	}
+	em.mutex.Unlock()
+
+	if err != nil {
+		em.breaker.Failure()
+		return response, err
+	}
+	em.breaker.Success()
+	em.store(key, response)
+	return response, nil
+}
+
+// call hace el POST al servicio con el mensaje y su contexto
+func (em *ExternalModerator) call(message string, ctx MessageContext) (strategyResponse, error) {
+	var response strategyResponse
+	body, err := json.Marshal(map[string]interface{}{
+		"message": message,
+		"context": ctx,
+	})
+	if err != nil {
+		return response, err
+	}
+
+	req, err := http.NewRequest("POST", em.config.URL, bytes.NewReader(body))
+	if err != nil {
+		return response, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for name, value := range em.config.Headers {
+		req.Header.Set(name, value)
+	}
+
+	resp, err := em.client.Do(req)
+	if err != nil {
+		return response, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return response, fmt.Errorf("el servicio respondió %s", resp.Status)
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&response); err != nil {
+		return response, fmt.Errorf("respuesta inválida: %v", err)
+	}
+	return response, response.validate()
+}
+
+// GetStats retorna las métricas del servicio externo
+func (em *ExternalModerator) GetStats() map[string]interface{} {
+	em.mutex.Lock()
+	defer em.mutex.Unlock()
+	average := time.Duration(0)
+	if em.calls > 0 {
+		average = em.totalTime / time.Duration(em.calls)
+	}
+	return map[string]interface{}{
+		"circuit":         em.breaker.State(),
+		"calls":           em.calls,
+		"failures":        em.failures,
+		"cache_hits":      em.cacheHits,
+		"cached_messages": len(em.cache),
+		"short_circuited": em.shortCircuited,
+		"average_latency": average.String(),
+		"fail_mode":       em.config.FailMode,
+	}
+}
+
+// ExternalModerationStrategy modera con un servicio HTTP externo
+type ExternalModerationStrategy struct {
+	moderator *ExternalModerator
+}
+
+func NewExternalModerationStrategy() *ExternalModerationStrategy {
+	return &ExternalModerationStrategy{moderator: sharedExternalModerator()}
+}
+
+func (es *ExternalModerationStrategy) Moderate(message string) ModerationResult {
+	return es.ModerateWithContext(message, MessageContext{})
+}
+
+func (es *ExternalModerationStrategy) ModerateWithContext(message string, ctx MessageContext) ModerationResult {
+	response, err := es.moderator.Classify(message, ctx)
+	if err == nil {
+		return response.toResult(message, es.GetName(), ctx)
+	}
+
+	componentLog("external").Warn("moderation service failed", "error", err, "message_id", ctx.MessageID)
+	result := ModerationResult{
+		OriginalMessage: message,
+		ModifiedMessage: message,
+		Action:          "allow",
+		Reason:          "External moderation unavailable, message allowed: " + err.Error(),
+		Confidence:      0.0,
+		Timestamp:       time.Now(),
+		StrategyUsed:    es.GetName(),
+		Language:        ctx.Language,
+		Fallback:        true,
+	}
+	if es.moderator.config.FailMode == FailClosed {
+		result.Action = "block"
+		result.ModifiedMessage = ""
+		result.Reason = "External moderation unavailable, message blocked: " + err.Error()
+	}
+	return result
+}
+
+func (es *ExternalModerationStrategy) GetName() string {
+	return "External"
+}
+
+// registerExternalStrategy registra "external" si hay un servicio configurado
+func registerExternalStrategy() {
+	if strings.TrimSpace(sharedExternalModerator().config.URL) == "" {
+		return
+	}
+	strategies.Register("external", func() ModerationStrategy {
+		return NewExternalModerationStrategy()
+	})
+}
@@ -0,0 +1,285 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newTestExternalStrategy crea la estrategia contra un servicio de prueba, sin
+// la configuración compartida del proceso
+func newTestExternalStrategy(t *testing.T, handler http.HandlerFunc, configure func(*ExternalModerationConfig)) (*ExternalModerationStrategy, *int64) {
+	t.Helper()
+	var requests int64
+	service := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&requests, 1)
+		handler(w, r)
+	}))
+	t.Cleanup(service.Close)
+
+	config := DefaultExternalModerationConfig()
+	config.URL = service.URL
+	config.Timeout = Duration(100 * time.Millisecond)
+	config.CacheTTL = 0
+	if configure != nil {
+		configure(&config)
+	}
+	return &ExternalModerationStrategy{moderator: NewExternalModerator(config)}, &requests
+}
+
+// respondWith contesta siempre con el mismo resultado
+func respondWith(response map[string]interface{}) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(response)
+	}
+}
+
+func TestExternalModerationUsesServiceResult(t *testing.T) {
+	var received map[string]interface{}
+	strategy, _ := newTestExternalStrategy(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer secreto" {
+			http.Error(w, "sin credenciales", http.StatusUnauthorized)
+			return
+		}
+		json.NewDecoder(r.Body).Decode(&received)
+		respondWith(map[string]interface{}{
+			"action":           "modify",
+			"modified_message": "hola ***",
+			"reason":           "insulto",
+			"confidence":       0.9,
+			"matched_terms":    []string{"tonto"},
+		})(w, r)
+	}, func(config *ExternalModerationConfig) {
+		config.Headers = map[string]string{"Authorization": "Bearer secreto"}
+	})
+
+	result := strategy.ModerateWithContext("hola tonto", MessageContext{Username: "ana", Room: "general"})
+	if result.Action != "modify" || result.ModifiedMessage != "hola ***" {
+		t.Fatalf("resultado inesperado: %+v", result)
+	}
+	if result.StrategyUsed != "External" {
+		t.Errorf("StrategyUsed = %q, se esperaba External", result.StrategyUsed)
+	}
+	if received["message"] != "hola tonto" {
+		t.Errorf("el servicio recibió %v", received)
+	}
+	if context, _ := received["context"].(map[string]interface{}); context["room"] != "general" {
+		t.Errorf("el servicio no recibió el contexto: %v", received["context"])
+	}
+}
+
+func TestExternalModerationFallback(t *testing.T) {
+	tests := []struct {
+		name     string
+		handler  http.HandlerFunc
+		failMode string
+		action   string
+	}{
+		{
+			name: "timeout con fail open",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				time.Sleep(300 * time.Millisecond)
+				respondWith(map[string]interface{}{"action": "block"})(w, r)
+			},
+			failMode: FailOpen,
+			action:   "allow",
+		},
+		{
+			name: "timeout con fail closed",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				time.Sleep(300 * time.Millisecond)
+				respondWith(map[string]interface{}{"action": "allow"})(w, r)
+			},
+			failMode: FailClosed,
+			action:   "block",
+		},
+		{
+			name: "error 500",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				http.Error(w, "falla", http.StatusInternalServerError)
+			},
+			failMode: FailOpen,
+			action:   "allow",
+		},
+		{
+			name: "error 503 con fail closed",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				http.Error(w, "sin servicio", http.StatusServiceUnavailable)
+			},
+			failMode: FailClosed,
+			action:   "block",
+		},
+		{
+			name:     "acción desconocida",
+			handler:  respondWith(map[string]interface{}{"action": "borrar"}),
+			failMode: FailOpen,
+			action:   "allow",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			strategy, _ := newTestExternalStrategy(t, test.handler, func(config *ExternalModerationConfig) {
+				config.FailMode = test.failMode
+			})
+
+			result := strategy.Moderate("hola")
+			if result.Action != test.action {
+				t.Fatalf("Action = %q, se esperaba %q (%s)", result.Action, test.action, result.Reason)
+			}
+			if !strings.Contains(result.Reason, "External moderation unavailable") {
+				t.Errorf("Reason = %q, se esperaba el motivo de la falla", result.Reason)
+			}
+			if test.action == "block" && result.ModifiedMessage != "" {
+				t.Errorf("un mensaje bloqueado no debería tener texto: %q", result.ModifiedMessage)
+			}
+			if failures := strategy.moderator.GetStats()["failures"]; failures != int64(1) {
+				t.Errorf("failures = %v, se esperaba 1", failures)
+			}
+		})
+	}
+}
+
+func TestExternalModerationCircuitBreaker(t *testing.T) {
+	var healthy int32
+	strategy, requests := newTestExternalStrategy(t, func(w http.ResponseWriter, r *http.Request) {
+		if atomic.LoadInt32(&healthy) == 0 {
+			http.Error(w, "falla", http.StatusInternalServerError)
+			return
+		}
+		respondWith(map[string]interface{}{"action": "allow", "reason": "ok"})(w, r)
+	}, func(config *ExternalModerationConfig) {
+		config.FailureThreshold = 2
+		config.OpenDuration = Duration(100 * time.Millisecond)
+	})
+	moderator := strategy.moderator
+
+	// Dos fallas seguidas abren el circuito
+	strategy.Moderate("uno")
+	strategy.Moderate("dos")
+	if state := moderator.breaker.State(); state != CircuitOpen {
+		t.Fatalf("estado = %q después de 2 fallas, se esperaba %q", state, CircuitOpen)
+	}
+
+	// Con el circuito abierto no se llama al servicio
+	result := strategy.Moderate("tres")
+	if got := atomic.LoadInt64(requests); got != 2 {
+		t.Fatalf("el servicio recibió %d pedidos, se esperaban 2", got)
+	}
+	if result.Action != "allow" || !strings.Contains(result.Reason, "circuito abierto") {
+		t.Errorf("resultado con el circuito abierto: %+v", result)
+	}
+	if shortCircuited := moderator.GetStats()["short_circuited"]; shortCircuited != int64(1) {
+		t.Errorf("short_circuited = %v, se esperaba 1", shortCircuited)
+	}
+
+	// Pasado open_duration se deja pasar una prueba; si falla, el circuito vuelve a abrirse
+	time.Sleep(150 * time.Millisecond)
+	if state := moderator.breaker.State(); state != CircuitHalfOpen {
+		t.Fatalf("estado = %q, se esperaba %q", state, CircuitHalfOpen)
+	}
+	strategy.Moderate("cuatro")
+	if got := atomic.LoadInt64(requests); got != 3 {
+		t.Fatalf("el servicio recibió %d pedidos, se esperaban 3", got)
+	}
+	if state := moderator.breaker.State(); state != CircuitOpen {
+		t.Fatalf("estado = %q después de fallar la prueba, se esperaba %q", state, CircuitOpen)
+	}
+
+	// Con el servicio recuperado, la prueba cierra el circuito
+	atomic.StoreInt32(&healthy, 1)
+	time.Sleep(150 * time.Millisecond)
+	if result := strategy.Moderate("cinco"); result.Reason != "ok" {
+		t.Fatalf("la prueba no usó el servicio: %+v", result)
+	}
+	if state := moderator.breaker.State(); state != CircuitClosed {
+		t.Fatalf("estado = %q después de la prueba, se esperaba %q", state, CircuitClosed)
+	}
+	strategy.Moderate("seis")
+	if got := atomic.LoadInt64(requests); got != 5 {
+		t.Errorf("el servicio recibió %d pedidos, se esperaban 5", got)
+	}
+}
+
+func TestCircuitBreakerAllowsSingleProbe(t *testing.T) {
+	breaker := NewCircuitBreaker(1, 10*time.Millisecond)
+	breaker.Failure()
+	if breaker.Allow() {
+		t.Fatal("el circuito abierto dejó pasar una llamada")
+	}
+
+	time.Sleep(20 * time.Millisecond)
+	if !breaker.Allow() {
+		t.Fatal("pasado open_duration debería pasar una llamada de prueba")
+	}
+	if breaker.Allow() {
+		t.Fatal("con una prueba en curso no debería pasar otra llamada")
+	}
+	breaker.Success()
+	if !breaker.Allow() || !breaker.Allow() {
+		t.Fatal("el circuito cerrado debería dejar pasar todas las llamadas")
+	}
+}
+
+func TestExternalModerationCache(t *testing.T) {
+	strategy, requests := newTestExternalStrategy(t, respondWith(map[string]interface{}{"action": "allow"}), func(config *ExternalModerationConfig) {
+		config.CacheTTL = Duration(time.Minute)
+		config.CacheSize = 1
+	})
+
+	ctx := MessageContext{Room: "general"}
+	strategy.ModerateWithContext("Hola  Mundo", ctx)
+	strategy.ModerateWithContext("hola mundo", ctx)
+	if got := atomic.LoadInt64(requests); got != 1 {
+		t.Fatalf("el servicio recibió %d pedidos, se esperaba 1 (el segundo sale de la caché)", got)
+	}
+
+	// Otra sala es otra clave; con cache_size 1 descarta la anterior
+	strategy.ModerateWithContext("hola mundo", MessageContext{Room: "otra"})
+	strategy.ModerateWithContext("hola mundo", ctx)
+	if got := atomic.LoadInt64(requests); got != 3 {
+		t.Errorf("el servicio recibió %d pedidos, se esperaban 3", got)
+	}
+}
+
+func TestExternalOutageDoesNotSanction(t *testing.T) {
+	strategy, _ := newTestExternalStrategy(t, func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "sin servicio", http.StatusServiceUnavailable)
+	}, func(config *ExternalModerationConfig) {
+		config.FailMode = FailClosed
+		config.FailureThreshold = 100
+	})
+	sanctions := NewSanctionManager(DefaultStrikePolicy(), filepath.Join(t.TempDir(), "sanctions.json"))
+	reviewConfig := DefaultReviewConfig()
+	reviewConfig.Enabled = true
+	reviewConfig.Rules = []ReviewRule{{Name: "todo", Actions: []string{"block"}, Mode: ReviewHold}}
+	review := NewReviewQueue(reviewConfig, nil)
+
+	// Sola y dentro de una compuesta, la falla bloquea pero no es un veredicto
+	for _, moderation := range []ModerationStrategy{strategy, NewNamedCompositeStrategy("con-externa", strategy)} {
+		for i := 0; i < 10; i++ {
+			result := moderateWithContext(moderation, "hola", MessageContext{Username: "ana"})
+			if result.Action != "block" || !result.Fallback {
+				t.Fatalf("%s: resultado inesperado durante la caída: %+v", moderation.GetName(), result)
+			}
+			if sanction := sanctions.RecordResult("ana", "ses_ana", result); sanction != nil {
+				t.Fatalf("%s: la caída del servicio sancionó al usuario: %+v", moderation.GetName(), sanction)
+			}
+			if _, matched := review.Match(result); matched {
+				t.Fatalf("%s: un resultado de fallback no debería ir a revisión", moderation.GetName())
+			}
+		}
+	}
+	if strikes := sanctions.GetStrikes("ana", "ses_ana"); strikes != 0 {
+		t.Errorf("strikes = %v durante la caída, se esperaba 0", strikes)
+	}
+	if active := sanctions.ActiveSanctions(); len(active) != 0 {
+		t.Errorf("sanciones durante la caída: %+v", active)
+	}
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"encoding/json"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Límites de FirstSeenStore: los usuarios que no escriben hace más de
+// firstSeenRetention se olvidan (si vuelven, cuentan como nuevos) y nunca se
+// guardan más de maxFirstSeenUsers; al pasarse se olvidan los más inactivos.
+const (
+	firstSeenRetention   = 90 * 24 * time.Hour
+	maxFirstSeenUsers    = 100000
+	firstSeenSaveDelay   = 5 * time.Second
+	firstSeenLastSeenGap = time.Hour // cada cuánto se actualiza la última actividad
+)
+
+// firstSeenEntry guarda el primer mensaje de un usuario y su última actividad
+type firstSeenEntry struct {
+	First time.Time `json:"first"`
+	Last  time.Time `json:"last"`
+}
+
+// UnmarshalJSON acepta también el formato anterior del archivo, que solo
+// guardaba la hora del primer mensaje
+func (e *firstSeenEntry) UnmarshalJSON(data []byte) error {
+	var first time.Time
+	if err := json.Unmarshal(data, &first); err == nil {
+		e.First, e.Last = first, time.Now()
+		return nil
+	}
+	type plain firstSeenEntry
+	return json.Unmarshal(data, (*plain)(e))
+}
+
+// FirstSeenStore recuerda cuándo escribió cada usuario por primera vez, para
+// las heurísticas de cuentas nuevas. Se guarda en data/first_seen.json para
+// que reiniciar el servidor no vuelva "nuevos" a todos los usuarios; el
+// archivo se escribe en segundo plano, a lo sumo una vez cada pocos segundos.
+type FirstSeenStore struct {
+	users     map[string]firstSeenEntry // usuario normalizado -> primer mensaje y última actividad
+	lastPrune time.Time
+	saver     *jsonSaver
+	mutex     sync.Mutex
+}
+
+func NewFirstSeenStore(path string) *FirstSeenStore {
+	fs := &FirstSeenStore{users: make(map[string]firstSeenEntry), lastPrune: time.Now()}
+	if err := readJSONFile(path, &fs.users); err != nil {
+		componentLog("firstseen").Error("could not load file", "path", path, "error", err)
+	}
+	fs.saver = newJSONSaver(path, "firstseen", firstSeenSaveDelay, fs.snapshot)
+	return fs
+}
+
+// Touch devuelve cuándo escribió el usuario por primera vez y, si es la
+// primera vez, lo registra con la hora indicada
+func (fs *FirstSeenStore) Touch(username string, now time.Time) time.Time {
+	key := normalizeUsername(username)
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+
+	entry, exists := fs.users[key]
+	if exists && now.Sub(entry.Last) < firstSeenLastSeenGap {
+		return entry.First
+	}
+	if !exists {
+		entry.First = now
+	}
+	entry.Last = now
+	fs.users[key] = entry
+	if len(fs.users) > maxFirstSeenUsers || now.Sub(fs.lastPrune) > firstSeenLastSeenGap {
+		fs.prune(now)
+	}
+	fs.saver.MarkDirty()
+	return entry.First
+}
+
+// Get devuelve cuándo escribió el usuario por primera vez, sin registrarlo
+func (fs *FirstSeenStore) Get(username string) (time.Time, bool) {
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+	entry, exists := fs.users[normalizeUsername(username)]
+	return entry.First, exists
+}
+
+// prune olvida a los usuarios inactivos y, si todavía sobran, a los que
+// llevan más tiempo sin escribir. Se llama con el mutex tomado.
+func (fs *FirstSeenStore) prune(now time.Time) {
+	fs.lastPrune = now
+	for key, entry := range fs.users {
+		if now.Sub(entry.Last) > firstSeenRetention {
+			delete(fs.users, key)
+		}
+	}
+	if len(fs.users) <= maxFirstSeenUsers {
+		return
+	}
+	keys := make([]string, 0, len(fs.users))
+	for key := range fs.users {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool { return fs.users[keys[i]].Last.Before(fs.users[keys[j]].Last) })
+	for _, key := range keys[:len(keys)-maxFirstSeenUsers] {
+		delete(fs.users, key)
+	}
+	componentLog("firstseen").Warn("first seen store full, forgot least active users", "limit", maxFirstSeenUsers)
+}
+
+// snapshot copia los usuarios para guardarlos sin el mutex tomado
+func (fs *FirstSeenStore) snapshot() interface{} {
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+	users := make(map[string]firstSeenEntry, len(fs.users))
+	for key, entry := range fs.users {
+		users[key] = entry
+	}
+	return users
+}
+
+// Close guarda los cambios pendientes
+func (fs *FirstSeenStore) Close() {
+	fs.saver.Close()
+}
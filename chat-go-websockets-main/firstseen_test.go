@@ -0,0 +1,37 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFirstSeenStoreSavesInBackground(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "first_seen.json")
+	// Archivo con el formato anterior: solo la hora del primer mensaje
+	if err := os.WriteFile(path, []byte(`{"ana": "2024-01-02T03:04:05Z"}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	store := NewFirstSeenStore(path)
+	if first, exists := store.Get("Ana"); !exists || first.Year() != 2024 {
+		t.Fatalf("no se leyó el formato anterior: %v %v", first, exists)
+	}
+
+	now := time.Now()
+	for _, username := range []string{"beto", "carla", "beto"} {
+		store.Touch(username, now)
+	}
+	if first := store.Touch("beto", now.Add(time.Minute)); !first.Equal(now) {
+		t.Errorf("Touch cambió el primer mensaje de beto: %v", first)
+	}
+	store.Close()
+
+	reloaded := NewFirstSeenStore(path)
+	defer reloaded.Close()
+	for _, username := range []string{"ana", "beto", "carla"} {
+		if _, exists := reloaded.Get(username); !exists {
+			t.Errorf("%s no se guardó al cerrar", username)
+		}
+	}
+}
@@ -3,12 +3,20 @@ const nicknameInput = document.getElementById("nicknameInput")
 const messageInput = document.getElementById("messageInput");
 const sendButton = document.getElementById("sendButton");
 
-let nickname = "Usuario"
+// El nombre se elige una vez por conexión; el servidor lo fija con el primer mensaje
+let nickname = ""
 
-function addChatBubble(message, isOwn, senderUsername) {
+function addChatBubble(message, isOwn, senderUsername, messageId, pending) {
     const messageBubble = document.createElement("div")
     const messageElement = document.createElement("div");
     messageBubble.className = `flex ${isOwn ? 'justify-end' : 'justify-start'} mb-4`;
+    if (messageId) {
+        messageBubble.dataset.messageId = messageId;
+    }
+    if (pending) {
+        // Mensaje publicado pero pendiente de revisión
+        messageBubble.classList.add("opacity-50");
+    }
     messageElement.className = isOwn
         ? 'bg-blue-500 text-white p-3 rounded-lg rounded-br-none shadow'
         : 'bg-gray-200 text-gray-800 p-3 rounded-lg rounded-bl-none shadow'
@@ -22,8 +30,18 @@ function addChatBubble(message, isOwn, senderUsername) {
     }
 
     const messageText = document.createElement("div");
+    messageText.className = "message-text";
     messageText.textContent = message;
     messageElement.appendChild(messageText);
+
+    // Permitir denunciar mensajes de otros usuarios
+    if (!isOwn && messageId) {
+        const reportLink = document.createElement("button");
+        reportLink.className = "text-xs text-red-500 hover:underline mt-1";
+        reportLink.textContent = "Denunciar";
+        reportLink.onclick = () => reportMessage(messageId);
+        messageElement.appendChild(reportLink);
+    }
     
     messageBubble.appendChild(messageElement)
     messagesDiv.appendChild(messageBubble);
@@ -41,11 +59,33 @@ function addSystemMessage(message) {
     messagesDiv.scrollTop = messagesDiv.scrollHeight;
 }
 
+function setBubbleHidden(messageId, hidden) {
+    const bubble = document.querySelector(`[data-message-id="${messageId}"]`);
+    if (bubble) {
+        bubble.querySelector(".message-text").classList.toggle("hidden", hidden);
+        bubble.classList.toggle("italic", hidden);
+        bubble.title = hidden ? "Mensaje oculto por denuncias, pendiente de revisión" : "";
+    }
+}
+
+function reportMessage(messageId) {
+    const reason = prompt("Motivo de la denuncia (spam, harassment, hate, sexual, violence, other):", "spam");
+    if (reason) {
+        ws.send(JSON.stringify({
+            username: nickname,
+            message: `/report ${messageId} ${reason}`,
+            timestamp: new Date().toISOString()
+        }));
+    }
+}
+
 nicknameInput.addEventListener("input", () => {
-    nickname = nicknameInput.value || "Usuario"
+    nickname = nicknameInput.value.trim()
 })
 
-const ws = new WebSocket("/ws");
+// La sesión la emite el servidor; guardarla conserva la identidad al recargar
+const session = localStorage.getItem("chatSession");
+const ws = new WebSocket(session ? `/ws?session=${encodeURIComponent(session)}` : "/ws");
 
 ws.onopen = () => {
     console.log("WebSocket connection established");
@@ -58,7 +98,17 @@ ws.onmessage = (event) => {
         
         switch(data.type) {
             case 'message':
-                addChatBubble(data.message, false, data.username);
+                addChatBubble(data.message, false, data.username, data.data?.message_id, data.data?.pending_review);
+                break;
+            case 'message_approved':
+                setBubbleHidden(data.data?.message_id, false);
+                document.querySelector(`[data-message-id="${data.data?.message_id}"]`)?.classList.remove("opacity-50");
+                break;
+            case 'message_hidden':
+                setBubbleHidden(data.data?.message_id, true);
+                break;
+            case 'message_removed':
+                document.querySelector(`[data-message-id="${data.data?.message_id}"]`)?.remove();
                 break;
             case 'user_join':
                 addSystemMessage(`${data.username || 'Usuario'} se conectó`);
@@ -66,7 +116,14 @@ ws.onmessage = (event) => {
             case 'user_leave':
                 addSystemMessage(`${data.username || 'Usuario'} se desconectó`);
                 break;
+            case 'session':
+                localStorage.setItem("chatSession", data.data.session);
+                break;
             case 'system':
+                if (data.data?.username_taken) {
+                    // El nombre lo usa otra sesión: dejar elegir otro
+                    nicknameInput.disabled = false;
+                }
                 addSystemMessage(data.message);
                 break;
             default:
@@ -88,6 +145,9 @@ function sendMessage() {
             timestamp: new Date().toISOString()
         };
         ws.send(JSON.stringify(messageData));
+        if (nickname) {
+            nicknameInput.disabled = true;
+        }
         addChatBubble(message, true, nickname)
         messageInput.value = "";
     }
@@ -0,0 +1,411 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// incomingHookMaxBytes es el tamaño máximo del cuerpo de un webhook entrante
+const incomingHookMaxBytes = 64 << 10
+
+// IncomingHook es una URL con token por la que un sistema externo (CI,
+// monitoreo) publica mensajes en una sala con una identidad de bot
+//
+//	{
+//	    "name": "ci",
+//	    "room": "deploys",
+//	    "rooms": ["guardia"],
+//	    "username": "ci-bot",
+//	    "rate_limit": {"messages": 30, "window": "1m"}
+//	}
+type IncomingHook struct {
+	ID        string          `json:"id"`
+	Name      string          `json:"name,omitempty"`
+	Token     string          `json:"token,omitempty"`
+	Room      string          `json:"room"`            // sala por defecto
+	Rooms     []string        `json:"rooms,omitempty"` // otras salas que se pueden elegir con "channel"
+	Username  string          `json:"username"`        // identidad del bot
+	RateLimit RateLimitConfig `json:"rate_limit"`
+	CreatedBy string          `json:"created_by,omitempty"`
+	CreatedAt time.Time       `json:"created_at"`
+}
+
+// public devuelve el webhook con el token oculto, para listarlo
+func (ih IncomingHook) public() IncomingHook {
+	if len(ih.Token) > 4 {
+		ih.Token = "…" + ih.Token[len(ih.Token)-4:]
+	}
+	return ih
+}
+
+// allowsRoom indica si el webhook puede publicar en la sala
+func (ih IncomingHook) allowsRoom(room string) bool {
+	return room == ih.Room || containsString(ih.Rooms, room)
+}
+
+// SlackPayload es el cuerpo que aceptan los webhooks entrantes, compatible
+// con los incoming webhooks de Slack. Solo se usan los campos de texto.
+type SlackPayload struct {
+	Text        string            `json:"text"`
+	Channel     string            `json:"channel,omitempty"`
+	Username    string            `json:"username,omitempty"`
+	IconEmoji   string            `json:"icon_emoji,omitempty"`
+	IconURL     string            `json:"icon_url,omitempty"`
+	Attachments []SlackAttachment `json:"attachments,omitempty"`
+}
+
+type SlackAttachment struct {
+	Fallback string `json:"fallback,omitempty"`
+	Pretext  string `json:"pretext,omitempty"`
+	Title    string `json:"title,omitempty"`
+	Text     string `json:"text,omitempty"`
+}
+
+// message arma el texto del mensaje: text y, si hay, el texto de los adjuntos
+func (sp SlackPayload) message() string {
+	parts := []string{}
+	if text := strings.TrimSpace(sp.Text); text != "" {
+		parts = append(parts, text)
+	}
+	for _, attachment := range sp.Attachments {
+		if attachment.Fallback != "" {
+			parts = append(parts, attachment.Fallback)
+			continue
+		}
+		for _, part := range []string{attachment.Pretext, attachment.Title, attachment.Text} {
+			if part = strings.TrimSpace(part); part != "" {
+				parts = append(parts, part)
+			}
+		}
+	}
+	return strings.Join(parts, "\n")
+}
+
+// decodeSlackPayload lee el cuerpo como JSON o, como Slack, como formulario
+// con el JSON en "payload". Un formulario sin "payload" se lee como JSON,
+// porque es lo que manda curl -d sin Content-Type.
+func decodeSlackPayload(r *http.Request) (SlackPayload, error) {
+	var payload SlackPayload
+	data, err := io.ReadAll(r.Body)
+	if err != nil {
+		return payload, err
+	}
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "application/x-www-form-urlencoded") {
+		if form, err := url.ParseQuery(string(data)); err == nil && form.Get("payload") != "" {
+			data = []byte(form.Get("payload"))
+		}
+	}
+	err = json.Unmarshal(data, &payload)
+	return payload, err
+}
+
+// incomingHookStats cuenta lo que pasó con los mensajes de un webhook entrante
+type incomingHookStats struct {
+	received    int64
+	published   int64
+	held        int64 // retenidos para revisión
+	rejected    int64
+	rateLimited int64
+	lastUsed    int64 // hora Unix en nanosegundos
+}
+
+func (ihs *incomingHookStats) snapshot() map[string]interface{} {
+	stats := map[string]interface{}{
+		"received":     atomic.LoadInt64(&ihs.received),
+		"published":    atomic.LoadInt64(&ihs.published),
+		"held":         atomic.LoadInt64(&ihs.held),
+		"rejected":     atomic.LoadInt64(&ihs.rejected),
+		"rate_limited": atomic.LoadInt64(&ihs.rateLimited),
+	}
+	if lastUsed := atomic.LoadInt64(&ihs.lastUsed); lastUsed > 0 {
+		stats["last_used"] = time.Unix(0, lastUsed)
+	}
+	return stats
+}
+
+// IncomingHookStore guarda los webhooks entrantes en data/hooks.json
+type IncomingHookStore struct {
+	path      string
+	rateLimit RateLimitConfig // límite para los webhooks que no indican uno
+	hooks     map[string]IncomingHook
+	byToken   map[string]string // token -> ID
+	stats     map[string]*incomingHookStats
+	nextID    int64
+	mutex     sync.RWMutex
+}
+
+func NewIncomingHookStore(path string, rateLimit RateLimitConfig) *IncomingHookStore {
+	ihs := &IncomingHookStore{
+		path:      path,
+		rateLimit: rateLimit,
+		hooks:     make(map[string]IncomingHook),
+		byToken:   make(map[string]string),
+		stats:     make(map[string]*incomingHookStats),
+	}
+	if err := readJSONFile(path, &ihs.hooks); err != nil {
+		componentLog("hooks").Error("could not load file", "path", path, "error", err)
+	}
+	for id, hook := range ihs.hooks {
+		ihs.byToken[hook.Token] = id
+		ihs.stats[id] = &incomingHookStats{}
+		if seq, err := strconv.ParseInt(strings.TrimPrefix(id, "ih_"), 10, 64); err == nil && seq > ihs.nextID {
+			ihs.nextID = seq
+		}
+	}
+	return ihs
+}
+
+// Create valida y guarda un webhook entrante con un token nuevo
+func (ihs *IncomingHookStore) Create(hook IncomingHook) (IncomingHook, error) {
+	hook.Name = strings.TrimSpace(hook.Name)
+	hook.Room = strings.TrimPrefix(strings.TrimSpace(hook.Room), "#")
+	if hook.Room == "" {
+		hook.Room = DefaultRoom
+	}
+	for i, room := range hook.Rooms {
+		hook.Rooms[i] = strings.TrimPrefix(strings.TrimSpace(room), "#")
+	}
+	hook.Username = strings.TrimSpace(hook.Username)
+	if hook.Username == "" {
+		hook.Username = hook.Name
+	}
+	if hook.Username == "" {
+		return hook, errors.New("el webhook necesita un nombre o un username para el bot")
+	}
+	if hook.RateLimit.Messages <= 0 || hook.RateLimit.Window <= 0 {
+		hook.RateLimit = ihs.rateLimit
+	}
+	token := make([]byte, 24)
+	rand.Read(token)
+	hook.Token = hex.EncodeToString(token)
+
+	ihs.mutex.Lock()
+	defer ihs.mutex.Unlock()
+	ihs.nextID++
+	hook.ID = fmt.Sprintf("ih_%d", ihs.nextID)
+	hook.CreatedAt = time.Now()
+	ihs.hooks[hook.ID] = hook
+	ihs.byToken[hook.Token] = hook.ID
+	ihs.stats[hook.ID] = &incomingHookStats{}
+	ihs.save()
+	return hook, nil
+}
+
+// Remove borra un webhook entrante; su URL deja de funcionar
+func (ihs *IncomingHookStore) Remove(id string) bool {
+	ihs.mutex.Lock()
+	defer ihs.mutex.Unlock()
+	hook, exists := ihs.hooks[id]
+	if !exists {
+		return false
+	}
+	delete(ihs.hooks, id)
+	delete(ihs.byToken, hook.Token)
+	delete(ihs.stats, id)
+	ihs.save()
+	return true
+}
+
+// ByToken busca el webhook de una URL
+func (ihs *IncomingHookStore) ByToken(token string) (IncomingHook, *incomingHookStats, bool) {
+	ihs.mutex.RLock()
+	defer ihs.mutex.RUnlock()
+	id, exists := ihs.byToken[token]
+	if !exists || token == "" {
+		return IncomingHook{}, nil, false
+	}
+	return ihs.hooks[id], ihs.stats[id], true
+}
+
+// List devuelve los webhooks entrantes, sin token, con sus estadísticas
+func (ihs *IncomingHookStore) List() []map[string]interface{} {
+	ihs.mutex.RLock()
+	defer ihs.mutex.RUnlock()
+	list := make([]map[string]interface{}, 0, len(ihs.hooks))
+	for id, hook := range ihs.hooks {
+		list = append(list, map[string]interface{}{
+			"hook":  hook.public(),
+			"stats": ihs.stats[id].snapshot(),
+		})
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i]["hook"].(IncomingHook).CreatedAt.Before(list[j]["hook"].(IncomingHook).CreatedAt)
+	})
+	return list
+}
+
+// save persiste los webhooks; se llama con el lock tomado
+func (ihs *IncomingHookStore) save() {
+	if err := writeJSONFile(ihs.path, ihs.hooks); err != nil {
+		componentLog("hooks").Error("could not save file", "path", ihs.path, "error", err)
+	}
+}
+
+// handleIncomingHook publica el mensaje de POST /hooks/{token}. El mensaje
+// pasa por el pipeline como el de cualquier conexión (sanciones, límite del
+// webhook, moderación, anotaciones). Responde en texto plano, como Slack:
+// "ok" si se publicó, o un código de error.
+func (s *Server) handleIncomingHook(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Método no permitido", http.StatusMethodNotAllowed)
+		return
+	}
+	hook, stats, exists := s.incoming.ByToken(strings.TrimPrefix(r.URL.Path, "/hooks/"))
+	if !exists {
+		http.Error(w, "no_service", http.StatusNotFound)
+		return
+	}
+	atomic.AddInt64(&stats.received, 1)
+	atomic.StoreInt64(&stats.lastUsed, time.Now().UnixNano())
+	logger := requestLogger(r).With("hook", hook.ID)
+
+	r.Body = http.MaxBytesReader(w, r.Body, incomingHookMaxBytes)
+	payload, err := decodeSlackPayload(r)
+	if err != nil {
+		atomic.AddInt64(&stats.rejected, 1)
+		logger.Info("invalid incoming hook payload", "error", err)
+		http.Error(w, "invalid_payload", http.StatusBadRequest)
+		return
+	}
+	text := payload.message()
+	if text == "" {
+		atomic.AddInt64(&stats.rejected, 1)
+		http.Error(w, "no_text", http.StatusBadRequest)
+		return
+	}
+	room := hook.Room
+	if channel := strings.TrimPrefix(payload.Channel, "#"); channel != "" {
+		if !hook.allowsRoom(channel) {
+			atomic.AddInt64(&stats.rejected, 1)
+			http.Error(w, "channel_not_allowed", http.StatusForbidden)
+			return
+		}
+		room = channel
+	}
+
+	// El bot es una conexión sin socket: los avisos que recibe no van a ningún lado.
+	// Su ID es el mismo en cada pedido, así el límite se cuenta por webhook.
+	ip := clientIP(r)
+	observer := NewConnectionObserver("hook:"+hook.ID, nil)
+	observer.SetUsername(hook.Username)
+	observer.SetRoom(room)
+	observer.SetIdentity(ip, "", false)
+
+	inbound := &InboundMessage{
+		Chat: ChatMessage{
+			ID:        s.newMessageID(),
+			Username:  hook.Username,
+			Message:   text,
+			Room:      room,
+			Timestamp: time.Now(),
+		},
+		Text:     text,
+		Observer: observer,
+		IP:       ip,
+		Hook:     &hook,
+	}
+	inbound.Annotate("bot", true)
+	inbound.Annotate("hook", hook.ID)
+	// El nombre que manda el sistema externo se muestra, pero no reemplaza la identidad del bot
+	if payload.Username != "" {
+		inbound.Annotate("display_name", payload.Username)
+	}
+	if payload.IconURL != "" {
+		inbound.Annotate("icon_url", payload.IconURL)
+	} else if payload.IconEmoji != "" {
+		inbound.Annotate("icon_emoji", payload.IconEmoji)
+	}
+
+	if s.pipeline.Process(inbound) != VerdictContinue {
+		logger.Info("incoming hook message rejected", "message_id", inbound.Chat.ID, "room", room, "reason", inbound.Rejection)
+		if inbound.Rejection == "rate_limited" {
+			atomic.AddInt64(&stats.rateLimited, 1)
+			w.Header().Set("Retry-After", strconv.Itoa(int(time.Duration(hook.RateLimit.Window).Seconds())))
+			http.Error(w, "rate_limited", http.StatusTooManyRequests)
+			return
+		}
+		atomic.AddInt64(&stats.rejected, 1)
+		http.Error(w, "message_rejected: "+inbound.Rejection, http.StatusForbidden)
+		return
+	}
+	if !s.publishInbound(inbound) {
+		atomic.AddInt64(&stats.held, 1)
+		w.WriteHeader(http.StatusAccepted)
+		w.Write([]byte("pending_review"))
+		return
+	}
+	atomic.AddInt64(&stats.published, 1)
+	logger.Debug("incoming hook message published", "message_id", inbound.Chat.ID, "room", room)
+	w.Write([]byte("ok"))
+}
+
+// handleIncomingHooks administra los webhooks entrantes:
+// GET los lista, POST crea uno y DELETE ?id=... lo borra.
+// La respuesta de POST es la única que incluye el token y la URL.
+func (s *Server) handleIncomingHooks(w http.ResponseWriter, r *http.Request) {
+	moderator := r.Header.Get("X-Moderator")
+	if moderator == "" {
+		moderator = "admin"
+	}
+
+	switch r.Method {
+	case "GET":
+		writeJSON(w, s.incoming.List())
+	case "POST":
+		var hook IncomingHook
+		if err := json.NewDecoder(r.Body).Decode(&hook); err != nil {
+			http.Error(w, "JSON inválido: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		hook.CreatedBy = moderator
+		hook, err := s.incoming.Create(hook)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		s.audit.Record(AuditEntry{
+			Kind:   AuditModeratorAction,
+			Actor:  moderator,
+			Action: "create_incoming_hook",
+			Target: hook.ID,
+			Room:   hook.Room,
+			Data: map[string]interface{}{
+				"username": hook.Username,
+				"rooms":    hook.Rooms,
+			},
+		})
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"hook": hook,
+			"url":  "/hooks/" + hook.Token,
+		})
+	case "DELETE":
+		id := r.URL.Query().Get("id")
+		if !s.incoming.Remove(id) {
+			http.Error(w, "Webhook no encontrado", http.StatusNotFound)
+			return
+		}
+		s.audit.Record(AuditEntry{
+			Kind:   AuditModeratorAction,
+			Actor:  moderator,
+			Action: "delete_incoming_hook",
+			Target: id,
+		})
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "Método no permitido", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"encoding/json"
+	"log/slog"
+	"math"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// LanguageUndetermined se usa cuando el mensaje es muy corto o ambiguo
+const LanguageUndetermined = "und"
+
+// Modos para elegir qué listas de palabras aplicar
+const (
+	LanguageModeDetected = "detected" // la lista del idioma detectado (todas si no se pudo detectar)
+	LanguageModeAll      = "all"      // todas las listas, sin importar el idioma
+)
+
+// languageSamples son textos de referencia con los que se arman los perfiles de trigramas
+var languageSamples = map[string]string{
+	"es": `hola como estas que tal el dia de hoy me gusta mucho este chat porque
+	la gente es muy amable y siempre hay alguien con quien hablar nos vemos mañana
+	en la tarde para jugar un partido de futbol con los amigos del barrio creo que
+	va a llover pero no importa vamos igual yo tambien quiero ir si hay lugar para
+	mi gracias por la ayuda con la tarea de matematicas no entendia nada de lo que
+	explico el profesor en la clase alguien sabe donde queda la estacion de tren
+	estoy buscando un trabajo nuevo porque el que tengo no me pagan lo suficiente
+	que piensas de la pelicula que vimos ayer a mi me parecio un poco larga pero
+	los actores estuvieron muy bien el fin de semana vamos a la playa con mi familia
+	esta es la mejor cancion del año no puedo dejar de escucharla todos los dias`,
+	"en": `hello how are you doing today i really like this chat because people are
+	very friendly and there is always someone to talk with see you tomorrow in the
+	afternoon to play a football match with the friends from the neighborhood i think
+	it is going to rain but it does not matter we will go anyway i also want to go if
+	there is room for me thanks for the help with the math homework i did not understand
+	anything the teacher explained in class does anyone know where the train station is
+	i am looking for a new job because the one i have does not pay enough what do you
+	think about the movie we watched yesterday it seemed a bit long to me but the actors
+	were very good this weekend we are going to the beach with my family this is the best
+	song of the year i cannot stop listening to it every day`,
+	"pt": `ola como voce esta tudo bem hoje eu gosto muito deste chat porque as pessoas
+	sao muito simpaticas e sempre tem alguem para conversar a gente se ve amanha a tarde
+	para jogar uma partida de futebol com os amigos do bairro acho que vai chover mas nao
+	importa vamos mesmo assim eu tambem quero ir se tiver lugar para mim obrigado pela
+	ajuda com a licao de matematica nao entendi nada do que o professor explicou na aula
+	alguem sabe onde fica a estacao de trem estou procurando um emprego novo porque o que
+	eu tenho nao paga o suficiente o que voce achou do filme que vimos ontem achei um
+	pouco longo mas os atores foram muito bem neste fim de semana vamos para a praia com
+	a minha familia esta e a melhor musica do ano nao consigo parar de ouvir todos os dias`,
+}
+
+// languageProfile son las frecuencias de trigramas de un idioma
+type languageProfile struct {
+	counts map[string]int
+	total  int
+}
+
+// LanguageDetector detecta el idioma de un mensaje con un modelo de trigramas de caracteres
+type LanguageDetector struct {
+	profiles   map[string]languageProfile
+	minLetters int     // letras mínimas para intentar detectar
+	minProb    float64 // probabilidad mínima para aceptar el idioma
+}
+
+func NewLanguageDetector(samples map[string]string) *LanguageDetector {
+	ld := &LanguageDetector{
+		profiles:   make(map[string]languageProfile),
+		minLetters: 8,
+		minProb:    0.6,
+	}
+	for language, text := range samples {
+		profile := languageProfile{counts: make(map[string]int)}
+		for _, gram := range languageTrigrams(text) {
+			profile.counts[gram]++
+			profile.total++
+		}
+		ld.profiles[language] = profile
+	}
+	return ld
+}
+
+// languageTrigrams extrae los trigramas de las palabras, sin acentos ni signos
+func languageTrigrams(text string) []string {
+	grams := []string{}
+	for _, word := range strings.FieldsFunc(strings.ToLower(text), func(r rune) bool { return !unicode.IsLetter(r) }) {
+		runes := []rune(" " + stripAccents(word) + " ")
+		for i := 0; i+3 <= len(runes); i++ {
+			grams = append(grams, string(runes[i:i+3]))
+		}
+	}
+	return grams
+}
+
+// stripAccents quita los acentos más comunes para que "está" y "esta" cuenten igual
+func stripAccents(word string) string {
+	return strings.NewReplacer(
+		"á", "a", "é", "e", "í", "i", "ó", "o", "ú", "u", "ü", "u",
+		"à", "a", "â", "a", "ã", "a", "ê", "e", "ô", "o", "õ", "o", "ç", "c",
+	).Replace(word)
+}
+
+// Detect devuelve el idioma más probable y su probabilidad, o "und" si el
+// mensaje es muy corto o ningún idioma supera el mínimo
+func (ld *LanguageDetector) Detect(text string) (string, float64) {
+	letters := 0
+	for _, r := range text {
+		if unicode.IsLetter(r) {
+			letters++
+		}
+	}
+	if letters < ld.minLetters || len(ld.profiles) == 0 {
+		return LanguageUndetermined, 0
+	}
+
+	// Las letras propias de un idioma son una señal fuerte
+	lower := strings.ToLower(text)
+	if strings.ContainsAny(lower, "ñ¿¡") {
+		if _, exists := ld.profiles["es"]; exists {
+			return "es", 1
+		}
+	}
+
+	grams := languageTrigrams(text)
+	scores := make(map[string]float64, len(ld.profiles))
+	best := math.Inf(-1)
+	for language, profile := range ld.profiles {
+		score := 0.0
+		for _, gram := range grams {
+			score += math.Log(float64(profile.counts[gram]+1) / float64(profile.total+len(profile.counts)))
+		}
+		scores[language] = score
+		best = math.Max(best, score)
+	}
+
+	sum := 0.0
+	for language, score := range scores {
+		scores[language] = math.Exp(score - best)
+		sum += scores[language]
+	}
+	detected, probability := LanguageUndetermined, 0.0
+	for language, score := range scores {
+		if score/sum > probability {
+			detected, probability = language, score/sum
+		}
+	}
+	if probability < ld.minProb {
+		return LanguageUndetermined, probability
+	}
+	return detected, probability
+}
+
+var (
+	languageDetector     *LanguageDetector
+	languageDetectorOnce sync.Once
+)
+
+// DetectLanguage detecta el idioma con el detector compartido
+func DetectLanguage(text string) string {
+	languageDetectorOnce.Do(func() {
+		languageDetector = NewLanguageDetector(languageSamples)
+	})
+	language, _ := languageDetector.Detect(text)
+	return language
+}
+
+// Dictionary agrupa listas de palabras por idioma. La clave "*" es una lista
+// que se aplica siempre, sin importar el idioma.
+type Dictionary map[string][]string
+
+// Words devuelve las palabras que se aplican a un mensaje en ese idioma
+func (d Dictionary) Words(language string) []string {
+	words := append([]string{}, d["*"]...)
+	if languageMode() == LanguageModeAll || language == LanguageUndetermined || language == "" || d[language] == nil {
+		// Sin idioma (o sin lista para él) se usan todas las listas
+		for _, key := range d.Languages() {
+			if key != "*" {
+				words = append(words, d[key]...)
+			}
+		}
+		return words
+	}
+	return append(words, d[language]...)
+}
+
+// Languages devuelve los idiomas con lista, ordenados
+func (d Dictionary) Languages() []string {
+	languages := make([]string, 0, len(d))
+	for language := range d {
+		languages = append(languages, language)
+	}
+	sort.Strings(languages)
+	return languages
+}
+
+// languageMode lee LANGUAGE_MODE ("detected" por defecto o "all")
+func languageMode() string {
+	if os.Getenv("LANGUAGE_MODE") == LanguageModeAll {
+		return LanguageModeAll
+	}
+	return LanguageModeDetected
+}
+
+var (
+	dictionaries     map[string]Dictionary
+	dictionariesOnce sync.Once
+)
+
+// loadDictionary devuelve el diccionario de una estrategia ("badword", "strict",
+// "warning"). Si LANGUAGE_DICTIONARIES_FILE define esa estrategia, reemplaza al
+// diccionario por defecto:
+//
+//	{"badword": {"es": ["tonto"], "en": ["stupid"]}, "strict": {"*": ["spam"]}}
+func loadDictionary(strategy string, defaults Dictionary) Dictionary {
+	dictionariesOnce.Do(func() {
+		dictionaries = map[string]Dictionary{}
+		path := os.Getenv("LANGUAGE_DICTIONARIES_FILE")
+		if path == "" {
+			return
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			slog.Warn("could not read language dictionaries", "path", path, "error", err)
+			return
+		}
+		if err := json.Unmarshal(data, &dictionaries); err != nil {
+			slog.Warn("invalid language dictionaries", "path", path, "error", err)
+			dictionaries = map[string]Dictionary{}
+		}
+	})
+	if dictionary, exists := dictionaries[strategy]; exists {
+		return dictionary
+	}
+	return defaults
+}
+
+// messageLanguage usa el idioma del contexto si el servidor ya lo detectó
+func messageLanguage(message string, ctx MessageContext) string {
+	if ctx.Language != "" {
+		return ctx.Language
+	}
+	return DetectLanguage(message)
+}
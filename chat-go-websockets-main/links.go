@@ -0,0 +1,346 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"html"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// LinkPolicy configura qué enlaces se permiten en los mensajes
+type LinkPolicy struct {
+	AllowDomains    []string `json:"allow_domains,omitempty"` // siempre permitidos (incluye subdominios)
+	DenyDomains     []string `json:"deny_domains,omitempty"`  // siempre bloqueados (incluye subdominios)
+	AllowlistOnly   bool     `json:"allowlist_only"`          // bloquear todo lo que no esté en allow_domains
+	BlockShorteners bool     `json:"block_shorteners"`        // bloquear acortadores de URL
+	Shorteners      []string `json:"shorteners,omitempty"`    // dominios considerados acortadores
+	BlockIPLiterals bool     `json:"block_ip_literals"`       // bloquear enlaces a direcciones IP
+	MaxLinks        int      `json:"max_links"`               // enlaces por mensaje, 0 = sin límite
+	NewUserMaxLinks int      `json:"new_user_max_links"`      // límite para usuarios nuevos, 0 = sin límite propio
+	NewUserAge      Duration `json:"new_user_age"`            // antigüedad mínima para dejar de ser nuevo
+	Rewrite         bool     `json:"rewrite"`                 // reescribir enlaces permitidos al redirector
+	RedirectPath    string   `json:"redirect_path,omitempty"` // ruta del redirector de este servidor
+}
+
+func DefaultLinkPolicy() LinkPolicy {
+	return LinkPolicy{
+		BlockShorteners: true,
+		Shorteners: []string{
+			"bit.ly", "tinyurl.com", "t.co", "goo.gl", "ow.ly", "is.gd",
+			"buff.ly", "cutt.ly", "rebrand.ly", "shorturl.at", "tiny.cc", "rb.gy",
+		},
+		BlockIPLiterals: true,
+		MaxLinks:        5,
+		NewUserMaxLinks: 1,
+		NewUserAge:      Duration(10 * time.Minute),
+		RedirectPath:    "/r",
+	}
+}
+
+// LoadLinkPolicy carga la política desde LINK_POLICY_FILE o usa la de por defecto
+func LoadLinkPolicy() LinkPolicy {
+	policy := DefaultLinkPolicy()
+
+	path := os.Getenv("LINK_POLICY_FILE")
+	if path == "" {
+		return policy
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		slog.Warn("could not read link policy", "path", path, "error", err)
+		return policy
+	}
+	if err := json.Unmarshal(data, &policy); err != nil {
+		slog.Warn("invalid link policy", "path", path, "error", err)
+		return DefaultLinkPolicy()
+	}
+	if policy.RedirectPath == "" {
+		policy.RedirectPath = "/r"
+	}
+	return policy
+}
+
+var (
+	linkPolicy     LinkPolicy
+	linkSecret     []byte
+	linkPolicyOnce sync.Once
+)
+
+// sharedLinkPolicy carga la política y la clave del redirector la primera vez
+// que se usan. La clave sale de LINK_REDIRECT_SECRET o se genera al iniciar.
+func sharedLinkPolicy() (LinkPolicy, []byte) {
+	linkPolicyOnce.Do(func() {
+		linkPolicy = LoadLinkPolicy()
+		if secret := os.Getenv("LINK_REDIRECT_SECRET"); secret != "" {
+			linkSecret = []byte(secret)
+			return
+		}
+		linkSecret = make([]byte, 32)
+		rand.Read(linkSecret)
+	})
+	return linkPolicy, linkSecret
+}
+
+// messageLinkPattern detecta enlaces con esquema, con "www." o dominios seguidos de una ruta
+var messageLinkPattern = regexp.MustCompile(`(?i)\b(?:https?://[^\s<>"]+|www\.[^\s<>"]+|[a-z0-9-]+(?:\.[a-z0-9-]+)*\.[a-z]{2,}/[^\s<>"]*)`)
+
+// MessageLink es un enlace encontrado en un mensaje
+type MessageLink struct {
+	Raw        string `json:"raw"`
+	Normalized string `json:"normalized"`
+	Host       string `json:"host"`
+	Start      int    `json:"start"`
+	End        int    `json:"end"`
+}
+
+// ExtractLinks encuentra y normaliza los enlaces de un mensaje
+func ExtractLinks(message string) []MessageLink {
+	links := []MessageLink{}
+	for _, span := range messageLinkPattern.FindAllStringIndex(message, -1) {
+		raw := strings.TrimRight(message[span[0]:span[1]], ".,;:!?)]}'\"")
+		link := MessageLink{Raw: raw, Start: span[0], End: span[0] + len(raw)}
+		if parsed, err := normalizeLink(raw); err == nil {
+			link.Normalized = parsed.String()
+			link.Host = parsed.Hostname()
+		}
+		links = append(links, link)
+	}
+	return links
+}
+
+// normalizeLink agrega el esquema si falta y normaliza el host
+func normalizeLink(raw string) (*url.URL, error) {
+	if !strings.Contains(raw, "://") {
+		raw = "http://" + raw
+	}
+	parsed, err := url.Parse(raw)
+	if err != nil {
+		return nil, err
+	}
+	if parsed.Scheme != "http" && parsed.Scheme != "https" {
+		return nil, fmt.Errorf("esquema no permitido: %s", parsed.Scheme)
+	}
+	host := strings.TrimSuffix(strings.ToLower(parsed.Hostname()), ".")
+	if host == "" {
+		return nil, fmt.Errorf("enlace sin host: %s", raw)
+	}
+	port := parsed.Port()
+	if (parsed.Scheme == "http" && port == "80") || (parsed.Scheme == "https" && port == "443") {
+		port = ""
+	}
+	parsed.Scheme = strings.ToLower(parsed.Scheme)
+	parsed.User = nil
+	parsed.Host = host
+	if port != "" {
+		parsed.Host = net.JoinHostPort(host, port)
+	} else if strings.Contains(host, ":") {
+		parsed.Host = "[" + host + "]"
+	}
+	return parsed, nil
+}
+
+// domainMatches indica si el host es el dominio o un subdominio de alguno de la lista
+func domainMatches(host string, domains []string) (string, bool) {
+	host = strings.TrimPrefix(host, "www.")
+	for _, domain := range domains {
+		domain = strings.ToLower(strings.TrimPrefix(domain, "www."))
+		if host == domain || strings.HasSuffix(host, "."+domain) {
+			return domain, true
+		}
+	}
+	return "", false
+}
+
+// numericHostPattern detecta IPv4 en cualquiera de las formas que acepta
+// inet_aton y resuelven los navegadores: de una a cuatro partes, cada una en
+// decimal, octal (0177) o hexadecimal (0x7f), como http://3232235777,
+// http://127.1 o http://0177.0.0.1. Ningún nombre de dominio tiene esa forma.
+var numericHostPattern = regexp.MustCompile(`^(?:(?:0x[0-9a-f]*|[0-9]+)\.){0,3}(?:0x[0-9a-f]*|[0-9]+)$`)
+
+// isIPLiteral detecta hosts que son direcciones IP, incluidas las formas cortas y numéricas
+func isIPLiteral(host string) bool {
+	return net.ParseIP(host) != nil || numericHostPattern.MatchString(host)
+}
+
+// checkLink devuelve el motivo por el que la política rechaza un enlace, o ""
+func (p LinkPolicy) checkLink(link MessageLink) string {
+	if link.Host == "" {
+		return "invalid link"
+	}
+	if _, allowed := domainMatches(link.Host, p.AllowDomains); allowed {
+		return ""
+	}
+	if domain, denied := domainMatches(link.Host, p.DenyDomains); denied {
+		return "denied domain " + domain
+	}
+	if p.BlockShorteners {
+		if domain, shortener := domainMatches(link.Host, p.Shorteners); shortener {
+			return "URL shortener " + domain
+		}
+	}
+	if p.BlockIPLiterals && isIPLiteral(link.Host) {
+		return "IP address link " + link.Host
+	}
+	if p.AllowlistOnly {
+		return "domain not in allowlist " + link.Host
+	}
+	return ""
+}
+
+// signLink firma la URL de destino para que el redirector no sea un redirector abierto
+func signLink(secret []byte, target string) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(target))
+	return hex.EncodeToString(mac.Sum(nil)[:16])
+}
+
+// LinkPolicyStrategy aplica la política de enlaces a los mensajes
+type LinkPolicyStrategy struct {
+	policy LinkPolicy
+	secret []byte
+}
+
+func NewLinkPolicyStrategy() *LinkPolicyStrategy {
+	policy, secret := sharedLinkPolicy()
+	return NewLinkPolicyStrategyWithPolicy(policy, secret)
+}
+
+// NewLinkPolicyStrategyWithPolicy crea la estrategia con una política propia
+func NewLinkPolicyStrategyWithPolicy(policy LinkPolicy, secret []byte) *LinkPolicyStrategy {
+	return &LinkPolicyStrategy{
+		policy: policy,
+		secret: secret,
+	}
+}
+
+func (lps *LinkPolicyStrategy) Moderate(message string) ModerationResult {
+	return lps.ModerateWithContext(message, MessageContext{})
+}
+
+func (lps *LinkPolicyStrategy) ModerateWithContext(message string, ctx MessageContext) ModerationResult {
+	result := ModerationResult{
+		OriginalMessage: message,
+		ModifiedMessage: message,
+		Action:          "allow",
+		Reason:          "No links found",
+		Confidence:      0.0,
+		Timestamp:       time.Now(),
+		StrategyUsed:    lps.GetName(),
+	}
+
+	links := ExtractLinks(message)
+	if len(links) == 0 {
+		return result
+	}
+
+	// Límite de enlaces, más estricto para usuarios nuevos
+	limit := lps.policy.MaxLinks
+	newUser := !ctx.FirstSeen.IsZero() && ctx.Timestamp.Sub(ctx.FirstSeen) < time.Duration(lps.policy.NewUserAge)
+	if newUser && lps.policy.NewUserMaxLinks > 0 && (limit == 0 || lps.policy.NewUserMaxLinks < limit) {
+		limit = lps.policy.NewUserMaxLinks
+	}
+	if limit > 0 && len(links) > limit {
+		result.Action = "block"
+		result.ModifiedMessage = ""
+		result.Reason = fmt.Sprintf("Too many links: %d (limit %d)", len(links), limit)
+		if newUser {
+			result.Reason += " for new users"
+		}
+		result.Confidence = 0.8
+		return result
+	}
+
+	violations := []string{}
+	for _, link := range links {
+		if reason := lps.policy.checkLink(link); reason != "" {
+			violations = append(violations, reason)
+			result.MatchedTerms = append(result.MatchedTerms, link.Raw)
+		}
+	}
+	if len(violations) > 0 {
+		result.Action = "block"
+		result.ModifiedMessage = ""
+		result.Reason = "Message contains forbidden links: " + strings.Join(violations, ", ")
+		result.Confidence = 0.9
+		return result
+	}
+
+	result.Reason = fmt.Sprintf("%d links allowed", len(links))
+	if lps.policy.Rewrite {
+		// La reescritura no es una infracción: el resultado sigue siendo "allow"
+		// con el texto modificado, para no sumar strikes a quien comparte enlaces
+		rewritten := strings.Builder{}
+		last := 0
+		for _, link := range links {
+			rewritten.WriteString(message[last:link.Start])
+			rewritten.WriteString(lps.redirectURL(link.Normalized))
+			last = link.End
+		}
+		rewritten.WriteString(message[last:])
+		result.ModifiedMessage = rewritten.String()
+		result.Reason += " and rewritten to the safe redirect"
+	}
+	return result
+}
+
+// redirectURL arma el enlace al redirector de este servidor
+func (lps *LinkPolicyStrategy) redirectURL(target string) string {
+	query := url.Values{}
+	query.Set("u", target)
+	query.Set("sig", signLink(lps.secret, target))
+	return lps.policy.RedirectPath + "?" + query.Encode()
+}
+
+func (lps *LinkPolicyStrategy) GetName() string {
+	return "LinkPolicy"
+}
+
+// handleSafeRedirect muestra una página intermedia antes de salir del chat hacia
+// un enlace reescrito por LinkPolicyStrategy. Solo acepta destinos firmados.
+func handleSafeRedirect(w http.ResponseWriter, r *http.Request) {
+	policy, secret := sharedLinkPolicy()
+	target := r.URL.Query().Get("u")
+	signature := r.URL.Query().Get("sig")
+	if target == "" || !hmac.Equal([]byte(signature), []byte(signLink(secret, target))) {
+		http.Error(w, "Enlace inválido", http.StatusBadRequest)
+		return
+	}
+
+	// Volver a validar por si la política cambió desde que se reescribió el enlace
+	parsed, err := normalizeLink(target)
+	if err != nil {
+		http.Error(w, "Enlace inválido", http.StatusBadRequest)
+		return
+	}
+	if reason := policy.checkLink(MessageLink{Raw: target, Normalized: parsed.String(), Host: parsed.Hostname()}); reason != "" {
+		http.Error(w, "Enlace bloqueado: "+reason, http.StatusForbidden)
+		return
+	}
+
+	escaped := html.EscapeString(parsed.String())
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Referrer-Policy", "no-referrer")
+	fmt.Fprintf(w, `<!DOCTYPE html>
+<html lang="es">
+<head><meta charset="utf-8"><title>Saliendo del chat</title></head>
+<body style="font-family: sans-serif; max-width: 40rem; margin: 4rem auto;">
+<h1>Estás saliendo del chat</h1>
+<p>El enlace lleva a <strong>%s</strong>.</p>
+<p><a href="%s" rel="noopener noreferrer nofollow">Continuar a %s</a></p>
+</body>
+</html>
+`, html.EscapeString(parsed.Hostname()), escaped, escaped)
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// LogConfig configura los logs del servidor
+type LogConfig struct {
+	Level     string `json:"level"`      // debug | info | warn | error
+	Format    string `json:"format"`     // text | json
+	AddSource bool   `json:"add_source"` // agrega archivo y línea a cada entrada
+}
+
+func DefaultLogConfig() LogConfig {
+	return LogConfig{Level: "info", Format: "text"}
+}
+
+// LoadLogConfig carga LOG_CONFIG_FILE o usa la configuración por defecto.
+// LOG_LEVEL y LOG_FORMAT tienen prioridad sobre el archivo.
+func LoadLogConfig() LogConfig {
+	config := DefaultLogConfig()
+
+	if path := os.Getenv("LOG_CONFIG_FILE"); path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			slog.Warn("could not read log config", "path", path, "error", err)
+		} else if err := json.Unmarshal(data, &config); err != nil {
+			slog.Warn("invalid log config", "path", path, "error", err)
+			config = DefaultLogConfig()
+		}
+	}
+	if level := os.Getenv("LOG_LEVEL"); level != "" {
+		config.Level = level
+	}
+	if format := os.Getenv("LOG_FORMAT"); format != "" {
+		config.Format = format
+	}
+	return config
+}
+
+// logLevel es el nivel vigente; se puede cambiar sin reiniciar con /logging
+var logLevel = new(slog.LevelVar)
+
+func parseLogLevel(name string) (slog.Level, error) {
+	var level slog.Level
+	err := level.UnmarshalText([]byte(strings.TrimSpace(name)))
+	return level, err
+}
+
+// SetupLogging instala el logger por defecto. Los log.Printf que queden
+// también pasan por él.
+func SetupLogging(config LogConfig) {
+	level, err := parseLogLevel(config.Level)
+	if err != nil {
+		level = slog.LevelInfo
+	}
+	logLevel.Set(level)
+
+	options := &slog.HandlerOptions{Level: logLevel, AddSource: config.AddSource}
+	var handler slog.Handler
+	if strings.EqualFold(config.Format, "json") {
+		handler = slog.NewJSONHandler(os.Stdout, options)
+	} else {
+		handler = slog.NewTextHandler(os.Stdout, options)
+	}
+	slog.SetDefault(slog.New(handler))
+	if err != nil {
+		slog.Warn("invalid log level, using info", "level", config.Level)
+	}
+}
+
+// componentLog devuelve el logger de un subsistema (eventlog, broker, webhooks...)
+func componentLog(component string) *slog.Logger {
+	return slog.Default().With("component", component)
+}
+
+// eventAttrs son los campos con los que se registra un evento
+func eventAttrs(event Event) []any {
+	attrs := []any{"event_type", event.Type}
+	if event.Username != "" {
+		attrs = append(attrs, "user", event.Username)
+	}
+	if event.Room != "" {
+		attrs = append(attrs, "room", event.Room)
+	}
+	if event.Offset > 0 {
+		attrs = append(attrs, "offset", event.Offset)
+	}
+	if event.Node != "" {
+		attrs = append(attrs, "node", event.Node)
+	}
+	if messageID, ok := event.Data["message_id"].(string); ok {
+		attrs = append(attrs, "message_id", messageID)
+	}
+	return attrs
+}
+
+// moderationAttrs son los campos con los que se registra un resultado de moderación
+func moderationAttrs(result ModerationResult) []any {
+	attrs := []any{
+		"action", result.Action,
+		"strategy", result.StrategyUsed,
+		"confidence", result.Confidence,
+	}
+	if result.Reason != "" {
+		attrs = append(attrs, "reason", result.Reason)
+	}
+	if len(result.MatchedTerms) > 0 {
+		attrs = append(attrs, "matched_terms", result.MatchedTerms)
+	}
+	if result.Language != "" {
+		attrs = append(attrs, "language", result.Language)
+	}
+	return attrs
+}
+
+type requestLoggerKey struct{}
+
+// requestLogger devuelve el logger del pedido, con su ID, método, ruta e IP
+func requestLogger(r *http.Request) *slog.Logger {
+	if logger, ok := r.Context().Value(requestLoggerKey{}).(*slog.Logger); ok {
+		return logger
+	}
+	return slog.Default()
+}
+
+// withRequestLogging le da a cada pedido un logger propio y registra cómo
+// terminó. Respeta el X-Request-Id que llega y lo devuelve en la respuesta.
+func withRequestLogging(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestID := r.Header.Get("X-Request-Id")
+		if requestID == "" {
+			id := make([]byte, 8)
+			rand.Read(id)
+			requestID = hex.EncodeToString(id)
+		}
+		w.Header().Set("X-Request-Id", requestID)
+
+		logger := slog.Default().With(
+			"request_id", requestID,
+			"method", r.Method,
+			"path", r.URL.Path,
+			"ip", clientIP(r),
+		)
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		start := time.Now()
+		next.ServeHTTP(recorder, r.WithContext(context.WithValue(r.Context(), requestLoggerKey{}, logger)))
+
+		level := slog.LevelDebug
+		switch {
+		case recorder.status >= 500:
+			level = slog.LevelError
+		case recorder.status >= 400:
+			level = slog.LevelInfo
+		}
+		logger.Log(r.Context(), level, "request completed",
+			"status", recorder.status,
+			"duration_ms", float64(time.Since(start))/float64(time.Millisecond),
+		)
+	})
+}
+
+// statusRecorder guarda el código de la respuesta. Implementa Hijacker para
+// que el upgrade de WebSocket siga funcionando.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (sr *statusRecorder) WriteHeader(status int) {
+	sr.status = status
+	sr.ResponseWriter.WriteHeader(status)
+}
+
+func (sr *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := sr.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("la respuesta no admite hijack")
+	}
+	sr.status = http.StatusSwitchingProtocols
+	return hijacker.Hijack()
+}
+
+func (sr *statusRecorder) Flush() {
+	if flusher, ok := sr.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// handleLogging muestra el nivel de log vigente (GET) o lo cambia sin
+// reiniciar (POST {"level": "debug"})
+func handleLogging(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+	case "POST":
+		var req struct {
+			Level string `json:"level"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "JSON inválido: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		level, err := parseLogLevel(req.Level)
+		if err != nil {
+			http.Error(w, "Nivel inválido: "+req.Level+" (debug|info|warn|error)", http.StatusBadRequest)
+			return
+		}
+		logLevel.Set(level)
+		requestLogger(r).Info("log level changed", "level", level.String())
+	default:
+		http.Error(w, "Método no permitido", http.StatusMethodNotAllowed)
+		return
+	}
+	writeJSON(w, map[string]interface{}{"level": logLevel.Level().String()})
+}
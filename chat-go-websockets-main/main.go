@@ -1,19 +1,46 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
-	"log"
+	"log/slog"
 	"net/http"
 	"os"
+	ossignal "os/signal"
+	"syscall"
+	"time"
 
 	"github.com/joho/godotenv"
 )
 
 func main() {
+	// Subcomandos de línea de comandos
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "moderate-eval":
+			registerClassifierStrategy()
+			registerExternalStrategy()
+			NewCompositeStore(dataPath("composites.json"))
+			os.Exit(runModerateEval(os.Args[2:]))
+		case "train-classifier":
+			os.Exit(runTrainClassifier(os.Args[2:]))
+		}
+	}
+
 	err := godotenv.Load(".env")
+	
+	// Logs estructurados: nivel y formato (text|json) desde LOG_LEVEL, LOG_FORMAT o LOG_CONFIG_FILE
+	SetupLogging(LoadLogConfig())
 	if err != nil {
-		log.Println("Warning: .env file not found, using environment variables")
+		slog.Warn(".env file not found, using environment variables")
 	}
+	// Sin ADMIN_TOKEN los endpoints de administración quedan desactivados
+	checkAdminToken()
+
+	// Clasificador estadístico local, si hay un modelo entrenado
+	registerClassifierStrategy()
+	// Servicio de clasificación externo, si hay uno configurado
+	registerExternalStrategy()
 
 	// Obtener puerto de variable de entorno o usar 8080 por defecto
 	port := os.Getenv("PORT")
@@ -21,12 +48,16 @@ func main() {
 		port = "8080"
 	}
 
-	log.Println("Websocket server started")
+	slog.Info("websocket server started")
 	server := NewServer()
 
 	http.Handle("/", http.FileServer(http.Dir("./frontend")))
 	http.HandleFunc("/ws", server.handleWebSocket)
 	
+	// Redirector seguro para los enlaces reescritos por la política de enlaces
+	linkPolicy, _ := sharedLinkPolicy()
+	http.HandleFunc(linkPolicy.RedirectPath, handleSafeRedirect)
+	
 	// Endpoints para moderación
 	http.HandleFunc("/moderation/badword", func(w http.ResponseWriter, r *http.Request) {
 		if r.Method == "POST" {
@@ -60,6 +91,48 @@ func main() {
 		}
 	})
 	
+	http.HandleFunc("/moderation/spam", requireAdmin(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "POST" {
+			server.SetModerationStrategy(NewSpamStrategy())
+			w.WriteHeader(http.StatusOK)
+			w.Write([]byte("Estrategia cambiada a SpamBehavior"))
+		}
+	}))
+	
+	http.HandleFunc("/moderation/links", requireAdmin(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "POST" {
+			server.SetModerationStrategy(NewLinkPolicyStrategy())
+			w.WriteHeader(http.StatusOK)
+			w.Write([]byte("Estrategia cambiada a LinkPolicy"))
+		}
+	}))
+	
+	http.HandleFunc("/moderation/classifier", requireAdmin(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "POST" {
+			strategy, err := strategies.Create("classifier")
+			if err != nil {
+				http.Error(w, "No hay un modelo de clasificador cargado", http.StatusNotFound)
+				return
+			}
+			server.SetModerationStrategy(strategy)
+			w.WriteHeader(http.StatusOK)
+			w.Write([]byte("Estrategia cambiada a Classifier"))
+		}
+	}))
+	
+	http.HandleFunc("/moderation/external", requireAdmin(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "POST" {
+			strategy, err := strategies.Create("external")
+			if err != nil {
+				http.Error(w, "No hay un servicio de moderación externo configurado", http.StatusNotFound)
+				return
+			}
+			server.SetModerationStrategy(strategy)
+			w.WriteHeader(http.StatusOK)
+			w.Write([]byte("Estrategia cambiada a External"))
+		}
+	}))
+	
 	http.HandleFunc("/moderation/stats", func(w http.ResponseWriter, r *http.Request) {
 		if r.Method == "GET" {
 			stats := server.GetModerationStats()
@@ -68,6 +141,80 @@ func main() {
 		}
 	})
 	
-	log.Printf("Server starting on port %s", port)
-	log.Fatal(http.ListenAndServe(":"+port, nil))
+	// Acciones de moderadores sobre usuarios
+	http.HandleFunc("/moderation/kick", requireAdmin(server.handleKick))
+	http.HandleFunc("/moderation/mute", requireAdmin(server.handleMute))
+	http.HandleFunc("/moderation/ban", requireAdmin(server.handleBan))
+	http.HandleFunc("/moderation/lift", requireAdmin(server.handleLift))
+	http.HandleFunc("/moderation/sanctions", requireAdmin(server.handleSanctions))
+	http.HandleFunc("/moderation/audit", requireAdmin(server.handleAudit))
+	http.HandleFunc("/moderation/connections", requireAdmin(server.handleConnections))
+	
+	// Estrategias candidatas en modo sombra
+	http.HandleFunc("/moderation/shadow", requireAdmin(server.handleShadow))
+	http.HandleFunc("/moderation/shadow/promote", requireAdmin(server.handleShadowPromote))
+	
+	// Prueba de moderación sin publicar el mensaje
+	http.HandleFunc("/moderation/test", requireAdmin(server.handleModerationTest))
+	
+	// Estrategias compuestas con nombre
+	http.HandleFunc("/moderation/composites", requireAdmin(server.handleComposites))
+	http.HandleFunc("/moderation/composites/activate", requireAdmin(server.handleCompositeActivate))
+	http.HandleFunc("/moderation/plugins", requireAdmin(server.handlePlugins))
+	
+	// Cola de revisión humana
+	http.HandleFunc("/moderation/review", requireAdmin(server.handleReviewQueue))
+	http.HandleFunc("/moderation/review/claim", requireAdmin(server.handleReviewAction("claim")))
+	http.HandleFunc("/moderation/review/approve", requireAdmin(server.handleReviewAction("approve")))
+	http.HandleFunc("/moderation/review/reject", requireAdmin(server.handleReviewAction("reject")))
+	
+	// Log de eventos: los clientes se ponen al día desde un offset o una fecha
+	http.HandleFunc("/events", server.handleEvents)
+	http.HandleFunc("/events/subscribers", requireAdmin(server.handleEventSubscribers))
+	
+	// Clúster sin broker externo: enlace entre nodos, estado y presencia
+	http.HandleFunc("/cluster/link", server.handleClusterLink)
+	http.HandleFunc("/cluster", requireAdmin(server.handleCluster))
+	http.HandleFunc("/presence", requireAdmin(server.handlePresence))
+	
+	// Webhooks salientes: eventos firmados hacia herramientas externas
+	http.HandleFunc("/webhooks", requireAdmin(server.handleWebhooks))
+	http.HandleFunc("/webhooks/test", requireAdmin(server.handleWebhookAction("test")))
+	http.HandleFunc("/webhooks/disable", requireAdmin(server.handleWebhookAction("disable")))
+	http.HandleFunc("/webhooks/enable", requireAdmin(server.handleWebhookAction("enable")))
+	http.HandleFunc("/webhooks/dead", requireAdmin(server.handleWebhookDeadLetters))
+	
+	// Webhooks entrantes: sistemas externos publican en una sala con POST /hooks/{token}
+	http.HandleFunc("/webhooks/incoming", requireAdmin(server.handleIncomingHooks))
+	http.HandleFunc("/hooks/", server.handleIncomingHook)
+	
+	// Nivel de log en caliente
+	http.HandleFunc("/logging", requireAdmin(handleLogging))
+	
+	// Cada pedido lleva su propio logger, con su ID, ruta e IP
+	httpServer := &http.Server{Addr: ":" + port, Handler: withRequestLogging(http.DefaultServeMux)}
+	
+	// Al recibir SIGINT o SIGTERM se deja de aceptar pedidos y se guarda lo pendiente
+	ctx, stop := ossignal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	shutdown := make(chan bool)
+	go func() {
+		defer close(shutdown)
+		<-ctx.Done()
+		slog.Info("shutting down")
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := httpServer.Shutdown(shutdownCtx); err != nil {
+			slog.Warn("http shutdown did not finish", "error", err)
+		}
+	}()
+	
+	slog.Info("server starting", "port", port)
+	if err := httpServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		slog.Error("server stopped", "error", err)
+		os.Exit(1)
+	}
+	<-shutdown
+	server.Close()
+	slog.Info("server stopped")
 }
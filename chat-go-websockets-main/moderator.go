@@ -0,0 +1,454 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// defaultMuteDuration se usa cuando un moderador no indica duración al silenciar
+const defaultMuteDuration = 10 * time.Minute
+
+// ModeratorRequest son los parámetros de una acción manual de un moderador
+type ModeratorRequest struct {
+	ConnectionID string   `json:"connection_id,omitempty"`
+	Username     string   `json:"username,omitempty"`
+	IP           string   `json:"ip,omitempty"`
+	Token        string   `json:"token,omitempty"`
+	SanctionID   string   `json:"sanction_id,omitempty"`
+	Room         string   `json:"room,omitempty"`     // sala a notificar si el usuario no está conectado
+	Duration     Duration `json:"duration,omitempty"` // vacío en un ban = permanente
+	Reason       string   `json:"reason,omitempty"`
+	Moderator    string   `json:"-"`
+}
+
+// target describe a quién apunta la acción, para mensajes y auditoría
+func (req ModeratorRequest) target() string {
+	switch {
+	case req.ConnectionID != "":
+		return req.ConnectionID
+	case req.Username != "":
+		return req.Username
+	case req.IP != "":
+		return "ip:" + req.IP
+	case req.Token != "":
+		return "token:" + req.Token
+	}
+	return req.SanctionID
+}
+
+func (req ModeratorRequest) matches(observer *ConnectionObserver) bool {
+	if req.ConnectionID != "" {
+		return observer.GetID() == req.ConnectionID
+	}
+	identity := Sanction{Username: req.Username, IP: req.IP, Token: req.Token}
+	return identity.Matches(observer.GetUsername(), observer.GetIP(), observer.GetToken())
+}
+
+// matchingObservers devuelve las conexiones activas alcanzadas por la acción
+func (s *Server) matchingObservers(req ModeratorRequest) []*ConnectionObserver {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	result := []*ConnectionObserver{}
+	for _, observer := range s.observerMap {
+		if req.matches(observer) {
+			result = append(result, observer)
+		}
+	}
+	return result
+}
+
+// announceModeratorAction avisa en las salas afectadas y registra la acción en auditoría
+func (s *Server) announceModeratorAction(req ModeratorRequest, action, message string, affected []*ConnectionObserver, data map[string]interface{}) {
+	rooms := map[string]bool{}
+	for _, observer := range affected {
+		rooms[observer.GetRoom()] = true
+	}
+	if req.Room != "" {
+		rooms[req.Room] = true
+	}
+	if len(rooms) == 0 {
+		rooms[DefaultRoom] = true
+	}
+
+	for room := range rooms {
+		s.publisher.PublishRoomEvent(SystemEvent, room, message, "", map[string]interface{}{
+			"moderator_action": action,
+			"target":           req.target(),
+		})
+	}
+
+	s.audit.Record(AuditEntry{
+		Kind:   AuditModeratorAction,
+		Actor:  req.Moderator,
+		Action: action,
+		Target: req.target(),
+		Reason: req.Reason,
+		Data:   data,
+	})
+	componentLog("moderator").Info("moderator action", "moderator", req.Moderator, "action", action, "target", req.target(), "affected", len(affected))
+}
+
+// Kick desconecta las conexiones que coinciden con la solicitud
+func (s *Server) Kick(req ModeratorRequest) (int, error) {
+	if req.ConnectionID == "" && req.Username == "" && req.IP == "" && req.Token == "" {
+		return 0, errors.New("se requiere connection_id, username, ip o token")
+	}
+
+	affected := s.matchingObservers(req)
+	for _, observer := range affected {
+		observer.Disconnect(Event{
+			Type:      SystemEvent,
+			Message:   "Fuiste expulsado por un moderador: " + req.Reason,
+			Timestamp: time.Now(),
+		})
+	}
+
+	s.announceModeratorAction(req, "kick", req.target()+" fue expulsado por un moderador", affected, map[string]interface{}{
+		"connections": len(affected),
+	})
+	return len(affected), nil
+}
+
+// Mute silencia a un usuario durante la duración indicada
+func (s *Server) Mute(req ModeratorRequest) (Sanction, error) {
+	if req.Username == "" {
+		return Sanction{}, errors.New("se requiere username")
+	}
+	duration := time.Duration(req.Duration)
+	if duration <= 0 {
+		duration = defaultMuteDuration
+	}
+
+	// El silencio alcanza también a la sesión dueña del nombre, para que no se
+	// esquive reconectando con otro nombre
+	session, _ := s.sessions.Owner(req.Username)
+	sanction := s.sanctions.AddSanction(Sanction{
+		Type:      SanctionMute,
+		Username:  req.Username,
+		Token:     session,
+		Reason:    req.Reason,
+		IssuedBy:  req.Moderator,
+		ExpiresAt: time.Now().Add(duration),
+	})
+
+	affected := s.matchingObservers(ModeratorRequest{Username: req.Username, Token: session})
+	for _, observer := range affected {
+		s.applySanction(observer, &sanction)
+	}
+
+	s.announceModeratorAction(req, "mute", fmt.Sprintf("%s fue silenciado por %s", req.Username, duration), affected, map[string]interface{}{
+		"sanction": sanction,
+	})
+	return sanction, nil
+}
+
+// Ban prohíbe el acceso por usuario, IP o token. Sin duración el ban es permanente.
+func (s *Server) Ban(req ModeratorRequest) (Sanction, error) {
+	if req.Username == "" && req.IP == "" && req.Token == "" {
+		return Sanction{}, errors.New("se requiere username, ip o token")
+	}
+
+	sanction := Sanction{
+		Type:     SanctionPermBan,
+		Username: req.Username,
+		IP:       req.IP,
+		Token:    req.Token,
+		Reason:   req.Reason,
+		IssuedBy: req.Moderator,
+	}
+	if duration := time.Duration(req.Duration); duration > 0 {
+		sanction.Type = SanctionTempBan
+		sanction.ExpiresAt = time.Now().Add(duration)
+	}
+	sanction = s.sanctions.AddSanction(sanction)
+
+	affected := s.matchingObservers(ModeratorRequest{Username: req.Username, IP: req.IP, Token: req.Token})
+	for _, observer := range affected {
+		s.applySanction(observer, &sanction)
+	}
+
+	s.announceModeratorAction(req, "ban", req.target()+" fue baneado por un moderador", affected, map[string]interface{}{
+		"sanction": sanction,
+	})
+	return sanction, nil
+}
+
+// Lift levanta una sanción por ID o todas las que alcancen a la identidad indicada
+func (s *Server) Lift(req ModeratorRequest) ([]Sanction, error) {
+	var lifted []Sanction
+	switch {
+	case req.SanctionID != "":
+		sanction, found := s.sanctions.Lift(req.SanctionID)
+		if !found {
+			return nil, errors.New("sanción no encontrada: " + req.SanctionID)
+		}
+		lifted = []Sanction{sanction}
+		req.Username, req.IP, req.Token = sanction.Username, sanction.IP, sanction.Token
+	case req.Username != "" || req.IP != "" || req.Token != "":
+		lifted = s.sanctions.LiftMatching(req.Username, req.IP, req.Token)
+	default:
+		return nil, errors.New("se requiere sanction_id, username, ip o token")
+	}
+
+	affected := s.matchingObservers(ModeratorRequest{Username: req.Username, IP: req.IP, Token: req.Token})
+	s.announceModeratorAction(req, "lift", "Se levantaron las sanciones de "+req.target(), affected, map[string]interface{}{
+		"lifted": lifted,
+	})
+	return lifted, nil
+}
+
+// adminOpen indica si se pidió expresamente dejar los endpoints de
+// administración abiertos sin ADMIN_TOKEN (ADMIN_INSECURE=true), para desarrollo
+func adminOpen() bool {
+	open, _ := strconv.ParseBool(os.Getenv("ADMIN_INSECURE"))
+	return open
+}
+
+// checkAdminToken avisa al arrancar si los endpoints de administración quedan
+// desactivados o abiertos a cualquiera
+func checkAdminToken() {
+	if os.Getenv("ADMIN_TOKEN") != "" {
+		return
+	}
+	if adminOpen() {
+		slog.Warn("ADMIN_TOKEN is not set and ADMIN_INSECURE=true: admin endpoints are open to anyone")
+		return
+	}
+	slog.Warn("ADMIN_TOKEN is not set: admin endpoints are disabled")
+}
+
+// requireAdmin protege un endpoint con ADMIN_TOKEN. Sin token configurado el
+// endpoint responde 503, salvo que ADMIN_INSECURE=true lo deje abierto.
+func requireAdmin(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token := os.Getenv("ADMIN_TOKEN")
+		if token == "" && !adminOpen() {
+			http.Error(w, "Endpoints de administración desactivados: defina ADMIN_TOKEN", http.StatusServiceUnavailable)
+			return
+		}
+		if token != "" && r.Header.Get("X-Admin-Token") != token {
+			requestLogger(r).Warn("unauthorized admin request")
+			http.Error(w, "No autorizado", http.StatusUnauthorized)
+			return
+		}
+		next(w, r)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+// decodeModeratorRequest lee el cuerpo de una acción de moderador
+func decodeModeratorRequest(w http.ResponseWriter, r *http.Request) (ModeratorRequest, bool) {
+	var req ModeratorRequest
+	if r.Method != "POST" {
+		http.Error(w, "Método no permitido", http.StatusMethodNotAllowed)
+		return req, false
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "JSON inválido: "+err.Error(), http.StatusBadRequest)
+		return req, false
+	}
+	req.Moderator = r.Header.Get("X-Moderator")
+	if req.Moderator == "" {
+		req.Moderator = "admin"
+	}
+	return req, true
+}
+
+func (s *Server) handleKick(w http.ResponseWriter, r *http.Request) {
+	req, ok := decodeModeratorRequest(w, r)
+	if !ok {
+		return
+	}
+	kicked, err := s.Kick(req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	writeJSON(w, map[string]interface{}{"kicked": kicked})
+}
+
+func (s *Server) handleMute(w http.ResponseWriter, r *http.Request) {
+	req, ok := decodeModeratorRequest(w, r)
+	if !ok {
+		return
+	}
+	sanction, err := s.Mute(req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	writeJSON(w, sanction)
+}
+
+func (s *Server) handleBan(w http.ResponseWriter, r *http.Request) {
+	req, ok := decodeModeratorRequest(w, r)
+	if !ok {
+		return
+	}
+	sanction, err := s.Ban(req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	writeJSON(w, sanction)
+}
+
+func (s *Server) handleLift(w http.ResponseWriter, r *http.Request) {
+	req, ok := decodeModeratorRequest(w, r)
+	if !ok {
+		return
+	}
+	lifted, err := s.Lift(req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	writeJSON(w, map[string]interface{}{"lifted": lifted})
+}
+
+// handleAudit consulta el log de auditoría. Parámetros: user, action, strategy,
+// kind, since, until (RFC3339), limit y format=jsonl para exportar.
+func (s *Server) handleAudit(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Método no permitido", http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := r.URL.Query()
+	filter := AuditFilter{
+		User:     query.Get("user"),
+		Action:   query.Get("action"),
+		Strategy: query.Get("strategy"),
+		Kind:     query.Get("kind"),
+	}
+	for param, target := range map[string]*time.Time{"since": &filter.Since, "until": &filter.Until} {
+		if value := query.Get(param); value != "" {
+			parsed, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				http.Error(w, "Fecha inválida en "+param+": "+err.Error(), http.StatusBadRequest)
+				return
+			}
+			*target = parsed
+		}
+	}
+
+	if query.Get("format") == "jsonl" {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		w.Header().Set("Content-Disposition", `attachment; filename="audit.jsonl"`)
+		if err := s.audit.Export(w, filter); err != nil {
+			requestLogger(r).Error("could not export audit log", "error", err)
+		}
+		return
+	}
+
+	filter.Limit = 100
+	if value := query.Get("limit"); value != "" {
+		if _, err := fmt.Sscanf(value, "%d", &filter.Limit); err != nil {
+			http.Error(w, "limit inválido", http.StatusBadRequest)
+			return
+		}
+	}
+	entries, err := s.audit.Query(filter)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, entries)
+}
+
+func (s *Server) handleSanctions(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Método no permitido", http.StatusMethodNotAllowed)
+		return
+	}
+	writeJSON(w, s.sanctions.ActiveSanctions())
+}
+
+// moderatorCommands son los comandos de chat reservados a moderadores
+var moderatorCommands = map[string]bool{
+	"/kick": true, "/mute": true, "/ban": true, "/unban": true, "/sanctions": true,
+}
+
+// handleCommand ejecuta un comando de moderador escrito en el chat.
+// Devuelve false si el texto no es un comando de moderación.
+func (s *Server) handleCommand(observer *ConnectionObserver, text string) bool {
+	fields := strings.Fields(text)
+	if len(fields) == 0 || !moderatorCommands[fields[0]] {
+		return false
+	}
+
+	reply := func(message string) {
+		observer.Update(Event{Type: SystemEvent, Message: message, Timestamp: time.Now()})
+	}
+
+	if !observer.IsModerator() {
+		reply("No tienes permisos para usar " + fields[0])
+		return true
+	}
+
+	req := ModeratorRequest{Moderator: observer.GetUsername(), Room: observer.GetRoom()}
+	args := fields[1:]
+	if fields[0] != "/sanctions" {
+		if len(args) == 0 {
+			reply("Uso: " + fields[0] + " <usuario> [duración] [motivo]")
+			return true
+		}
+		req.Username, args = args[0], args[1:]
+	}
+	// La duración es opcional en /mute y /ban
+	if len(args) > 0 && (fields[0] == "/mute" || fields[0] == "/ban") {
+		if duration, err := time.ParseDuration(args[0]); err == nil {
+			req.Duration, args = Duration(duration), args[1:]
+		}
+	}
+	req.Reason = strings.Join(args, " ")
+
+	var err error
+	switch fields[0] {
+	case "/kick":
+		var kicked int
+		if kicked, err = s.Kick(req); err == nil {
+			reply(fmt.Sprintf("%d conexiones expulsadas", kicked))
+		}
+	case "/mute":
+		var sanction Sanction
+		if sanction, err = s.Mute(req); err == nil {
+			reply("Sanción " + sanction.ID + " aplicada")
+		}
+	case "/ban":
+		var sanction Sanction
+		if sanction, err = s.Ban(req); err == nil {
+			reply("Sanción " + sanction.ID + " aplicada")
+		}
+	case "/unban":
+		var lifted []Sanction
+		if lifted, err = s.Lift(req); err == nil {
+			reply(fmt.Sprintf("%d sanciones levantadas", len(lifted)))
+		}
+	case "/sanctions":
+		active := s.sanctions.ActiveSanctions()
+		if len(active) == 0 {
+			reply("No hay sanciones vigentes")
+		}
+		for _, sanction := range active {
+			reply(fmt.Sprintf("%s: %s %s%s%s %s", sanction.ID, sanction.Type,
+				sanction.Username, sanction.IP, sanction.Token, sanction.Reason))
+		}
+	}
+	if err != nil {
+		reply("Error: " + err.Error())
+	}
+	return true
+}
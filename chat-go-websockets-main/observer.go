@@ -2,7 +2,9 @@ package main
 
 import (
 	"encoding/json"
-	"fmt"
+	"log/slog"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gorilla/websocket"
@@ -16,13 +18,23 @@ const (
 	UserJoinEvent   EventType = "user_join"
 	UserLeave EventType = "user_leave"
 	SystemEvent     EventType = "system"
+	MessageApprovedEvent EventType = "message_approved" // un mensaje marcado fue aprobado
+	MessageRemovedEvent  EventType = "message_removed"  // un mensaje publicado debe eliminarse
+	MessageHiddenEvent   EventType = "message_hidden"   // un mensaje se oculta hasta ser revisado
+	SessionEvent         EventType = "session"          // sesión nueva que el cliente debe guardar; solo a esa conexión
 )
 
+// DefaultRoom es la sala a la que se une una conexión si no indica otra
+const DefaultRoom = "general"
+
 // Event representa un evento genérico en el sistema
 type Event struct {
+	Offset    int64               `json:"offset,omitempty"` // posición en el log de eventos, si está activo
+	Node      string              `json:"node,omitempty"`   // instancia donde se originó el evento
 	Type      EventType           `json:"type"`
 	Message   string              `json:"message,omitempty"`
 	Username  string              `json:"username,omitempty"`
+	Room      string              `json:"room,omitempty"` // vacío = todas las salas
 	Data      map[string]interface{} `json:"data,omitempty"`
 	Timestamp time.Time           `json:"timestamp"`
 }
@@ -35,7 +47,7 @@ type Observer interface {
 
 // Publisher es la interfaz para el sujeto observable
 type Publisher interface {
-	Subscribe(observer Observer)
+	Subscribe(observer Observer, filters ...EventFilter)
 	Unsubscribe(observer Observer)
 	Notify(event Event)
 }
@@ -49,52 +61,166 @@ type ChatMessage struct {
 	Timestamp time.Time `json:"timestamp"`
 }
 
+// connectionWriteTimeout es el tiempo máximo para escribir un evento a un cliente
+const connectionWriteTimeout = 10 * time.Second
+
 // ConnectionObserver implementa Observer para manejar conexiones WebSocket
 type ConnectionObserver struct {
 	id         string
 	conn       *websocket.Conn
 	username   string
-	sendChan   chan Event
+	room       string
+	ip         string
+	token      string
+	moderator  bool
+	connectedAt time.Time
+	queue      *eventQueue // eventos pendientes, con la política de backpressure de la conexión
 	closeChan  chan bool
+	closeOnce  sync.Once
+	disconnectChan chan Event
+	log        *slog.Logger // logger del pedido que abrió la conexión
+	mutex      sync.RWMutex
 }
 
 func NewConnectionObserver(id string, conn *websocket.Conn) *ConnectionObserver {
+	return NewConnectionObserverWithBackpressure(id, conn, DefaultBackpressureSettings().Connections)
+}
+
+// NewConnectionObserverWithBackpressure crea la conexión con su propia política de cola llena
+func NewConnectionObserverWithBackpressure(id string, conn *websocket.Conn, config BackpressureConfig) *ConnectionObserver {
 	return &ConnectionObserver{
 		id:        id,
 		conn:      conn,
-		sendChan:  make(chan Event, 100),
+		room:      DefaultRoom,
+		connectedAt: time.Now(),
+		queue:     newEventQueue(config),
 		closeChan: make(chan bool),
+		disconnectChan: make(chan Event, 1),
 	}
 }
 
 func (co *ConnectionObserver) Update(event Event) {
-	select {
-	case co.sendChan <- event:
-	default:
-		// Si el canal está lleno, log pero no bloquea
-		fmt.Printf("Warning: Notification queue full for observer %s\n", co.id)
+	if !co.queue.Push(event) {
+		// Política disconnect: el cliente no da abasto
+		co.Logger().Warn("notification queue full, disconnecting slow consumer")
+		co.Disconnect(Event{
+			Type:      SystemEvent,
+			Message:   "Conexión cerrada: no estás recibiendo los mensajes a tiempo",
+			Data:      map[string]interface{}{"slow_consumer": true},
+			Timestamp: time.Now(),
+		})
 	}
 }
 
+// GetStats retorna el estado de la cola de la conexión
+func (co *ConnectionObserver) GetStats() map[string]interface{} {
+	return co.queue.GetStats()
+}
+
 func (co *ConnectionObserver) GetID() string {
 	return co.id
 }
 
+// ConnectedAt devuelve cuándo se abrió la conexión
+func (co *ConnectionObserver) ConnectedAt() time.Time {
+	return co.connectedAt
+}
+
 func (co *ConnectionObserver) SetUsername(username string) {
+	co.mutex.Lock()
+	defer co.mutex.Unlock()
 	co.username = username
 }
 
 func (co *ConnectionObserver) GetUsername() string {
+	co.mutex.RLock()
+	defer co.mutex.RUnlock()
 	return co.username
 }
 
+func (co *ConnectionObserver) SetRoom(room string) {
+	co.mutex.Lock()
+	defer co.mutex.Unlock()
+	co.room = room
+}
+
+func (co *ConnectionObserver) GetRoom() string {
+	co.mutex.RLock()
+	defer co.mutex.RUnlock()
+	return co.room
+}
+
+// SetIdentity guarda los datos con los que se abrió la conexión
+func (co *ConnectionObserver) SetIdentity(ip, token string, moderator bool) {
+	co.mutex.Lock()
+	defer co.mutex.Unlock()
+	co.ip = ip
+	co.token = token
+	co.moderator = moderator
+}
+
+func (co *ConnectionObserver) GetIP() string {
+	co.mutex.RLock()
+	defer co.mutex.RUnlock()
+	return co.ip
+}
+
+func (co *ConnectionObserver) GetToken() string {
+	co.mutex.RLock()
+	defer co.mutex.RUnlock()
+	return co.token
+}
+
+// SetLogger usa el logger del pedido HTTP como base de los logs de la conexión
+func (co *ConnectionObserver) SetLogger(logger *slog.Logger) {
+	co.mutex.Lock()
+	defer co.mutex.Unlock()
+	co.log = logger
+}
+
+// Logger devuelve un logger con el ID, el usuario y la sala de la conexión
+func (co *ConnectionObserver) Logger() *slog.Logger {
+	co.mutex.RLock()
+	defer co.mutex.RUnlock()
+	base := co.log
+	if base == nil {
+		base = slog.Default()
+	}
+	return base.With("conn_id", co.id, "user", co.username, "room", co.room)
+}
+
+// IsModerator indica si la conexión se autenticó como moderador
+func (co *ConnectionObserver) IsModerator() bool {
+	co.mutex.RLock()
+	defer co.mutex.RUnlock()
+	return co.moderator
+}
+
+// Disconnect envía un último evento al cliente y cierra la conexión
+func (co *ConnectionObserver) Disconnect(event Event) {
+	select {
+	case co.disconnectChan <- event:
+	default:
+		// Ya hay una desconexión en curso
+	}
+}
+
 // StartListening inicia el proceso de escucha para enviar mensajes al cliente
 func (co *ConnectionObserver) StartListening() {
 	go func() {
 		for {
 			select {
-			case event := <-co.sendChan:
+			case <-co.queue.ready:
+				if !co.drain() {
+					return
+				}
+			case event := <-co.disconnectChan:
 				co.sendEventToClient(event)
+				co.conn.WriteControl(websocket.CloseMessage,
+					websocket.FormatCloseMessage(websocket.ClosePolicyViolation, ""),
+					time.Now().Add(time.Second))
+				co.conn.Close()
+				return
 			case <-co.closeChan:
 				return
 			}
@@ -102,39 +228,76 @@ func (co *ConnectionObserver) StartListening() {
 	}()
 }
 
-func (co *ConnectionObserver) sendEventToClient(event Event) {
+// drain envía los eventos encolados y, si se perdieron eventos, avisa al
+// cliente para que vuelva a pedir el historial
+func (co *ConnectionObserver) drain() bool {
+	for {
+		event, ok := co.queue.Pop()
+		if !ok {
+			break
+		}
+		if err := co.sendEventToClient(event); err != nil {
+			return false
+		}
+	}
+	if missed, resyncFrom := co.queue.TakeMissed(); missed > 0 {
+		return co.sendEventToClient(missedEventsNotice(missed, resyncFrom)) == nil
+	}
+	return true
+}
+
+func (co *ConnectionObserver) sendEventToClient(event Event) error {
 	eventBytes, err := json.Marshal(event)
 	if err != nil {
-		fmt.Printf("Error marshaling event: %v\n", err)
-		return
+		co.Logger().Error("could not marshal event", append(eventAttrs(event), "error", err)...)
+		return nil
 	}
 
+	co.conn.SetWriteDeadline(time.Now().Add(connectionWriteTimeout))
 	err = co.conn.WriteMessage(websocket.TextMessage, eventBytes)
 	if err != nil {
-		fmt.Printf("Error sending message to client: %v\n", err)
+		co.Logger().Warn("could not send event to client", "error", err)
 		co.Stop()
 	}
+	return err
 }
 
-// Stop cierra el observador de conexión
+// Stop cierra el observador de conexión. Se puede llamar más de una vez.
 func (co *ConnectionObserver) Stop() {
-	select {
-	case co.closeChan <- true:
-	default:
-	}
-	close(co.sendChan)
+	co.closeOnce.Do(func() {
+		close(co.closeChan)
+		co.queue.Close()
+	})
 }
 
 // EventPublisher implementa Publisher para manejar notificaciones
 type EventPublisher struct {
-	observers map[string]Observer
+	observers map[string]*subscription
+	byType    map[EventType]map[string]*subscription // suscripciones limitadas a ciertos tipos
+	anyType   map[string]*subscription               // suscripciones que reciben cualquier tipo
+	durable   map[string]*durableSubscription        // suscripciones que leen del log de eventos
+	eventLog  *EventLog                              // nil si el log está desactivado
+	broker    Broker                                 // reparte los eventos entre instancias; nil = solo locales
 	eventChan chan Event
+	config    BackpressureConfig // qué hacer cuando eventChan está lleno
+	dropped   int64
+	mutex     sync.RWMutex
 }
 
 func NewEventPublisher() *EventPublisher {
+	return NewEventPublisherWithBackpressure(DefaultBackpressureSettings().Publisher)
+}
+
+// NewEventPublisherWithBackpressure crea el publisher con su política de cola llena.
+// Admite drop_newest, drop_oldest y block; las demás se tratan como drop_newest.
+func NewEventPublisherWithBackpressure(config BackpressureConfig) *EventPublisher {
 	ep := &EventPublisher{
-		observers: make(map[string]Observer),
-		eventChan: make(chan Event, 1000),
+		observers: make(map[string]*subscription),
+		byType:    make(map[EventType]map[string]*subscription),
+		anyType:   make(map[string]*subscription),
+		durable:   make(map[string]*durableSubscription),
+		eventChan: make(chan Event, config.QueueSize),
+		config:    config,
 	}
 	
 	// Inicia el proceso de publicación en una goroutine
@@ -143,28 +306,132 @@ func NewEventPublisher() *EventPublisher {
 	return ep
 }
 
-func (ep *EventPublisher) Subscribe(observer Observer) {
-	ep.observers[observer.GetID()] = observer
+// Subscribe registra un observador. Sin filtros recibe todos los eventos; con
+// filtros solo los que cumplan alguno. Suscribir de nuevo el mismo observador
+// reemplaza sus filtros.
+func (ep *EventPublisher) Subscribe(observer Observer, filters ...EventFilter) {
+	ep.mutex.Lock()
+	defer ep.mutex.Unlock()
+	ep.remove(observer.GetID())
+
+	sub := &subscription{observer: observer, filters: filters}
+	ep.observers[observer.GetID()] = sub
+	types := sub.eventTypes()
+	if types == nil {
+		ep.anyType[observer.GetID()] = sub
+		return
+	}
+	for _, eventType := range types {
+		if ep.byType[eventType] == nil {
+			ep.byType[eventType] = make(map[string]*subscription)
+		}
+		ep.byType[eventType][observer.GetID()] = sub
+	}
 }
 
 func (ep *EventPublisher) Unsubscribe(observer Observer) {
-	delete(ep.observers, observer.GetID())
+	ep.mutex.Lock()
+	defer ep.mutex.Unlock()
+	ep.remove(observer.GetID())
+}
+
+// remove quita una suscripción de los índices; se llama con el lock tomado
+func (ep *EventPublisher) remove(id string) {
+	if ds, exists := ep.durable[id]; exists {
+		close(ds.stop)
+		delete(ep.durable, id)
+	}
+	if _, exists := ep.observers[id]; !exists {
+		return
+	}
+	delete(ep.observers, id)
+	delete(ep.anyType, id)
+	for eventType, subs := range ep.byType {
+		delete(subs, id)
+		if len(subs) == 0 {
+			delete(ep.byType, eventType)
+		}
+	}
+}
+
+// recipients devuelve los observadores que deben recibir el evento. Los
+// filtros se evalúan una sola vez aquí, y solo para las suscripciones de ese tipo.
+func (ep *EventPublisher) recipients(event Event) []Observer {
+	ep.mutex.RLock()
+	defer ep.mutex.RUnlock()
+	recipients := make([]Observer, 0, len(ep.anyType)+len(ep.byType[event.Type]))
+	for _, sub := range ep.anyType {
+		if sub.matches(event) {
+			recipients = append(recipients, sub.observer)
+		}
+	}
+	for _, sub := range ep.byType[event.Type] {
+		if sub.matches(event) {
+			recipients = append(recipients, sub.observer)
+		}
+	}
+	return recipients
 }
 
 func (ep *EventPublisher) Notify(event Event) {
 	select {
 	case ep.eventChan <- event:
+		return
 	default:
-		fmt.Printf("Warning: Event queue full\n")
+	}
+
+	switch ep.config.Policy {
+	case BlockWithTimeout:
+		timer := time.NewTimer(time.Duration(ep.config.BlockTimeout))
+		defer timer.Stop()
+		select {
+		case ep.eventChan <- event:
+			return
+		case <-timer.C:
+		}
+	case DropOldest:
+		select {
+		case <-ep.eventChan:
+			atomic.AddInt64(&ep.dropped, 1)
+		default:
+		}
+		select {
+		case ep.eventChan <- event:
+			componentLog("publisher").Warn("event queue full, dropped oldest event")
+			return
+		default:
+		}
+	}
+	atomic.AddInt64(&ep.dropped, 1)
+	componentLog("publisher").Warn("event queue full, dropped event", eventAttrs(event)...)
+}
+
+// GetStats retorna el estado de la cola del publisher
+func (ep *EventPublisher) GetStats() map[string]interface{} {
+	ep.mutex.RLock()
+	subscribers := len(ep.observers)
+	ep.mutex.RUnlock()
+	return map[string]interface{}{
+		"policy":      ep.config.Policy,
+		"queue_size":  cap(ep.eventChan),
+		"queued":      len(ep.eventChan),
+		"dropped":     atomic.LoadInt64(&ep.dropped),
+		"subscribers": subscribers,
 	}
 }
 
 // PublishEvent es un método conveniente para publicar eventos
 func (ep *EventPublisher) PublishEvent(eventType EventType, message, username string, data map[string]interface{}) {
+	ep.PublishRoomEvent(eventType, "", message, username, data)
+}
+
+// PublishRoomEvent publica un evento dirigido solo a las conexiones de una sala
+func (ep *EventPublisher) PublishRoomEvent(eventType EventType, room, message, username string, data map[string]interface{}) {
 	event := Event{
 		Type:      eventType,
 		Message:   message,
 		Username:  username,
+		Room:      room,
 		Data:      data,
 		Timestamp: time.Now(),
 	}
@@ -173,9 +440,31 @@ func (ep *EventPublisher) PublishEvent(eventType EventType, message, username st
 
 func (ep *EventPublisher) processEvents() {
 	for event := range ep.eventChan {
-		for _, observer := range ep.observers {
+		broker := ep.Broker()
+		local := event.Node == ""
+		if local && broker != nil {
+			event.Node = broker.NodeID()
+		}
+
+		// Con el log activo, el evento se guarda antes de entregarse y recibe su offset
+		if el := ep.EventLog(); el != nil {
+			logged, err := el.Append(event)
+			if err != nil {
+				componentLog("eventlog").Error("could not append event", append(eventAttrs(event), "error", err)...)
+			} else {
+				event = logged
+			}
+		}
+		for _, observer := range ep.recipients(event) {
 			go observer.Update(event)
 		}
+
+		// Solo se reenvían los eventos locales, para no devolver los que llegaron de otra instancia
+		if local && broker != nil {
+			if err := broker.Publish(event); err != nil {
+				componentLog("broker").Error("could not publish event", append(eventAttrs(event), "error", err)...)
+			}
+		}
 	}
 }
 
@@ -189,12 +478,11 @@ func NewLoggerObserver() *LoggerObserver {
 }
 
 func (lo *LoggerObserver) Update(event Event) {
-	formattedTime := event.Timestamp.Format("15:04:05")
-	fmt.Printf("[%s] %s: %s", formattedTime, string(event.Type), event.Message)
-	if event.Username != "" {
-		fmt.Printf(" (de: %s)", event.Username)
+	attrs := eventAttrs(event)
+	if event.Message != "" {
+		attrs = append(attrs, "message", event.Message)
 	}
-	fmt.Printf("\n")
+	componentLog("events").Info("event published", attrs...)
 }
 
 func (lo *LoggerObserver) GetID() string {
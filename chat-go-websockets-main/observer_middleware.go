@@ -0,0 +1,433 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/rand"
+	"os"
+	"runtime/debug"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ObserverMiddleware envuelve un Observer para agregarle comportamiento sin
+// tocar el observador: reintentos, tiempo máximo, recuperación de panics,
+// muestreo, lotes y métricas.
+//
+//	observer := ChainObserver(NewStatsObserver(),
+//	    WithMetrics(),
+//	    WithTimeout(time.Second),
+//	    WithRecovery(),
+//	)
+//	publisher.Subscribe(observer)
+type ObserverMiddleware func(Observer) Observer
+
+// ChainObserver aplica los middlewares en orden: el primero es el más externo
+// y el último queda pegado al observador
+func ChainObserver(observer Observer, middlewares ...ObserverMiddleware) Observer {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		observer = middlewares[i](observer)
+	}
+	return observer
+}
+
+// FallibleObserver es un observador que puede informar que no procesó un
+// evento, para que WithRetry lo reintente
+type FallibleObserver interface {
+	Observer
+	Handle(event Event) error
+}
+
+// BatchObserver es un observador que procesa varios eventos juntos; WithBatching
+// le entrega los lotes enteros
+type BatchObserver interface {
+	Observer
+	UpdateBatch(events []Event) error
+}
+
+// ErrObserverTimeout indica que un observador superó su tiempo máximo
+var ErrObserverTimeout = errors.New("el observador superó su tiempo máximo")
+
+// PanicError es el error de un observador que entró en panic
+type PanicError struct {
+	Value interface{}
+	Stack []byte
+}
+
+func (pe *PanicError) Error() string {
+	return fmt.Sprintf("panic: %v", pe.Value)
+}
+
+// handleEvent entrega un evento y devuelve el error si el observador lo informa
+func handleEvent(observer Observer, event Event) error {
+	if fallible, ok := observer.(FallibleObserver); ok {
+		return fallible.Handle(event)
+	}
+	observer.Update(event)
+	return nil
+}
+
+// middlewareObserver es el Observer que arma cada middleware. Conserva el ID
+// del observador envuelto, así Unsubscribe funciona con cualquiera de los dos.
+type middlewareObserver struct {
+	next   Observer
+	handle func(event Event) error
+}
+
+func (mo *middlewareObserver) Update(event Event) {
+	mo.handle(event)
+}
+
+func (mo *middlewareObserver) Handle(event Event) error {
+	return mo.handle(event)
+}
+
+func (mo *middlewareObserver) GetID() string {
+	return mo.next.GetID()
+}
+
+// Unwrap devuelve el observador envuelto
+func (mo *middlewareObserver) Unwrap() Observer {
+	return mo.next
+}
+
+// WithRecovery convierte un panic del observador en un error y lo registra,
+// en lugar de perder la goroutine sin ningún aviso
+func WithRecovery() ObserverMiddleware {
+	return func(next Observer) Observer {
+		return &middlewareObserver{next: next, handle: func(event Event) (err error) {
+			defer func() {
+				if value := recover(); value != nil {
+					panicErr := &PanicError{Value: value, Stack: debug.Stack()}
+					componentLog("observers").Error("observer panicked", "observer", next.GetID(), "event_type", event.Type, "panic", value, "stack", string(panicErr.Stack))
+					err = panicErr
+				}
+			}()
+			return handleEvent(next, event)
+		}}
+	}
+}
+
+// WithTimeout devuelve ErrObserverTimeout si el observador tarda más de
+// timeout. El observador no se puede interrumpir: sigue corriendo, pero quien
+// lo llamó ya no lo espera.
+func WithTimeout(timeout time.Duration) ObserverMiddleware {
+	return func(next Observer) Observer {
+		return &middlewareObserver{next: next, handle: func(event Event) error {
+			done := make(chan error, 1)
+			go func() {
+				done <- handleEvent(next, event)
+			}()
+			timer := time.NewTimer(timeout)
+			defer timer.Stop()
+			select {
+			case err := <-done:
+				return err
+			case <-timer.C:
+				componentLog("observers").Warn("observer timed out", "observer", next.GetID(), "event_type", event.Type, "timeout", timeout)
+				return ErrObserverTimeout
+			}
+		}}
+	}
+}
+
+// WithRetry reintenta un evento fallido hasta retries veces, duplicando la
+// espera entre intentos. Los timeouts y los panics no se reintentan: un
+// observador que superó su tiempo sigue corriendo, y reintentarlo lo
+// ejecutaría dos veces a la vez.
+func WithRetry(retries int, backoff time.Duration) ObserverMiddleware {
+	return func(next Observer) Observer {
+		return &middlewareObserver{next: next, handle: func(event Event) error {
+			wait := backoff
+			attempts := 1
+			err := handleEvent(next, event)
+			for ; retryable(err) && attempts <= retries; attempts++ {
+				time.Sleep(wait)
+				wait *= 2
+				metricsFor(next.GetID()).addRetry()
+				err = handleEvent(next, event)
+			}
+			if err != nil && retries > 0 {
+				componentLog("observers").Error("observer failed", "observer", next.GetID(), "event_type", event.Type, "attempts", attempts, "error", err)
+			}
+			return err
+		}}
+	}
+}
+
+// retryable indica si vale la pena reintentar un error del observador
+func retryable(err error) bool {
+	var panicErr *PanicError
+	return err != nil && !errors.Is(err, ErrObserverTimeout) && !errors.As(err, &panicErr)
+}
+
+// WithSampling entrega solo una fracción (rate, entre 0 y 1) de los eventos de
+// los tipos indicados; los demás tipos pasan siempre. Sin tipos, se muestrean todos.
+func WithSampling(rate float64, types ...EventType) ObserverMiddleware {
+	return func(next Observer) Observer {
+		if rate >= 1 {
+			return next
+		}
+		return &middlewareObserver{next: next, handle: func(event Event) error {
+			if (len(types) == 0 || containsEventType(types, event.Type)) && rand.Float64() >= rate {
+				metricsFor(next.GetID()).addSkipped()
+				return nil
+			}
+			return handleEvent(next, event)
+		}}
+	}
+}
+
+// WithBatching junta los eventos y los entrega cuando hay size o pasó
+// interval desde el primero. Los lotes se entregan en orden desde una sola
+// goroutine; si el observador no es un BatchObserver, recibe los eventos de a uno.
+func WithBatching(size int, interval time.Duration) ObserverMiddleware {
+	return func(next Observer) Observer {
+		batcher := &eventBatcher{next: next, size: size, interval: interval, ready: make(chan struct{}, 1)}
+		go batcher.run()
+		return &middlewareObserver{next: next, handle: batcher.add}
+	}
+}
+
+type eventBatcher struct {
+	next     Observer
+	size     int
+	interval time.Duration
+	pending  []Event
+	ready    chan struct{} // avisa que se llenó un lote
+	mutex    sync.Mutex
+}
+
+func (eb *eventBatcher) add(event Event) error {
+	eb.mutex.Lock()
+	eb.pending = append(eb.pending, event)
+	full := len(eb.pending) >= eb.size
+	eb.mutex.Unlock()
+	if full {
+		signal(eb.ready)
+	}
+	return nil
+}
+
+func (eb *eventBatcher) run() {
+	ticker := time.NewTicker(eb.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-eb.ready:
+		}
+		eb.mutex.Lock()
+		batch := eb.pending
+		eb.pending = nil
+		eb.mutex.Unlock()
+		if len(batch) > 0 {
+			eb.flush(batch)
+		}
+	}
+}
+
+func (eb *eventBatcher) flush(batch []Event) {
+	if batchObserver, ok := eb.next.(BatchObserver); ok {
+		batchObserver.UpdateBatch(batch)
+		return
+	}
+	for _, event := range batch {
+		handleEvent(eb.next, event)
+	}
+}
+
+// WithMetrics mide cuántos eventos procesa el observador, cuánto tarda y
+// cuántos fallan. Las métricas se ven en GET /moderation/connections.
+func WithMetrics() ObserverMiddleware {
+	return func(next Observer) Observer {
+		metrics := metricsFor(next.GetID())
+		return &middlewareObserver{next: next, handle: func(event Event) error {
+			start := time.Now()
+			err := handleEvent(next, event)
+			metrics.record(time.Since(start), err)
+			return err
+		}}
+	}
+}
+
+// ObserverMetrics son las métricas de un observador
+type ObserverMetrics struct {
+	events     int64
+	errors     int64
+	panics     int64
+	timeouts   int64
+	retries    int64
+	skipped    int64 // descartados por muestreo
+	totalNanos int64
+	maxNanos   int64
+}
+
+func (om *ObserverMetrics) record(elapsed time.Duration, err error) {
+	atomic.AddInt64(&om.events, 1)
+	atomic.AddInt64(&om.totalNanos, int64(elapsed))
+	for {
+		max := atomic.LoadInt64(&om.maxNanos)
+		if int64(elapsed) <= max || atomic.CompareAndSwapInt64(&om.maxNanos, max, int64(elapsed)) {
+			break
+		}
+	}
+	if err == nil {
+		return
+	}
+	atomic.AddInt64(&om.errors, 1)
+	var panicErr *PanicError
+	switch {
+	case errors.As(err, &panicErr):
+		atomic.AddInt64(&om.panics, 1)
+	case errors.Is(err, ErrObserverTimeout):
+		atomic.AddInt64(&om.timeouts, 1)
+	}
+}
+
+func (om *ObserverMetrics) addRetry() {
+	atomic.AddInt64(&om.retries, 1)
+}
+
+func (om *ObserverMetrics) addSkipped() {
+	atomic.AddInt64(&om.skipped, 1)
+}
+
+func (om *ObserverMetrics) GetStats() map[string]interface{} {
+	events := atomic.LoadInt64(&om.events)
+	average := time.Duration(0)
+	if events > 0 {
+		average = time.Duration(atomic.LoadInt64(&om.totalNanos) / events)
+	}
+	return map[string]interface{}{
+		"events":      events,
+		"errors":      atomic.LoadInt64(&om.errors),
+		"panics":      atomic.LoadInt64(&om.panics),
+		"timeouts":    atomic.LoadInt64(&om.timeouts),
+		"retries":     atomic.LoadInt64(&om.retries),
+		"sampled_out": atomic.LoadInt64(&om.skipped),
+		"avg_latency": average.String(),
+		"max_latency": time.Duration(atomic.LoadInt64(&om.maxNanos)).String(),
+	}
+}
+
+// observerMetrics guarda las métricas por ID de observador, así los distintos
+// middlewares de una cadena registran en el mismo lugar
+var observerMetrics = struct {
+	byID  map[string]*ObserverMetrics
+	mutex sync.Mutex
+}{byID: make(map[string]*ObserverMetrics)}
+
+func metricsFor(id string) *ObserverMetrics {
+	observerMetrics.mutex.Lock()
+	defer observerMetrics.mutex.Unlock()
+	metrics, exists := observerMetrics.byID[id]
+	if !exists {
+		metrics = &ObserverMetrics{}
+		observerMetrics.byID[id] = metrics
+	}
+	return metrics
+}
+
+// ObserverMetricsStats devuelve las métricas de todos los observadores medidos
+func ObserverMetricsStats() map[string]interface{} {
+	observerMetrics.mutex.Lock()
+	ids := make([]string, 0, len(observerMetrics.byID))
+	for id := range observerMetrics.byID {
+		ids = append(ids, id)
+	}
+	observerMetrics.mutex.Unlock()
+	sort.Strings(ids)
+
+	stats := make(map[string]interface{}, len(ids))
+	for _, id := range ids {
+		stats[id] = metricsFor(id).GetStats()
+	}
+	return stats
+}
+
+// ObserverChainConfig configura la cadena de middlewares de un observador.
+// Los valores en cero desactivan el middleware correspondiente.
+type ObserverChainConfig struct {
+	Timeout       Duration    `json:"timeout"`
+	Retries       int         `json:"retries"`
+	RetryBackoff  Duration    `json:"retry_backoff"`
+	SampleRate    float64     `json:"sample_rate"`  // 0 o 1 = todos los eventos
+	SampleTypes   []EventType `json:"sample_types"` // tipos a muestrear; vacío = todos
+	BatchSize     int         `json:"batch_size"`
+	BatchInterval Duration    `json:"batch_interval"`
+}
+
+// Wrap arma la cadena: muestreo, lotes, métricas, reintentos, tiempo máximo y
+// recuperación de panics. Métricas y recuperación van siempre.
+func (cc ObserverChainConfig) Wrap(observer Observer) Observer {
+	middlewares := []ObserverMiddleware{}
+	if cc.SampleRate > 0 && cc.SampleRate < 1 {
+		middlewares = append(middlewares, WithSampling(cc.SampleRate, cc.SampleTypes...))
+	}
+	if cc.BatchSize > 1 {
+		interval := time.Duration(cc.BatchInterval)
+		if interval <= 0 {
+			interval = time.Second
+		}
+		middlewares = append(middlewares, WithBatching(cc.BatchSize, interval))
+	}
+	middlewares = append(middlewares, WithMetrics())
+	if cc.Retries > 0 {
+		middlewares = append(middlewares, WithRetry(cc.Retries, time.Duration(cc.RetryBackoff)))
+	}
+	if cc.Timeout > 0 {
+		middlewares = append(middlewares, WithTimeout(time.Duration(cc.Timeout)))
+	}
+	middlewares = append(middlewares, WithRecovery())
+	return ChainObserver(observer, middlewares...)
+}
+
+// ObserverMiddlewareSettings agrupa las cadenas de los observadores del servidor
+type ObserverMiddlewareSettings struct {
+	Logger     ObserverChainConfig `json:"logger"`
+	Stats      ObserverChainConfig `json:"stats"`
+	Moderation ObserverChainConfig `json:"moderation"`
+}
+
+func DefaultObserverMiddlewareSettings() ObserverMiddlewareSettings {
+	return ObserverMiddlewareSettings{
+		// El logger escribe en lotes y en orden, sin bloquear a nadie
+		Logger: ObserverChainConfig{
+			BatchSize:     50,
+			BatchInterval: Duration(200 * time.Millisecond),
+		},
+		Stats: ObserverChainConfig{
+			Timeout: Duration(time.Second),
+		},
+		Moderation: ObserverChainConfig{
+			Timeout:      Duration(2 * time.Second),
+			Retries:      2,
+			RetryBackoff: Duration(50 * time.Millisecond),
+		},
+	}
+}
+
+// LoadObserverMiddlewareSettings carga OBSERVER_MIDDLEWARE_FILE o usa la configuración por defecto
+func LoadObserverMiddlewareSettings() ObserverMiddlewareSettings {
+	settings := DefaultObserverMiddlewareSettings()
+
+	path := os.Getenv("OBSERVER_MIDDLEWARE_FILE")
+	if path == "" {
+		return settings
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		slog.Warn("could not read observer middleware config", "path", path, "error", err)
+		return settings
+	}
+	if err := json.Unmarshal(data, &settings); err != nil {
+		slog.Warn("invalid observer middleware config", "path", path, "error", err)
+		return DefaultObserverMiddlewareSettings()
+	}
+	return settings
+}
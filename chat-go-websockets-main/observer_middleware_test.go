@@ -0,0 +1,66 @@
+package main
+
+import (
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// countingObserver cuenta las llamadas y responde con handle
+type countingObserver struct {
+	calls  int64
+	handle func(call int64) error
+}
+
+func (co *countingObserver) Update(event Event) { co.Handle(event) }
+
+func (co *countingObserver) Handle(event Event) error {
+	return co.handle(atomic.AddInt64(&co.calls, 1))
+}
+
+func (co *countingObserver) GetID() string { return "prueba_reintentos" }
+
+func TestRetrySkipsTimeoutsAndPanics(t *testing.T) {
+	tests := []struct {
+		name   string
+		handle func(call int64) error
+		calls  int64
+	}{
+		{
+			name:   "error común",
+			handle: func(call int64) error { return errors.New("falla") },
+			calls:  3,
+		},
+		{
+			name: "timeout",
+			handle: func(call int64) error {
+				time.Sleep(50 * time.Millisecond)
+				return nil
+			},
+			calls: 1,
+		},
+		{
+			name:   "panic",
+			handle: func(call int64) error { panic("roto") },
+			calls:  1,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			inner := &countingObserver{handle: test.handle}
+			observer := ObserverChainConfig{
+				Timeout: Duration(10 * time.Millisecond),
+				Retries: 2,
+			}.Wrap(inner)
+
+			observer.(FallibleObserver).Handle(Event{Type: MessageEvent})
+			// Dar tiempo a que terminen los intentos que quedaron corriendo
+			time.Sleep(100 * time.Millisecond)
+			if calls := atomic.LoadInt64(&inner.calls); calls != test.calls {
+				t.Errorf("el observador se llamó %d veces, se esperaban %d", calls, test.calls)
+			}
+		})
+	}
+}
@@ -0,0 +1,478 @@
+package main
+
+import (
+	"encoding/json"
+	"log/slog"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
+)
+
+// InboundMessage es un mensaje recibido de un cliente mientras recorre el
+// pipeline. Las etapas pueden modificar Text, agregar anotaciones o rechazarlo.
+type InboundMessage struct {
+	Chat        ChatMessage
+	Text        string // texto que se va a publicar
+	Observer    *ConnectionObserver
+	IP          string
+	Token       string
+	Hook        *IncomingHook    // webhook entrante que envió el mensaje; nil si vino de una conexión
+	Context     MessageContext   // lo completa la etapa de moderación
+	Result      ModerationResult // resultado de la moderación
+	Annotations map[string]interface{}
+	Rejection   string // por qué se rechazó, si se rechazó
+}
+
+// Annotate agrega un dato que se publica con el mensaje, en data.annotations
+func (im *InboundMessage) Annotate(key string, value interface{}) {
+	if im.Annotations == nil {
+		im.Annotations = make(map[string]interface{})
+	}
+	im.Annotations[key] = value
+}
+
+// reject marca el mensaje como rechazado
+func (im *InboundMessage) reject(reason string) Verdict {
+	im.Rejection = reason
+	return VerdictReject
+}
+
+// Verdict es lo que decide una etapa sobre el mensaje
+type Verdict string
+
+const (
+	VerdictContinue Verdict = "continue" // pasar a la siguiente etapa
+	VerdictReject   Verdict = "reject"   // no publicar el mensaje
+	VerdictHandled  Verdict = "handled"  // la etapa ya se ocupó del mensaje, por ejemplo un comando
+)
+
+// Interceptor es una etapa del pipeline. Las etapas se ocupan de avisar al
+// usuario cuando rechazan su mensaje.
+type Interceptor interface {
+	Name() string
+	Intercept(msg *InboundMessage) Verdict
+}
+
+// InterceptorFactory crea una etapa para un servidor
+type InterceptorFactory func(s *Server, config PipelineConfig) Interceptor
+
+// interceptors son las etapas disponibles para PIPELINE_CONFIG_FILE
+var interceptors = struct {
+	factories map[string]InterceptorFactory
+	mutex     sync.RWMutex
+}{factories: map[string]InterceptorFactory{
+	"auth":       func(s *Server, _ PipelineConfig) Interceptor { return &authInterceptor{server: s} },
+	"rate_limit": func(s *Server, c PipelineConfig) Interceptor { return newRateLimitInterceptor(c.RateLimit) },
+	"commands":   func(s *Server, _ PipelineConfig) Interceptor { return &commandInterceptor{server: s} },
+	"moderation": func(s *Server, _ PipelineConfig) Interceptor { return &moderationInterceptor{server: s} },
+	"enrichment": func(s *Server, _ PipelineConfig) Interceptor { return &enrichmentInterceptor{server: s} },
+}}
+
+// RegisterInterceptor agrega una etapa que se puede usar por nombre en la configuración
+func RegisterInterceptor(name string, factory InterceptorFactory) {
+	interceptors.mutex.Lock()
+	defer interceptors.mutex.Unlock()
+	interceptors.factories[name] = factory
+}
+
+// RateLimitConfig limita cuántos mensajes puede enviar una conexión
+type RateLimitConfig struct {
+	Messages int      `json:"messages"` // mensajes permitidos por ventana
+	Window   Duration `json:"window"`
+}
+
+// PipelineConfig configura las etapas del pipeline de mensajes entrantes
+type PipelineConfig struct {
+	Stages    []string        `json:"stages"` // orden de las etapas; las que no figuran no se usan
+	RateLimit RateLimitConfig `json:"rate_limit"`
+}
+
+func DefaultPipelineConfig() PipelineConfig {
+	return PipelineConfig{
+		Stages: []string{"auth", "rate_limit", "commands", "moderation", "enrichment"},
+		RateLimit: RateLimitConfig{
+			Messages: 20,
+			Window:   Duration(10 * time.Second),
+		},
+	}
+}
+
+// LoadPipelineConfig carga PIPELINE_CONFIG_FILE o usa la configuración por defecto
+func LoadPipelineConfig() PipelineConfig {
+	config := DefaultPipelineConfig()
+
+	path := os.Getenv("PIPELINE_CONFIG_FILE")
+	if path == "" {
+		return config
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		slog.Warn("could not read pipeline config", "path", path, "error", err)
+		return config
+	}
+	if err := json.Unmarshal(data, &config); err != nil {
+		slog.Warn("invalid pipeline config", "path", path, "error", err)
+		return DefaultPipelineConfig()
+	}
+	return config
+}
+
+// stageStats cuenta las decisiones de una etapa
+type stageStats struct {
+	verdicts   map[Verdict]int64
+	totalNanos int64
+}
+
+// MessagePipeline pasa cada mensaje recibido por sus etapas, en orden, antes de publicarlo
+type MessagePipeline struct {
+	stages    []Interceptor
+	stats     map[string]*stageStats
+	processed int64
+	accepted  int64
+	mutex     sync.Mutex
+}
+
+func NewMessagePipeline(stages ...Interceptor) *MessagePipeline {
+	mp := &MessagePipeline{stages: stages, stats: make(map[string]*stageStats)}
+	for _, stage := range stages {
+		mp.stats[stage.Name()] = &stageStats{verdicts: make(map[Verdict]int64)}
+	}
+	return mp
+}
+
+// newMessagePipeline arma el pipeline del servidor según la configuración
+func (s *Server) newMessagePipeline(config PipelineConfig) *MessagePipeline {
+	stages := []Interceptor{}
+	interceptors.mutex.RLock()
+	for _, name := range config.Stages {
+		factory, exists := interceptors.factories[name]
+		if !exists {
+			componentLog("pipeline").Warn("unknown pipeline stage", "stage", name)
+			continue
+		}
+		stages = append(stages, factory(s, config))
+	}
+	interceptors.mutex.RUnlock()
+
+	names := make([]string, len(stages))
+	for i, stage := range stages {
+		names[i] = stage.Name()
+	}
+	if !containsString(names, "moderation") {
+		componentLog("pipeline").Warn("pipeline has no moderation stage; messages are published unmoderated")
+	}
+	componentLog("pipeline").Info("pipeline configured", "stages", names)
+	return NewMessagePipeline(stages...)
+}
+
+func containsString(values []string, value string) bool {
+	for _, candidate := range values {
+		if candidate == value {
+			return true
+		}
+	}
+	return false
+}
+
+// Process pasa el mensaje por las etapas hasta que una lo rechace o se ocupe
+// de él. Devuelve VerdictContinue si el mensaje se puede publicar.
+func (mp *MessagePipeline) Process(msg *InboundMessage) Verdict {
+	atomic.AddInt64(&mp.processed, 1)
+	for _, stage := range mp.stages {
+		start := time.Now()
+		verdict := stage.Intercept(msg)
+		mp.record(stage.Name(), verdict, time.Since(start))
+		if verdict != VerdictContinue {
+			return verdict
+		}
+	}
+	atomic.AddInt64(&mp.accepted, 1)
+	return VerdictContinue
+}
+
+func (mp *MessagePipeline) record(stage string, verdict Verdict, elapsed time.Duration) {
+	mp.mutex.Lock()
+	defer mp.mutex.Unlock()
+	stats := mp.stats[stage]
+	stats.verdicts[verdict]++
+	stats.totalNanos += int64(elapsed)
+}
+
+// GetStats retorna cuántos mensajes pasaron por cada etapa y qué decidió
+func (mp *MessagePipeline) GetStats() map[string]interface{} {
+	mp.mutex.Lock()
+	defer mp.mutex.Unlock()
+	stages := make([]map[string]interface{}, 0, len(mp.stages))
+	for _, stage := range mp.stages {
+		stats := mp.stats[stage.Name()]
+		total := int64(0)
+		for _, count := range stats.verdicts {
+			total += count
+		}
+		average := time.Duration(0)
+		if total > 0 {
+			average = time.Duration(stats.totalNanos / total)
+		}
+		stages = append(stages, map[string]interface{}{
+			"name":        stage.Name(),
+			"continued":   stats.verdicts[VerdictContinue],
+			"rejected":    stats.verdicts[VerdictReject],
+			"handled":     stats.verdicts[VerdictHandled],
+			"avg_latency": average.String(),
+		})
+	}
+	return map[string]interface{}{
+		"processed": atomic.LoadInt64(&mp.processed),
+		"accepted":  atomic.LoadInt64(&mp.accepted),
+		"stages":    stages,
+	}
+}
+
+// authInterceptor rechaza los mensajes de usuarios con un ban o un silencio vigente
+type authInterceptor struct {
+	server *Server
+}
+
+func (ai *authInterceptor) Name() string {
+	return "auth"
+}
+
+func (ai *authInterceptor) Intercept(msg *InboundMessage) Verdict {
+	s, observer := ai.server, msg.Observer
+	if sanction, banned := s.sanctions.IsBanned(observer.GetUsername(), msg.IP, msg.Token); banned {
+		s.applySanction(observer, &sanction)
+		return msg.reject("banned")
+	}
+	if sanction, muted := s.sanctions.IsMuted(observer.GetUsername(), msg.Token); muted {
+		s.applySanction(observer, &sanction)
+		return msg.reject("muted")
+	}
+	return VerdictContinue
+}
+
+// rateLimitInterceptor limita los mensajes por conexión con una ventana
+// deslizante. Los moderadores no tienen límite; los webhooks entrantes usan el suyo.
+type rateLimitInterceptor struct {
+	config RateLimitConfig
+	sent   map[string][]time.Time // conexión -> mensajes dentro de la ventana
+	calls  int
+	mutex  sync.Mutex
+}
+
+func newRateLimitInterceptor(config RateLimitConfig) *rateLimitInterceptor {
+	return &rateLimitInterceptor{config: config, sent: make(map[string][]time.Time)}
+}
+
+func (rl *rateLimitInterceptor) Name() string {
+	return "rate_limit"
+}
+
+func (rl *rateLimitInterceptor) Intercept(msg *InboundMessage) Verdict {
+	config := rl.config
+	if msg.Hook != nil {
+		config = msg.Hook.RateLimit
+	}
+	if config.Messages <= 0 || msg.Observer.IsModerator() {
+		return VerdictContinue
+	}
+	now := time.Now()
+	window := time.Duration(config.Window)
+
+	rl.mutex.Lock()
+	id := msg.Observer.GetID()
+	recent := pruneBefore(rl.sent[id], now.Add(-window))
+	allowed := len(recent) < config.Messages
+	if allowed {
+		recent = append(recent, now)
+	}
+	rl.sent[id] = recent
+	// De vez en cuando se olvidan las conexiones que ya no envían
+	if rl.calls++; rl.calls%1000 == 0 {
+		for key, times := range rl.sent {
+			if len(pruneBefore(times, now.Add(-window))) == 0 {
+				delete(rl.sent, key)
+			}
+		}
+	}
+	rl.mutex.Unlock()
+
+	if !allowed {
+		msg.Observer.Update(Event{
+			Type:      SystemEvent,
+			Message:   "Estás enviando mensajes demasiado rápido; espera unos segundos",
+			Data:      map[string]interface{}{"rate_limited": true},
+			Timestamp: now,
+		})
+		return msg.reject("rate_limited")
+	}
+	return VerdictContinue
+}
+
+func pruneBefore(times []time.Time, cutoff time.Time) []time.Time {
+	i := sort.Search(len(times), func(i int) bool { return times[i].After(cutoff) })
+	return times[i:]
+}
+
+// commandInterceptor atiende las denuncias (/report) y los comandos de moderador
+type commandInterceptor struct {
+	server *Server
+}
+
+func (ci *commandInterceptor) Name() string {
+	return "commands"
+}
+
+func (ci *commandInterceptor) Intercept(msg *InboundMessage) Verdict {
+	// Los webhooks entrantes no ejecutan comandos
+	if msg.Hook != nil {
+		return VerdictContinue
+	}
+	if ci.server.handleReportCommand(msg.Observer, msg.Text) || ci.server.handleCommand(msg.Observer, msg.Text) {
+		return VerdictHandled
+	}
+	return VerdictContinue
+}
+
+// moderationInterceptor modera el mensaje una sola vez: aplica la estrategia,
+// registra el resultado y las sanciones, y bloquea o modifica el texto
+type moderationInterceptor struct {
+	server *Server
+}
+
+func (mi *moderationInterceptor) Name() string {
+	return "moderation"
+}
+
+func (mi *moderationInterceptor) Intercept(msg *InboundMessage) Verdict {
+	s, observer := mi.server, msg.Observer
+	msg.Context = MessageContext{
+		MessageID: msg.Chat.ID,
+		Username:  observer.GetUsername(),
+		Session:   msg.Token,
+		Room:      observer.GetRoom(),
+		SenderID:  observer.GetID(),
+		IP:        msg.IP,
+		FirstSeen: s.userFirstSeen(observer.GetUsername()),
+		Language:  DetectLanguage(msg.Text),
+		Timestamp: time.Now(),
+	}
+	result := s.moderateMessage(msg.Text, msg.Context)
+	msg.Result = result
+	// Un resultado de fallback (servicio externo caído) no es un veredicto: no
+	// cuenta en las estadísticas, la sombra ni la auditoría, ni suma strikes
+	if !result.Fallback {
+		s.moderationObserver.Record(result)
+		s.shadow.Evaluate(msg.Text, msg.Context, result)
+		s.audit.RecordModeration(observer.GetUsername(), observer.GetRoom(), msg.Chat.ID, result)
+	}
+
+	// Acumular strikes y aplicar la sanción que corresponda
+	if sanction := s.sanctions.RecordResult(observer.GetUsername(), msg.Token, result); sanction != nil {
+		s.audit.RecordSanction(*sanction, observer.GetRoom(), msg.Chat.ID, result.StrategyUsed)
+		s.applySanction(observer, sanction)
+		if sanction.Type != SanctionWarning {
+			return msg.reject("sanction")
+		}
+	}
+
+	switch {
+	case result.Action == "modify":
+		msg.Text = result.ModifiedMessage
+		observer.Logger().Debug("message modified by moderation", "message_id", msg.Chat.ID, "original", result.OriginalMessage, "modified", result.ModifiedMessage)
+	case result.Action == "block":
+		// Avisar al usuario que su mensaje fue bloqueado
+		s.publisher.PublishEvent(SystemEvent, "Tu mensaje fue bloqueado: "+result.Reason, "", map[string]interface{}{
+			"blocked_message": true,
+			"message_id":      msg.Chat.ID,
+			"sender_id":       observer.GetID(),
+		})
+		return msg.reject(result.Reason)
+	case result.ModifiedMessage != "":
+		// Transformaciones que no son infracciones, como reescribir enlaces
+		msg.Text = result.ModifiedMessage
+	}
+	return VerdictContinue
+}
+
+// enrichmentInterceptor agrega datos útiles para los clientes: idioma,
+// menciones, largo y si el texto fue modificado
+type enrichmentInterceptor struct {
+	server *Server
+}
+
+func (ei *enrichmentInterceptor) Name() string {
+	return "enrichment"
+}
+
+func (ei *enrichmentInterceptor) Intercept(msg *InboundMessage) Verdict {
+	language := msg.Result.Language
+	if language == "" {
+		language = DetectLanguage(msg.Text)
+	}
+	msg.Annotate("language", language)
+	msg.Annotate("length", utf8.RuneCountInString(msg.Text))
+	if msg.Text != msg.Chat.Message {
+		msg.Annotate("edited_by_moderation", true)
+	}
+	mentions := []string{}
+	for _, match := range mentionPattern.FindAllString(msg.Text, -1) {
+		mention := strings.TrimPrefix(strings.TrimSpace(match), "@")
+		if !containsString(mentions, mention) {
+			mentions = append(mentions, mention)
+		}
+	}
+	if len(mentions) > 0 {
+		msg.Annotate("mentions", mentions)
+	}
+	return VerdictContinue
+}
+
+// publishInbound publica un mensaje que pasó el pipeline, o lo deja en la
+// cola de revisión si una regla lo pide. Devuelve false si quedó retenido.
+func (s *Server) publishInbound(msg *InboundMessage) bool {
+	observer := msg.Observer
+	item := ReviewItem{
+		ID:          msg.Chat.ID,
+		SenderID:    observer.GetID(),
+		Username:    observer.GetUsername(),
+		Room:        observer.GetRoom(),
+		Message:     msg.Text,
+		ChatMessage: msg.Chat,
+		Result:      msg.Result,
+		Annotations: msg.Annotations,
+	}
+
+	// Mensajes que requieren revisión humana
+	if rule, matched := s.review.Match(msg.Result); matched {
+		queued, ok := s.review.Enqueue(item, rule)
+		switch {
+		case ok && queued.Mode == ReviewHold:
+			observer.Update(Event{
+				Type:      SystemEvent,
+				Message:   "Tu mensaje está pendiente de revisión por un moderador",
+				Data:      map[string]interface{}{"message_id": msg.Chat.ID, "pending_review": true},
+				Timestamp: time.Now(),
+			})
+			return false
+		case ok:
+			item = queued
+		case rule.Mode != ReviewFlag && s.review.config.Fallback == "reject":
+			// Con la cola llena se aplica el fallback sin esperar al SLA
+			observer.Update(Event{
+				Type:      SystemEvent,
+				Message:   "Tu mensaje necesitaba revisión y la cola está llena; se descartó",
+				Data:      map[string]interface{}{"message_id": msg.Chat.ID},
+				Timestamp: time.Now(),
+			})
+			return false
+		}
+	}
+
+	// Publicar evento de mensaje con el contenido final
+	s.publishChatMessage(item)
+	return true
+}
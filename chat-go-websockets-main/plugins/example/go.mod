@@ -0,0 +1,3 @@
+module go-chat/plugins/example
+
+go 1.24
@@ -0,0 +1,75 @@
+// Plugin de ejemplo para la moderación del chat.
+//
+// Bloquea los mensajes que contienen "prohibido" y enmascara "feo".
+// Compilar con:
+//
+//	GOOS=wasip1 GOARCH=wasm go build -buildmode=c-shared -o ../example.wasm .
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"unsafe"
+)
+
+type request struct {
+	Message string                 `json:"message"`
+	Context map[string]interface{} `json:"context"`
+}
+
+type response struct {
+	Action          string   `json:"action"`
+	ModifiedMessage string   `json:"modified_message"`
+	Reason          string   `json:"reason"`
+	Confidence      float64  `json:"confidence"`
+	MatchedTerms    []string `json:"matched_terms"`
+}
+
+// buffers mantiene vivas las reservas hasta que termine la instancia
+var buffers [][]byte
+
+//go:wasmexport alloc
+func alloc(size uint32) uint32 {
+	buffer := make([]byte, size)
+	buffers = append(buffers, buffer)
+	return uint32(uintptr(unsafe.Pointer(unsafe.SliceData(buffer))))
+}
+
+// pack devuelve el puntero y largo de los datos como ptr<<32 | len
+func pack(data []byte) uint64 {
+	buffers = append(buffers, data)
+	ptr := uint64(uintptr(unsafe.Pointer(unsafe.SliceData(data))))
+	return ptr<<32 | uint64(len(data))
+}
+
+//go:wasmexport name
+func name() uint64 {
+	return pack([]byte("ExamplePlugin"))
+}
+
+//go:wasmexport moderate
+func moderate(ptr, size uint32) uint64 {
+	input := unsafe.Slice((*byte)(unsafe.Pointer(uintptr(ptr))), size)
+	var req request
+	if err := json.Unmarshal(input, &req); err != nil {
+		return pack([]byte(`{"action":"allow","reason":"invalid input"}`))
+	}
+
+	result := response{Action: "allow", ModifiedMessage: req.Message, Reason: "Message is clean"}
+	lower := strings.ToLower(req.Message)
+	switch {
+	case strings.Contains(lower, "prohibido"):
+		result = response{Action: "block", Reason: "Forbidden term", Confidence: 1, MatchedTerms: []string{"prohibido"}}
+	case strings.Contains(lower, "feo"):
+		result.Action = "modify"
+		result.ModifiedMessage = strings.ReplaceAll(lower, "feo", "***")
+		result.Reason = "Masked term"
+		result.Confidence = 0.8
+		result.MatchedTerms = []string{"feo"}
+	}
+
+	output, _ := json.Marshal(result)
+	return pack(output)
+}
+
+func main() {}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+)
+
+// ModerationPolicy describe en un archivo JSON una estrategia compuesta con
+// listas de palabras propias, para poder versionar y probar cambios de listas
+//
+//	{
+//	    "name": "politica-v2",
+//	    "block_words": ["spam", "scam"],
+//	    "replace_words": ["tonto", "feo"],
+//	    "replacement": "***",
+//	    "warn_words": ["amenaza"],
+//	    "strategies": ["badword"]
+//	}
+//
+// Las listas se aplican en orden bloqueo → reemplazo → advertencia y luego las
+// estrategias registradas indicadas en "strategies".
+type ModerationPolicy struct {
+	Name         string   `json:"name"`
+	BlockWords   []string `json:"block_words,omitempty"`
+	ReplaceWords []string `json:"replace_words,omitempty"`
+	Replacement  string   `json:"replacement,omitempty"`
+	WarnWords    []string `json:"warn_words,omitempty"`
+	Strategies   []string `json:"strategies,omitempty"`
+}
+
+// LoadPolicyFile lee un archivo de política y construye su estrategia
+func LoadPolicyFile(path string) (ModerationStrategy, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var policy ModerationPolicy
+	if err := json.Unmarshal(data, &policy); err != nil {
+		return nil, err
+	}
+	return policy.Build()
+}
+
+// Build construye la estrategia compuesta que describe la política
+func (p ModerationPolicy) Build() (ModerationStrategy, error) {
+	name := p.Name
+	if name == "" {
+		name = "Policy"
+	}
+	replacement := p.Replacement
+	if replacement == "" {
+		replacement = "***"
+	}
+
+	chain := []ModerationStrategy{}
+	if len(p.BlockWords) > 0 {
+		chain = append(chain, NewStrictBlockingStrategyWithWords(p.BlockWords))
+	}
+	if len(p.ReplaceWords) > 0 {
+		chain = append(chain, NewBadWordReplacementStrategyWithWords(p.ReplaceWords, replacement))
+	}
+	if len(p.WarnWords) > 0 {
+		chain = append(chain, NewWarningStrategyWithWords(p.WarnWords))
+	}
+	for _, strategyName := range p.Strategies {
+		strategy, err := strategies.Create(strategyName)
+		if err != nil {
+			return nil, err
+		}
+		chain = append(chain, strategy)
+	}
+
+	if len(chain) == 0 {
+		return nil, errors.New("la política " + name + " no define ninguna regla")
+	}
+	return NewNamedCompositeStrategy(name, chain...), nil
+}
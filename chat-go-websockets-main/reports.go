@@ -0,0 +1,333 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ReportReasons son las categorías con las que se puede denunciar un mensaje
+var ReportReasons = []string{"spam", "harassment", "hate", "sexual", "violence", "other"}
+
+// ReportConfig configura los umbrales de las denuncias
+type ReportConfig struct {
+	HideThreshold  int      `json:"hide_threshold"`   // denunciantes distintos para ocultar un mensaje
+	UserThreshold  int      `json:"user_threshold"`   // denuncias recibidas por un usuario para sumarle strikes
+	MinReporterAge Duration `json:"min_reporter_age"` // tiempo conectado antes de poder denunciar
+}
+
+func DefaultReportConfig() ReportConfig {
+	return ReportConfig{
+		HideThreshold:  3,
+		UserThreshold:  5,
+		MinReporterAge: Duration(2 * time.Minute),
+	}
+}
+
+// LoadReportConfig carga la configuración desde REPORT_CONFIG_FILE o usa la de por defecto
+func LoadReportConfig() ReportConfig {
+	config := DefaultReportConfig()
+
+	path := os.Getenv("REPORT_CONFIG_FILE")
+	if path == "" {
+		return config
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		slog.Warn("could not read report config", "path", path, "error", err)
+		return config
+	}
+	if err := json.Unmarshal(data, &config); err != nil {
+		slog.Warn("invalid report config", "path", path, "error", err)
+		return DefaultReportConfig()
+	}
+	return config
+}
+
+// ReportOutcome indica qué umbrales cruzó una denuncia
+type ReportOutcome struct {
+	MessageReports int  // denunciantes distintos del mensaje
+	UserReports    int  // denuncias totales recibidas por el autor
+	HideMessage    bool // el mensaje acaba de cruzar el umbral de ocultamiento
+	AddStrikes     bool // el autor acaba de cruzar un múltiplo del umbral por usuario
+}
+
+// messageReports agrupa las denuncias de un mensaje
+type messageReports struct {
+	author    string
+	reporters map[string]string // clave del denunciante (sesión o conexión) -> motivo
+	hidden    bool
+}
+
+// ReportTracker agrega denuncias por mensaje y por usuario
+type ReportTracker struct {
+	config   ReportConfig
+	messages map[string]*messageReports
+	users    map[string]int
+	reasons  map[string]int
+	total    int64
+	hidden   int64
+	mutex    sync.Mutex
+}
+
+func NewReportTracker(config ReportConfig) *ReportTracker {
+	return &ReportTracker{
+		config:   config,
+		messages: make(map[string]*messageReports),
+		users:    make(map[string]int),
+		reasons:  make(map[string]int),
+	}
+}
+
+// reporterKey identifica a quien denuncia por la sesión que le emitió el
+// servidor o, si no tiene, por su conexión. Ni el nombre de usuario ni la IP
+// sirven: el primero lo elige el cliente y la segunda se puede falsear o
+// compartir detrás de un proxy.
+func reporterKey(observer *ConnectionObserver) string {
+	if session := observer.GetToken(); session != "" {
+		return "session:" + session
+	}
+	return "conn:" + observer.GetID()
+}
+
+// ReporterWait devuelve cuánto le falta a una conexión para poder denunciar.
+// Una sesión nueva no cuesta nada, así que sin esta espera bastaría con abrir
+// varias conexiones para ocultar cualquier mensaje.
+func (rt *ReportTracker) ReporterWait(connectedAt, now time.Time) time.Duration {
+	wait := connectedAt.Add(time.Duration(rt.config.MinReporterAge)).Sub(now)
+	if wait < 0 {
+		return 0
+	}
+	return wait
+}
+
+// Report registra una denuncia. Devuelve false si el denunciante ya había
+// denunciado ese mensaje.
+func (rt *ReportTracker) Report(messageID, author, reporter, reason string) (ReportOutcome, bool) {
+	rt.mutex.Lock()
+	defer rt.mutex.Unlock()
+
+	reports, exists := rt.messages[messageID]
+	if !exists {
+		reports = &messageReports{author: author, reporters: make(map[string]string)}
+		rt.messages[messageID] = reports
+	}
+	if _, already := reports.reporters[reporter]; already {
+		return ReportOutcome{}, false
+	}
+	reports.reporters[reporter] = reason
+
+	authorKey := normalizeUsername(author)
+	rt.users[authorKey]++
+	rt.reasons[reason]++
+	rt.total++
+
+	outcome := ReportOutcome{
+		MessageReports: len(reports.reporters),
+		UserReports:    rt.users[authorKey],
+	}
+	if !reports.hidden && rt.config.HideThreshold > 0 && outcome.MessageReports >= rt.config.HideThreshold {
+		reports.hidden = true
+		rt.hidden++
+		outcome.HideMessage = true
+	}
+	if rt.config.UserThreshold > 0 && outcome.UserReports%rt.config.UserThreshold == 0 {
+		outcome.AddStrikes = true
+	}
+	return outcome, true
+}
+
+// Forget descarta las denuncias de un mensaje que ya no se puede denunciar.
+// Las denuncias recibidas por el autor se conservan.
+func (rt *ReportTracker) Forget(messageID string) {
+	rt.mutex.Lock()
+	defer rt.mutex.Unlock()
+	delete(rt.messages, messageID)
+}
+
+// GetStats retorna el resumen de denuncias para /moderation/stats
+func (rt *ReportTracker) GetStats() map[string]interface{} {
+	rt.mutex.Lock()
+	defer rt.mutex.Unlock()
+
+	type userCount struct {
+		Username string `json:"username"`
+		Reports  int    `json:"reports"`
+	}
+	mostReported := []userCount{}
+	for user, count := range rt.users {
+		mostReported = append(mostReported, userCount{Username: user, Reports: count})
+	}
+	sort.Slice(mostReported, func(i, j int) bool {
+		return mostReported[i].Reports > mostReported[j].Reports
+	})
+	if len(mostReported) > 5 {
+		mostReported = mostReported[:5]
+	}
+
+	reasons := make(map[string]int, len(rt.reasons))
+	for reason, count := range rt.reasons {
+		reasons[reason] = count
+	}
+
+	return map[string]interface{}{
+		"total_reports":     rt.total,
+		"hidden_messages":   rt.hidden,
+		"reported_messages": len(rt.messages),
+		"by_reason":         reasons,
+		"most_reported":     mostReported,
+	}
+}
+
+// maxRecentMessages limita cuántos mensajes publicados se recuerdan para denuncias
+const maxRecentMessages = 1000
+
+// MessageIndex recuerda los últimos mensajes publicados para poder denunciarlos
+type MessageIndex struct {
+	items   map[string]ReviewItem
+	order   []string
+	onEvict func(id string) // se llama cuando un mensaje sale del índice
+	mutex   sync.RWMutex
+}
+
+func NewMessageIndex(onEvict func(id string)) *MessageIndex {
+	return &MessageIndex{items: make(map[string]ReviewItem), onEvict: onEvict}
+}
+
+func (mi *MessageIndex) Add(item ReviewItem) {
+	mi.mutex.Lock()
+	if _, exists := mi.items[item.ID]; !exists {
+		mi.order = append(mi.order, item.ID)
+	}
+	mi.items[item.ID] = item
+	evicted := ""
+	if len(mi.order) > maxRecentMessages {
+		evicted = mi.order[0]
+		delete(mi.items, evicted)
+		mi.order = mi.order[1:]
+	}
+	mi.mutex.Unlock()
+
+	if evicted != "" && mi.onEvict != nil {
+		mi.onEvict(evicted)
+	}
+}
+
+func (mi *MessageIndex) Get(id string) (ReviewItem, bool) {
+	mi.mutex.RLock()
+	defer mi.mutex.RUnlock()
+	item, exists := mi.items[id]
+	return item, exists
+}
+
+// handleReportCommand procesa "/report <id_mensaje> <motivo>" enviado por cualquier participante.
+// Devuelve false si el texto no es una denuncia.
+func (s *Server) handleReportCommand(observer *ConnectionObserver, text string) bool {
+	fields := strings.Fields(text)
+	if len(fields) == 0 || fields[0] != "/report" {
+		return false
+	}
+
+	reply := func(message string) {
+		observer.Update(Event{Type: SystemEvent, Message: message, Timestamp: time.Now()})
+	}
+
+	if len(fields) < 3 || !containsFold(ReportReasons, fields[2]) {
+		reply("Uso: /report <id_mensaje> <" + strings.Join(ReportReasons, "|") + ">")
+		return true
+	}
+	messageID, reason := fields[1], strings.ToLower(fields[2])
+
+	item, exists := s.messages.Get(messageID)
+	if !exists {
+		reply("No se encontró el mensaje " + messageID)
+		return true
+	}
+
+	reporter := observer.GetUsername()
+	if reporter == "" {
+		reporter = observer.GetID()
+	}
+	if observer.GetID() == item.SenderID || normalizeUsername(reporter) == normalizeUsername(item.Username) {
+		reply("No puedes denunciar tus propios mensajes")
+		return true
+	}
+
+	if wait := s.reports.ReporterWait(observer.ConnectedAt(), time.Now()); wait > 0 {
+		reply(fmt.Sprintf("Podrás denunciar mensajes dentro de %s", wait.Round(time.Second)))
+		return true
+	}
+
+	outcome, accepted := s.reports.Report(messageID, item.Username, reporterKey(observer), reason)
+	if !accepted {
+		reply("Ya denunciaste este mensaje")
+		return true
+	}
+	reply("Gracias, tu denuncia fue registrada")
+
+	s.audit.Record(AuditEntry{
+		Kind:      AuditReport,
+		Actor:     reporter,
+		Action:    "report",
+		Target:    item.Username,
+		Username:  item.Username,
+		Room:      item.Room,
+		MessageID: messageID,
+		Reason:    reason,
+		Data: map[string]interface{}{
+			"message_reports": outcome.MessageReports,
+			"user_reports":    outcome.UserReports,
+		},
+	})
+
+	if outcome.HideMessage {
+		s.hideReportedMessage(item, outcome)
+	}
+	if outcome.HideMessage || outcome.AddStrikes {
+		s.addReportStrikes(item, outcome)
+	}
+	return true
+}
+
+// hideReportedMessage oculta un mensaje denunciado y lo envía a la cola de revisión
+func (s *Server) hideReportedMessage(item ReviewItem, outcome ReportOutcome) {
+	componentLog("reports").Info("message hidden after reports", "message_id", item.ID, "user", item.Username, "reports", outcome.MessageReports)
+	s.publisher.PublishRoomEvent(MessageHiddenEvent, item.Room, "", item.Username, map[string]interface{}{
+		"message_id": item.ID,
+		"reports":    outcome.MessageReports,
+	})
+	// Sin cola de revisión (o con la cola llena) el mensaje queda oculto
+	if _, queued := s.review.Enqueue(item, ReviewRule{Name: "denuncias", Mode: ReviewFlag}); !queued {
+		componentLog("reports").Warn("hidden message not queued for review", "message_id", item.ID, "review_enabled", s.review.config.Enabled)
+	}
+}
+
+// addReportStrikes suma strikes al autor de un mensaje denunciado
+func (s *Server) addReportStrikes(item ReviewItem, outcome ReportOutcome) {
+	result := ModerationResult{
+		OriginalMessage: item.ChatMessage.Message,
+		ModifiedMessage: item.Message,
+		Action:          "report",
+		Reason:          fmt.Sprintf("Mensaje denunciado por %d usuarios (%d denuncias al usuario)", outcome.MessageReports, outcome.UserReports),
+		Confidence:      1.0,
+		Timestamp:       time.Now(),
+		StrategyUsed:    "UserReports",
+	}
+	// Los strikes van a la sesión dueña del nombre con el que se publicó
+	session, _ := s.sessions.Owner(item.Username)
+	sanction := s.sanctions.RecordResult(item.Username, session, result)
+	if sanction == nil {
+		return
+	}
+
+	s.audit.RecordSanction(*sanction, item.Room, item.ID, result.StrategyUsed)
+	for _, observer := range s.matchingObservers(ModeratorRequest{Username: item.Username, Token: session}) {
+		s.applySanction(observer, sanction)
+	}
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestReporterKeyIgnoresIP(t *testing.T) {
+	first := NewConnectionObserver("obs_1", nil)
+	first.SetIdentity("203.0.113.7", "ses_ana", false)
+	second := NewConnectionObserver("obs_2", nil)
+	second.SetIdentity("203.0.113.7", "ses_bruno", false)
+	if reporterKey(first) == reporterKey(second) {
+		t.Error("dos sesiones detrás de la misma IP deberían contar como denunciantes distintos")
+	}
+
+	// Otra conexión de la misma sesión no es un denunciante nuevo
+	again := NewConnectionObserver("obs_3", nil)
+	again.SetIdentity("198.51.100.9", "ses_ana", false)
+	if reporterKey(first) != reporterKey(again) {
+		t.Error("cambiar de IP no debería crear otro denunciante")
+	}
+}
+
+func TestReporterWait(t *testing.T) {
+	tracker := NewReportTracker(DefaultReportConfig())
+	now := time.Now()
+	if wait := tracker.ReporterWait(now.Add(-30*time.Second), now); wait != 90*time.Second {
+		t.Errorf("espera con 30s conectado = %v, se esperaban 90s", wait)
+	}
+	if wait := tracker.ReporterWait(now.Add(-5*time.Minute), now); wait != 0 {
+		t.Errorf("espera con 5m conectado = %v, se esperaba 0", wait)
+	}
+}
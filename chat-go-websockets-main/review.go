@@ -0,0 +1,402 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Modos de la cola de revisión
+const (
+	ReviewHold = "hold" // el mensaje no se publica hasta que se apruebe
+	ReviewFlag = "flag" // el mensaje se publica marcado como pendiente
+)
+
+// Estados de un mensaje en revisión
+const (
+	ReviewPending  = "pending"
+	ReviewClaimed  = "claimed"
+	ReviewApproved = "approved"
+	ReviewRejected = "rejected"
+)
+
+// ReviewRule decide qué mensajes pasan por revisión humana.
+// Los campos vacíos no filtran.
+type ReviewRule struct {
+	Name          string   `json:"name"`
+	Actions       []string `json:"actions,omitempty"`
+	Strategies    []string `json:"strategies,omitempty"`
+	Terms         []string `json:"terms,omitempty"`
+	MinConfidence float64  `json:"min_confidence,omitempty"`
+	Mode          string   `json:"mode"`
+}
+
+func (rr ReviewRule) matches(result ModerationResult) bool {
+	if len(rr.Actions) > 0 && !containsFold(rr.Actions, result.Action) {
+		return false
+	}
+	if len(rr.Strategies) > 0 && !containsFold(rr.Strategies, result.StrategyUsed) {
+		return false
+	}
+	if len(rr.Terms) > 0 {
+		found := false
+		for _, term := range result.MatchedTerms {
+			if containsFold(rr.Terms, term) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return result.Confidence >= rr.MinConfidence
+}
+
+func containsFold(list []string, value string) bool {
+	for _, item := range list {
+		if strings.EqualFold(item, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// ReviewConfig configura la cola de revisión
+type ReviewConfig struct {
+	Enabled    bool         `json:"enabled"`     // desactivada por defecto: los "warn" se publican como siempre
+	SLA        Duration     `json:"sla"`         // tiempo máximo de espera por un moderador; 0 = sin límite
+	Fallback   string       `json:"fallback"`    // "approve" o "reject" al vencer el SLA o con la cola llena
+	MaxPending int          `json:"max_pending"` // mensajes sin resolver como máximo; siempre hay un límite
+	Rules      []ReviewRule `json:"rules"`
+}
+
+func DefaultReviewConfig() ReviewConfig {
+	return ReviewConfig{
+		Enabled:    false,
+		SLA:        Duration(2 * time.Minute),
+		Fallback:   "approve",
+		MaxPending: 500,
+		Rules: []ReviewRule{
+			{Name: "advertencias", Actions: []string{"warn"}, Mode: ReviewHold},
+		},
+	}
+}
+
+// LoadReviewConfig carga la configuración desde REVIEW_CONFIG_FILE o usa la de por defecto
+func LoadReviewConfig() ReviewConfig {
+	config := DefaultReviewConfig()
+
+	path := os.Getenv("REVIEW_CONFIG_FILE")
+	if path == "" {
+		return config
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		slog.Warn("could not read review config", "path", path, "error", err)
+		return config
+	}
+	if err := json.Unmarshal(data, &config); err != nil {
+		slog.Warn("invalid review config", "path", path, "error", err)
+		return DefaultReviewConfig()
+	}
+	// Sin límite, con sla 0 los mensajes pendientes crecerían sin parar
+	if config.MaxPending <= 0 {
+		slog.Warn("review max_pending must be positive, using default", "path", path, "max_pending", config.MaxPending)
+		config.MaxPending = DefaultReviewConfig().MaxPending
+	}
+	return config
+}
+
+// ReviewItem es un mensaje esperando (o que esperó) la decisión de un moderador
+type ReviewItem struct {
+	ID          string                 `json:"id"` // mismo ID que el mensaje
+	SenderID    string                 `json:"sender_id"`
+	Username    string                 `json:"username"`
+	Room        string                 `json:"room"`
+	Message     string                 `json:"message"` // texto ya moderado que se publicaría
+	ChatMessage ChatMessage            `json:"chat_message"`
+	Result      ModerationResult       `json:"moderation_result"`
+	Rule        string                 `json:"rule"`
+	Mode        string                 `json:"mode"`
+	Status      string                 `json:"status"`
+	ClaimedBy   string                 `json:"claimed_by,omitempty"`
+	CreatedAt   time.Time              `json:"created_at"`
+	Deadline    time.Time              `json:"deadline"`
+	ResolvedBy  string                 `json:"resolved_by,omitempty"` // "sla" si se resolvió automáticamente
+	ResolvedAt  *time.Time             `json:"resolved_at,omitempty"`
+	Reason      string                 `json:"reason,omitempty"`
+	Annotations map[string]interface{} `json:"annotations,omitempty"` // datos que agregó el pipeline
+}
+
+// maxResolvedReviews limita cuántos mensajes resueltos se conservan para consulta
+const maxResolvedReviews = 200
+
+// ReviewQueue mantiene los mensajes marcados para revisión humana
+type ReviewQueue struct {
+	config    ReviewConfig
+	items     map[string]*ReviewItem
+	order     []string // IDs en orden de llegada
+	timers    map[string]*time.Timer
+	onResolve func(item ReviewItem)
+	mutex     sync.Mutex
+}
+
+func NewReviewQueue(config ReviewConfig, onResolve func(item ReviewItem)) *ReviewQueue {
+	return &ReviewQueue{
+		config:    config,
+		items:     make(map[string]*ReviewItem),
+		timers:    make(map[string]*time.Timer),
+		onResolve: onResolve,
+	}
+}
+
+// Match devuelve la primera regla que envía el resultado a revisión
+func (rq *ReviewQueue) Match(result ModerationResult) (ReviewRule, bool) {
+	if !rq.config.Enabled || result.Fallback {
+		return ReviewRule{}, false
+	}
+	for _, rule := range rq.config.Rules {
+		if rule.matches(result) {
+			return rule, true
+		}
+	}
+	return ReviewRule{}, false
+}
+
+// Enqueue agrega un mensaje a la cola e inicia su timer de SLA. Devuelve false
+// si la cola está desactivada o llena (max_pending mensajes sin resolver); en
+// ese caso quien llama aplica el fallback.
+func (rq *ReviewQueue) Enqueue(item ReviewItem, rule ReviewRule) (ReviewItem, bool) {
+	if !rq.config.Enabled {
+		return item, false
+	}
+	rq.mutex.Lock()
+	defer rq.mutex.Unlock()
+
+	// Un mensaje que ya espera revisión no se vuelve a encolar
+	if existing, exists := rq.items[item.ID]; exists && (existing.Status == ReviewPending || existing.Status == ReviewClaimed) {
+		return *existing, true
+	}
+	if rq.unresolved() >= rq.config.MaxPending {
+		componentLog("review").Warn("review queue full", "message_id", item.ID, "max_pending", rq.config.MaxPending, "fallback", rq.config.Fallback)
+		return item, false
+	}
+
+	now := time.Now()
+	item.Rule = rule.Name
+	item.Mode = rule.Mode
+	if item.Mode != ReviewFlag {
+		item.Mode = ReviewHold
+	}
+	item.Status = ReviewPending
+	item.CreatedAt = now
+	item.Deadline = now.Add(time.Duration(rq.config.SLA))
+
+	rq.items[item.ID] = &item
+	rq.order = append(rq.order, item.ID)
+	if rq.config.SLA > 0 {
+		id := item.ID
+		rq.timers[id] = time.AfterFunc(time.Duration(rq.config.SLA), func() { rq.expire(id) })
+	}
+	componentLog("review").Info("message queued for review", "message_id", item.ID, "user", item.Username, "room", item.Room, "mode", item.Mode, "rule", item.Rule)
+	return item, true
+}
+
+// unresolved cuenta los mensajes pendientes o tomados. Se llama con el mutex tomado.
+func (rq *ReviewQueue) unresolved() int {
+	count := 0
+	for _, item := range rq.items {
+		if item.Status == ReviewPending || item.Status == ReviewClaimed {
+			count++
+		}
+	}
+	return count
+}
+
+// List devuelve los mensajes con el estado pedido (todos si status está vacío)
+func (rq *ReviewQueue) List(status string) []ReviewItem {
+	rq.mutex.Lock()
+	defer rq.mutex.Unlock()
+
+	result := []ReviewItem{}
+	for _, id := range rq.order {
+		item := rq.items[id]
+		if status == "" || item.Status == status {
+			result = append(result, *item)
+		}
+	}
+	return result
+}
+
+// Claim asigna un mensaje pendiente a un moderador
+func (rq *ReviewQueue) Claim(id, moderator string) (ReviewItem, error) {
+	rq.mutex.Lock()
+	defer rq.mutex.Unlock()
+
+	item, exists := rq.items[id]
+	if !exists {
+		return ReviewItem{}, errors.New("mensaje no encontrado en la cola: " + id)
+	}
+	if item.Status == ReviewClaimed && item.ClaimedBy != moderator {
+		return ReviewItem{}, errors.New("el mensaje ya fue tomado por " + item.ClaimedBy)
+	}
+	if item.Status != ReviewPending && item.Status != ReviewClaimed {
+		return ReviewItem{}, errors.New("el mensaje ya fue resuelto: " + item.Status)
+	}
+	item.Status = ReviewClaimed
+	item.ClaimedBy = moderator
+	return *item, nil
+}
+
+// Approve publica el mensaje retenido
+func (rq *ReviewQueue) Approve(id, moderator string) (ReviewItem, error) {
+	return rq.resolve(id, moderator, ReviewApproved, "")
+}
+
+// Reject descarta el mensaje retenido
+func (rq *ReviewQueue) Reject(id, moderator, reason string) (ReviewItem, error) {
+	return rq.resolve(id, moderator, ReviewRejected, reason)
+}
+
+func (rq *ReviewQueue) resolve(id, moderator, status, reason string) (ReviewItem, error) {
+	rq.mutex.Lock()
+	item, exists := rq.items[id]
+	if !exists {
+		rq.mutex.Unlock()
+		return ReviewItem{}, errors.New("mensaje no encontrado en la cola: " + id)
+	}
+	if item.Status != ReviewPending && item.Status != ReviewClaimed {
+		rq.mutex.Unlock()
+		return ReviewItem{}, errors.New("el mensaje ya fue resuelto: " + item.Status)
+	}
+	if item.Status == ReviewClaimed && moderator != "sla" && item.ClaimedBy != moderator {
+		rq.mutex.Unlock()
+		return ReviewItem{}, errors.New("el mensaje fue tomado por " + item.ClaimedBy)
+	}
+
+	now := time.Now()
+	item.Status = status
+	item.ResolvedBy = moderator
+	item.ResolvedAt = &now
+	item.Reason = reason
+	if timer, exists := rq.timers[id]; exists {
+		timer.Stop()
+		delete(rq.timers, id)
+	}
+	rq.trim()
+	resolved := *item
+	rq.mutex.Unlock()
+
+	// El callback publica o notifica, por eso se llama sin el mutex tomado
+	if rq.onResolve != nil {
+		rq.onResolve(resolved)
+	}
+	return resolved, nil
+}
+
+// expire aplica el comportamiento de fallback cuando nadie revisó a tiempo
+func (rq *ReviewQueue) expire(id string) {
+	var err error
+	if rq.config.Fallback == "reject" {
+		_, err = rq.Reject(id, "sla", "Sin revisión dentro del SLA")
+	} else {
+		_, err = rq.Approve(id, "sla")
+	}
+	if err == nil {
+		componentLog("review").Warn("review SLA expired", "message_id", id, "fallback", rq.config.Fallback)
+	}
+}
+
+// trim descarta los mensajes resueltos más antiguos. Se llama con el mutex tomado.
+func (rq *ReviewQueue) trim() {
+	resolved := 0
+	for _, id := range rq.order {
+		if status := rq.items[id].Status; status == ReviewApproved || status == ReviewRejected {
+			resolved++
+		}
+	}
+
+	kept := rq.order[:0]
+	for _, id := range rq.order {
+		status := rq.items[id].Status
+		if resolved > maxResolvedReviews && (status == ReviewApproved || status == ReviewRejected) {
+			delete(rq.items, id)
+			resolved--
+			continue
+		}
+		kept = append(kept, id)
+	}
+	rq.order = kept
+}
+
+// GetStats retorna la cantidad de mensajes por estado
+func (rq *ReviewQueue) GetStats() map[string]interface{} {
+	rq.mutex.Lock()
+	defer rq.mutex.Unlock()
+
+	counts := map[string]int{}
+	for _, item := range rq.items {
+		counts[item.Status]++
+	}
+	return map[string]interface{}{
+		"enabled":  rq.config.Enabled,
+		"statuses": counts,
+	}
+}
+
+// reviewRequest es el cuerpo de las acciones sobre la cola de revisión
+type reviewRequest struct {
+	ID     string `json:"id"`
+	Reason string `json:"reason,omitempty"`
+}
+
+// handleReviewQueue lista la cola de revisión (?status=pending|claimed|approved|rejected)
+func (s *Server) handleReviewQueue(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Método no permitido", http.StatusMethodNotAllowed)
+		return
+	}
+	writeJSON(w, s.review.List(r.URL.Query().Get("status")))
+}
+
+// handleReviewAction devuelve el handler de una acción (claim, approve, reject) sobre la cola
+func (s *Server) handleReviewAction(action string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			http.Error(w, "Método no permitido", http.StatusMethodNotAllowed)
+			return
+		}
+		var req reviewRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "JSON inválido: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		moderator := r.Header.Get("X-Moderator")
+		if moderator == "" {
+			moderator = "admin"
+		}
+
+		var item ReviewItem
+		var err error
+		switch action {
+		case "claim":
+			item, err = s.review.Claim(req.ID, moderator)
+		case "approve":
+			item, err = s.review.Approve(req.ID, moderator)
+		case "reject":
+			item, err = s.review.Reject(req.ID, moderator, req.Reason)
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		writeJSON(w, item)
+	}
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestReviewQueueLimits(t *testing.T) {
+	rule := ReviewRule{Name: "prueba", Mode: ReviewHold}
+
+	disabled := NewReviewQueue(DefaultReviewConfig(), nil)
+	if _, queued := disabled.Enqueue(ReviewItem{ID: "msg_1"}, rule); queued {
+		t.Fatal("la cola desactivada no debería aceptar mensajes")
+	}
+
+	config := DefaultReviewConfig()
+	config.Enabled = true
+	config.SLA = 0
+	config.MaxPending = 2
+	queue := NewReviewQueue(config, nil)
+	for i := 1; i <= 2; i++ {
+		if _, queued := queue.Enqueue(ReviewItem{ID: fmt.Sprintf("msg_%d", i)}, rule); !queued {
+			t.Fatalf("msg_%d no entró en la cola", i)
+		}
+	}
+	if _, queued := queue.Enqueue(ReviewItem{ID: "msg_3"}, rule); queued {
+		t.Fatal("con max_pending mensajes sin resolver la cola no debería aceptar más")
+	}
+	// Volver a encolar un mensaje que ya espera no cuenta como nuevo
+	if _, queued := queue.Enqueue(ReviewItem{ID: "msg_1"}, rule); !queued {
+		t.Error("msg_1 ya estaba en la cola")
+	}
+
+	// Al resolver uno se libera lugar
+	if _, err := queue.Approve("msg_1", "moderador"); err != nil {
+		t.Fatalf("Approve: %v", err)
+	}
+	if _, queued := queue.Enqueue(ReviewItem{ID: "msg_3"}, rule); !queued {
+		t.Error("después de resolver un mensaje debería haber lugar")
+	}
+}
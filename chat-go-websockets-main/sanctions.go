@@ -0,0 +1,455 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SanctionType representa los distintos tipos de sanción que se pueden aplicar
+type SanctionType string
+
+const (
+	SanctionWarning SanctionType = "warning"
+	SanctionMute    SanctionType = "mute"
+	SanctionKick    SanctionType = "kick"
+	SanctionTempBan SanctionType = "temp_ban"
+	SanctionPermBan SanctionType = "perm_ban"
+)
+
+// Duration permite escribir duraciones como "5m" o "1h" en los archivos JSON
+type Duration time.Duration
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+// SanctionRule define qué sanción se aplica al superar un umbral de strikes
+type SanctionRule struct {
+	Threshold float64      `json:"threshold"`
+	Type      SanctionType `json:"type"`
+	Duration  Duration     `json:"duration,omitempty"` // solo para mute y temp_ban
+}
+
+// StrikePolicy configura cómo se acumulan strikes y cómo escalan las sanciones
+type StrikePolicy struct {
+	Weights      map[string]float64 `json:"weights"`        // strikes por acción de moderación
+	DecayPerHour float64            `json:"decay_per_hour"` // strikes que se pierden por hora
+	Rules        []SanctionRule     `json:"rules"`
+}
+
+func DefaultStrikePolicy() StrikePolicy {
+	return StrikePolicy{
+		Weights: map[string]float64{
+			"block":  2,
+			"modify": 1,
+			"warn":   0.5,
+			"report": 2,
+		},
+		DecayPerHour: 1,
+		Rules: []SanctionRule{
+			{Threshold: 3, Type: SanctionWarning},
+			{Threshold: 5, Type: SanctionMute, Duration: Duration(5 * time.Minute)},
+			{Threshold: 8, Type: SanctionKick},
+			{Threshold: 12, Type: SanctionTempBan, Duration: Duration(time.Hour)},
+			{Threshold: 20, Type: SanctionPermBan},
+		},
+	}
+}
+
+// LoadStrikePolicy carga la política desde STRIKE_POLICY_FILE o usa la de por defecto
+func LoadStrikePolicy() StrikePolicy {
+	policy := DefaultStrikePolicy()
+
+	path := os.Getenv("STRIKE_POLICY_FILE")
+	if path == "" {
+		return policy
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		slog.Warn("could not read strike policy", "path", path, "error", err)
+		return policy
+	}
+	if err := json.Unmarshal(data, &policy); err != nil {
+		slog.Warn("invalid strike policy", "path", path, "error", err)
+		return DefaultStrikePolicy()
+	}
+	return policy
+}
+
+// Sanction representa una sanción aplicada a un usuario
+type Sanction struct {
+	ID        string       `json:"id"`
+	Type      SanctionType `json:"type"`
+	Username  string       `json:"username,omitempty"`
+	IP        string       `json:"ip,omitempty"`
+	Token     string       `json:"token,omitempty"`
+	Reason    string       `json:"reason"`
+	Strikes   float64      `json:"strikes,omitempty"`
+	IssuedBy  string       `json:"issued_by,omitempty"` // vacío = sistema de strikes
+	CreatedAt time.Time    `json:"created_at"`
+	ExpiresAt time.Time    `json:"expires_at"` // cero = no expira
+}
+
+// ActiveAt indica si la sanción sigue vigente en el instante dado
+func (s Sanction) ActiveAt(now time.Time) bool {
+	return s.ExpiresAt.IsZero() || now.Before(s.ExpiresAt)
+}
+
+// Matches indica si la sanción alcanza a una conexión con esa identidad
+func (s Sanction) Matches(username, ip, token string) bool {
+	if s.Username != "" && username != "" && normalizeUsername(s.Username) == normalizeUsername(username) {
+		return true
+	}
+	if s.IP != "" && s.IP == ip {
+		return true
+	}
+	return s.Token != "" && s.Token == token
+}
+
+// strikeRecord guarda los strikes acumulados de un usuario
+type strikeRecord struct {
+	Score     float64   `json:"score"`
+	UpdatedAt time.Time `json:"updated_at"`
+	Level     int       `json:"level"` // cantidad de reglas de la política ya alcanzadas
+}
+
+// sanctionState es lo que se persiste en disco
+type sanctionState struct {
+	Strikes   map[string]*strikeRecord `json:"strikes"`
+	Sanctions []Sanction               `json:"sanctions"`
+	NextID    int64                    `json:"next_id"`
+}
+
+// sanctionsSaveDelay es cuánto se esperan más cambios antes de guardar sanctions.json
+const sanctionsSaveDelay = 2 * time.Second
+
+// SanctionManager lleva la cuenta de strikes por usuario y aplica sanciones
+// escalonadas. El estado se guarda en segundo plano, fuera del mutex.
+type SanctionManager struct {
+	policy    StrikePolicy
+	path      string
+	strikes   map[string]*strikeRecord
+	sanctions []Sanction // solo sanciones con duración (mute y bans)
+	nextID    int64
+	saver     *jsonSaver
+	mutex     sync.Mutex
+}
+
+func NewSanctionManager(policy StrikePolicy, path string) *SanctionManager {
+	sort.Slice(policy.Rules, func(i, j int) bool {
+		return policy.Rules[i].Threshold < policy.Rules[j].Threshold
+	})
+
+	sm := &SanctionManager{
+		policy:  policy,
+		path:    path,
+		strikes: make(map[string]*strikeRecord),
+		nextID:  1,
+	}
+
+	state := sanctionState{}
+	if err := readJSONFile(path, &state); err != nil {
+		slog.Warn("could not load sanctions from", "path", path, "error", err)
+	}
+	if state.Strikes != nil {
+		sm.strikes = state.Strikes
+	}
+	if state.NextID > 0 {
+		sm.nextID = state.NextID
+	}
+	sm.sanctions = state.Sanctions
+	sm.saver = newJSONSaver(path, "sanctions", sanctionsSaveDelay, sm.snapshot)
+
+	return sm
+}
+
+func normalizeUsername(username string) string {
+	return strings.ToLower(strings.TrimSpace(username))
+}
+
+// RecordResult suma strikes según el resultado de moderación y devuelve la
+// sanción que corresponda aplicar, o nil si no se alcanzó un nuevo umbral.
+// Los strikes se cuentan por sesión, la identidad que emite el servidor; el
+// nombre de usuario solo se usa si no hay sesión (por ejemplo, webhooks).
+func (sm *SanctionManager) RecordResult(username, session string, result ModerationResult) *Sanction {
+	weight := sm.policy.Weights[result.Action]
+	key := strikeKey(username, session)
+	// Un bloqueo por falla del servicio externo no es culpa del usuario
+	if weight <= 0 || key == "" || result.Fallback {
+		return nil
+	}
+
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+
+	now := time.Now()
+	record := sm.decayedRecord(key, now)
+	record.Score += weight
+	sm.strikes[key] = record
+
+	// Buscar la regla más severa alcanzada que todavía no se aplicó
+	reached := sm.levelFor(record.Score)
+	if reached <= record.Level {
+		sm.save()
+		return nil
+	}
+	record.Level = reached
+	rule := sm.policy.Rules[reached-1]
+
+	sanction := Sanction{
+		ID:        fmt.Sprintf("san_%d", sm.nextID),
+		Type:      rule.Type,
+		Username:  username,
+		Token:     session,
+		Reason:    fmt.Sprintf("%.1f strikes acumulados (%s)", record.Score, result.Reason),
+		Strikes:   record.Score,
+		CreatedAt: now,
+	}
+	sm.nextID++
+
+	switch rule.Type {
+	case SanctionMute, SanctionTempBan:
+		sanction.ExpiresAt = now.Add(time.Duration(rule.Duration))
+		sm.sanctions = append(sm.sanctions, sanction)
+	case SanctionPermBan:
+		sm.sanctions = append(sm.sanctions, sanction)
+	}
+
+	sm.save()
+	componentLog("sanctions").Info("sanction applied", "user", username, "session", session, "sanction", sanction.Type, "reason", sanction.Reason)
+	return &sanction
+}
+
+// strikeKey es la clave de los strikes: la sesión si la hay, si no el usuario
+func strikeKey(username, session string) string {
+	if session != "" {
+		return session
+	}
+	return normalizeUsername(username)
+}
+
+// decayedRecord devuelve el registro del usuario con el decaimiento aplicado
+func (sm *SanctionManager) decayedRecord(key string, now time.Time) *strikeRecord {
+	record, exists := sm.strikes[key]
+	if !exists {
+		return &strikeRecord{UpdatedAt: now}
+	}
+
+	hours := now.Sub(record.UpdatedAt).Hours()
+	record.Score -= hours * sm.policy.DecayPerHour
+	if record.Score < 0 {
+		record.Score = 0
+	}
+	record.UpdatedAt = now
+
+	// Si el puntaje bajó de algún umbral, la regla puede volver a aplicarse
+	if level := sm.levelFor(record.Score); level < record.Level {
+		record.Level = level
+	}
+	return record
+}
+
+// levelFor devuelve cuántas reglas de la política alcanza un puntaje
+func (sm *SanctionManager) levelFor(score float64) int {
+	level := 0
+	for i, rule := range sm.policy.Rules {
+		if score >= rule.Threshold {
+			level = i + 1
+		}
+	}
+	return level
+}
+
+// ActiveSanction devuelve la sanción vigente más reciente que alcance a la
+// identidad dada entre los tipos pedidos
+func (sm *SanctionManager) ActiveSanction(username, ip, token string, types ...SanctionType) (Sanction, bool) {
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+
+	now := time.Now()
+	for i := len(sm.sanctions) - 1; i >= 0; i-- {
+		sanction := sm.sanctions[i]
+		if !sanction.Matches(username, ip, token) || !sanction.ActiveAt(now) {
+			continue
+		}
+		for _, t := range types {
+			if sanction.Type == t {
+				return sanction, true
+			}
+		}
+	}
+	return Sanction{}, false
+}
+
+// IsBanned indica si la identidad tiene un ban temporal o permanente vigente
+func (sm *SanctionManager) IsBanned(username, ip, token string) (Sanction, bool) {
+	return sm.ActiveSanction(username, ip, token, SanctionPermBan, SanctionTempBan)
+}
+
+// IsMuted indica si el usuario o su sesión están silenciados. Mirar la sesión
+// evita que el silencio se esquive cambiando de nombre.
+func (sm *SanctionManager) IsMuted(username, session string) (Sanction, bool) {
+	return sm.ActiveSanction(username, "", session, SanctionMute)
+}
+
+// AddSanction registra una sanción manual y la devuelve con su ID asignado
+func (sm *SanctionManager) AddSanction(sanction Sanction) Sanction {
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+
+	sanction.ID = fmt.Sprintf("san_%d", sm.nextID)
+	sm.nextID++
+	if sanction.CreatedAt.IsZero() {
+		sanction.CreatedAt = time.Now()
+	}
+	sm.sanctions = append(sm.sanctions, sanction)
+	sm.save()
+	return sanction
+}
+
+// Lift levanta la sanción con el ID dado
+func (sm *SanctionManager) Lift(id string) (Sanction, bool) {
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+
+	for i, sanction := range sm.sanctions {
+		if sanction.ID == id {
+			sm.sanctions = append(sm.sanctions[:i], sm.sanctions[i+1:]...)
+			sm.save()
+			return sanction, true
+		}
+	}
+	return Sanction{}, false
+}
+
+// LiftMatching levanta todas las sanciones vigentes que alcancen a la identidad dada.
+// También reinicia los strikes del usuario para que no vuelva a escalar de inmediato.
+func (sm *SanctionManager) LiftMatching(username, ip, token string) []Sanction {
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+
+	lifted := []Sanction{}
+	remaining := sm.sanctions[:0]
+	for _, sanction := range sm.sanctions {
+		if sanction.Matches(username, ip, token) {
+			lifted = append(lifted, sanction)
+		} else {
+			remaining = append(remaining, sanction)
+		}
+	}
+	sm.sanctions = remaining
+	if username != "" {
+		delete(sm.strikes, normalizeUsername(username))
+	}
+	sm.save()
+	return lifted
+}
+
+// ActiveSanctions devuelve una copia de las sanciones vigentes
+func (sm *SanctionManager) ActiveSanctions() []Sanction {
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+
+	now := time.Now()
+	result := []Sanction{}
+	for _, sanction := range sm.sanctions {
+		if sanction.ActiveAt(now) {
+			result = append(result, sanction)
+		}
+	}
+	return result
+}
+
+// GetStrikes devuelve los strikes actuales de una sesión, o de un usuario sin
+// sesión (con decaimiento)
+func (sm *SanctionManager) GetStrikes(username, session string) float64 {
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+
+	key := strikeKey(username, session)
+	if _, exists := sm.strikes[key]; !exists {
+		return 0
+	}
+	return sm.decayedRecord(key, time.Now()).Score
+}
+
+// GetStats retorna un resumen de strikes y sanciones vigentes
+func (sm *SanctionManager) GetStats() map[string]interface{} {
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+
+	now := time.Now()
+	active := map[SanctionType]int{}
+	for _, sanction := range sm.sanctions {
+		if sanction.ActiveAt(now) {
+			active[sanction.Type]++
+		}
+	}
+
+	return map[string]interface{}{
+		"users_with_strikes": len(sm.strikes),
+		"active_sanctions":   active,
+	}
+}
+
+// save descarta lo que ya no sirve y pide guardar el estado en segundo
+// plano. Se debe llamar con el mutex tomado.
+func (sm *SanctionManager) save() {
+	// Descartar sanciones vencidas y strikes que ya decayeron a cero para que
+	// el archivo no crezca indefinidamente
+	now := time.Now()
+	active := sm.sanctions[:0]
+	for _, sanction := range sm.sanctions {
+		if sanction.ActiveAt(now) {
+			active = append(active, sanction)
+		}
+	}
+	sm.sanctions = active
+	for key, record := range sm.strikes {
+		if record.Score-now.Sub(record.UpdatedAt).Hours()*sm.policy.DecayPerHour <= 0 {
+			delete(sm.strikes, key)
+		}
+	}
+	sm.saver.MarkDirty()
+}
+
+// snapshot copia el estado para guardarlo sin el mutex tomado
+func (sm *SanctionManager) snapshot() interface{} {
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+	state := sanctionState{
+		Strikes:   make(map[string]*strikeRecord, len(sm.strikes)),
+		Sanctions: append([]Sanction(nil), sm.sanctions...),
+		NextID:    sm.nextID,
+	}
+	for key, record := range sm.strikes {
+		copied := *record
+		state.Strikes[key] = &copied
+	}
+	return state
+}
+
+// Close guarda los cambios pendientes
+func (sm *SanctionManager) Close() {
+	sm.saver.Close()
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSanctionManagerPersistsAndPrunes(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sanctions.json")
+	// Un registro de hace un día ya decayó a cero con 1 strike por hora
+	old := time.Now().Add(-24 * time.Hour).Format(time.RFC3339)
+	state := `{"strikes": {"viejo": {"score": 3, "updated_at": "` + old + `", "level": 1}}, "next_id": 1}`
+	if err := os.WriteFile(path, []byte(state), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	sanctions := NewSanctionManager(DefaultStrikePolicy(), path)
+	block := ModerationResult{Action: "block"}
+	sanctions.RecordResult("ana", "ses_ana", block)
+	if sanction := sanctions.RecordResult("ana", "ses_ana", block); sanction == nil || sanction.Type != SanctionWarning {
+		t.Fatalf("con 4 strikes se esperaba una advertencia, llegó %+v", sanction)
+	}
+	sanctions.AddSanction(Sanction{Type: SanctionPermBan, IP: "203.0.113.7", Reason: "prueba"})
+	sanctions.Close()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "viejo") {
+		t.Error("el registro que decayó a cero debería haberse descartado")
+	}
+	if !strings.Contains(string(data), `"expires_at": "0001-01-01T00:00:00Z"`) {
+		t.Error("el ban permanente debería guardar expires_at")
+	}
+
+	reloaded := NewSanctionManager(DefaultStrikePolicy(), path)
+	defer reloaded.Close()
+	if strikes := reloaded.GetStrikes("ana", "ses_ana"); strikes < 3.9 {
+		t.Errorf("strikes de ana después de recargar = %v, se esperaban 4", strikes)
+	}
+	if _, banned := reloaded.IsBanned("", "203.0.113.7", ""); !banned {
+		t.Error("el ban no se guardó al cerrar")
+	}
+}
+
+func TestMuteFollowsSession(t *testing.T) {
+	sanctions := NewSanctionManager(DefaultStrikePolicy(), filepath.Join(t.TempDir(), "sanctions.json"))
+	defer sanctions.Close()
+	sanctions.AddSanction(Sanction{Type: SanctionMute, Username: "ana", Token: "ses_ana", Reason: "prueba", ExpiresAt: time.Now().Add(time.Hour)})
+
+	if _, muted := sanctions.IsMuted("otro_nombre", "ses_ana"); !muted {
+		t.Error("cambiar de nombre no debería levantar el silencio de la sesión")
+	}
+	if _, muted := sanctions.IsMuted("bruno", "ses_bruno"); muted {
+		t.Error("otra sesión con otro nombre no debería quedar silenciada")
+	}
+}
@@ -3,9 +3,14 @@ package main
 import (
 	"encoding/json"
 	"fmt"
-	"log"
+	"log/slog"
+	"net"
 	"net/http"
+	"os"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gorilla/websocket"
@@ -15,23 +20,46 @@ type Server struct {
 	publisher         *EventPublisher
 	logger            *LoggerObserver
 	moderationObserver *ModerationObserver
+	sanctions         *SanctionManager
+	sessions          *SessionStore
+	audit             *AuditLog
+	review            *ReviewQueue
+	reports           *ReportTracker
+	messages          *MessageIndex
+	shadow            *ShadowEvaluator
+	composites        *CompositeStore
+	plugins           *PluginManager
+	cluster           *PeerBroker // nil si no se usa el clúster sin broker
+	pipeline          *MessagePipeline
+	webhooks          *WebhookManager
+	incoming          *IncomingHookStore
 	observerMap       map[string]*ConnectionObserver
+	firstSeen         *FirstSeenStore
+	backpressure      BackpressureSettings
 	mutex             sync.RWMutex
 	nextObserverID    int64
+	messageSeq        int64
 }
 
 func NewServer() *Server {
-	publisher := NewEventPublisher()
+	backpressure := LoadBackpressureSettings()
+	publisher := NewEventPublisherWithBackpressure(backpressure.Publisher)
 	logger := NewLoggerObserver()
 	statsObserver := NewStatsObserver()
 	
 	// Crear ModerationObserver con estrategia de reemplazo de malas palabras
 	moderationObserver := NewModerationObserver(NewBadWordReplacementStrategy())
 	
-	// Suscribir observadores a todos los eventos
-	publisher.Subscribe(logger)
-	publisher.Subscribe(statsObserver)
-	publisher.Subscribe(moderationObserver)
+	// El logger recibe todos los eventos; los demás solo los que usan. Cada
+	// uno va envuelto en su cadena de middlewares (métricas, panics, etc.)
+	middleware := LoadObserverMiddlewareSettings()
+	publisher.Subscribe(middleware.Logger.Wrap(logger))
+	publisher.Subscribe(middleware.Stats.Wrap(statsObserver), EventFilter{
+		Types: []EventType{MessageEvent, UserJoinEvent, UserLeave, SystemEvent},
+	})
+	publisher.Subscribe(middleware.Moderation.Wrap(moderationObserver), EventFilter{
+		Types: []EventType{MessageEvent},
+	})
 	
 	// Iniciar el timer de estadísticas cada 30 segundos
 	statsObserver.StartStatsTimer(30 * time.Second)
@@ -40,9 +68,60 @@ func NewServer() *Server {
 		publisher:         publisher,
 		logger:           logger,
 		moderationObserver: moderationObserver,
+		sanctions:        NewSanctionManager(LoadStrikePolicy(), dataPath("sanctions.json")),
+		sessions:         NewSessionStore(dataPath("sessions.json")),
+		audit:            NewAuditLog(dataPath("audit.jsonl")),
+		reports:          NewReportTracker(LoadReportConfig()),
+		shadow:           NewShadowEvaluator(),
+		composites:       NewCompositeStore(dataPath("composites.json")),
 		observerMap:      make(map[string]*ConnectionObserver),
+		firstSeen:        NewFirstSeenStore(dataPath("first_seen.json")),
+		backpressure:     backpressure,
 		nextObserverID:   1,
 	}
+	s.review = NewReviewQueue(LoadReviewConfig(), s.resolveReview)
+	// Las denuncias de un mensaje se olvidan cuando sale del índice
+	s.messages = NewMessageIndex(s.reports.Forget)
+	// Al recargar un plugin activo, el servidor pasa a la versión nueva antes de cerrar la anterior
+	s.plugins = NewPluginManager(s.pluginReplaced)
+	s.pipeline = s.newMessagePipeline(LoadPipelineConfig())
+	
+	// Log de eventos persistente: cada evento recibe un offset y se puede volver a leer
+	if config := LoadEventLogConfig(); !config.Disabled {
+		eventLog, err := OpenEventLog(config)
+		if err != nil {
+			slog.Warn("event log disabled", "component", "eventlog", "error", err)
+		} else {
+			s.recoverMessages(eventLog)
+			publisher.SetEventLog(eventLog)
+			eventLog.StartMaintenance()
+		}
+	}
+	
+	// Broker para repartir los eventos entre instancias; si falla, el servidor queda solo
+	brokerConfig := LoadBrokerConfig()
+	broker, err := NewBroker(brokerConfig)
+	if err != nil {
+		slog.Warn("broker unavailable, using in-memory broker", "component", "broker", "type", brokerConfig.Type, "error", err)
+		brokerConfig.Type = MemoryBrokerType
+		broker, _ = NewBroker(brokerConfig)
+	}
+	if err := publisher.SetBroker(broker); err != nil {
+		slog.Warn("broker subscription failed", "component", "broker", "error", err)
+	}
+	if cluster, isCluster := broker.(*PeerBroker); isCluster {
+		s.cluster = cluster
+		cluster.SetPresenceSource(s.localPresence)
+		cluster.OnStrategyChange(s.applyClusterStrategy)
+	}
+	publisher.Subscribe(&remoteMessageIndexer{server: s}, EventFilter{
+		Types: []EventType{MessageEvent},
+	})
+	
+	// Webhooks salientes; van al final para leer del log de eventos si está activo
+	webhookConfig := LoadWebhookConfig()
+	s.webhooks = NewWebhookManager(webhookConfig, publisher)
+	s.incoming = NewIncomingHookStore(dataPath("hooks.json"), webhookConfig.IncomingRateLimit)
 	
 	return s
 }
@@ -52,25 +131,64 @@ var upgrader = websocket.Upgrader{
 	WriteBufferSize: 1024,
 }
 
+// maxClientFrameBytes es el tamaño máximo de un mensaje del cliente; uno más
+// grande cierra la conexión
+const maxClientFrameBytes = 64 << 10
+
 func (s *Server) handleWebSocket(w http.ResponseWriter, r *http.Request) {
 	conn, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
-		log.Println("Error upgrading connection", err)
+		requestLogger(r).Warn("websocket upgrade failed", "error", err)
 		return
 	}
 	defer conn.Close()
+	conn.SetReadLimit(maxClientFrameBytes)
+
+	// La sesión la emite el servidor: el cliente la guarda y la presenta al
+	// reconectarse. Sin una sesión válida se le entrega una nueva.
+	session, valid := s.sessions.Verify(r.URL.Query().Get("session"))
+	sessionToken := ""
+	if !valid {
+		session, sessionToken = s.sessions.Issue()
+	}
+	
+	// Rechazar conexiones con un ban vigente por IP o sesión
+	ip := clientIP(r)
+	if sanction, banned := s.sanctions.IsBanned("", ip, session); banned {
+		requestLogger(r).Info("banned connection rejected", "sanction", sanction.Type, "reason", sanction.Reason)
+		conn.WriteJSON(Event{
+			Type:      SystemEvent,
+			Message:   "Tienes prohibido el acceso al chat: " + sanction.Reason,
+			Timestamp: time.Now(),
+		})
+		return
+	}
 
 	// Crear un nuevo observador de conexión
-	observerID := fmt.Sprintf("obs_%d", s.nextObserverID)
-	s.nextObserverID++
+	observerID := fmt.Sprintf("obs_%d", atomic.AddInt64(&s.nextObserverID, 1)-1)
 	
-	observer := NewConnectionObserver(observerID, conn)
+	// Cada conexión tiene su propia política para cuando no da abasto
+	adminToken := os.Getenv("ADMIN_TOKEN")
+	isModerator := adminToken != "" && r.URL.Query().Get("admin_token") == adminToken
+	backpressure := s.backpressure.ForConnection(isModerator, r.URL.Query().Get("backpressure"))
+	observer := NewConnectionObserverWithBackpressure(observerID, conn, backpressure)
+	observer.SetIdentity(ip, session, isModerator)
+	observer.SetLogger(requestLogger(r))
 	observer.StartListening()
+	observer.Logger().Info("connection opened", "session", session, "moderator", isModerator, "backpressure", backpressure.Policy)
+	if sessionToken != "" {
+		observer.Update(Event{
+			Type:      SessionEvent,
+			Data:      map[string]interface{}{"session": sessionToken},
+			Timestamp: time.Now(),
+		})
+	}
 	
 	// Registrar el observador
 	s.mutex.Lock()
 	s.observerMap[observerID] = observer
-	s.publisher.Subscribe(observer)
+	// Cada conexión recibe los eventos globales y los de su sala actual
+	s.publisher.Subscribe(observer, EventFilter{RoomOf: observer.GetRoom})
 	s.mutex.Unlock()
 	
 	// Limpiar cuando se desconecte
@@ -91,7 +209,7 @@ func (s *Server) handleWebSocket(w http.ResponseWriter, r *http.Request) {
 	for {
 		_, msg, err := conn.ReadMessage()
 		if err != nil {
-			log.Println("Error reading message:", err)
+			observer.Logger().Info("connection closed", "reason", err)
 			break
 		}
 		
@@ -105,35 +223,31 @@ func (s *Server) handleWebSocket(w http.ResponseWriter, r *http.Request) {
 			}
 		}
 		
-		// Actualizar username del observer si se proporciona
-		if chatMsg.Username != "" {
-			observer.SetUsername(chatMsg.Username)
-		}
-		
-		// Usar la estrategia de moderación centralizada del servidor
-		moderationResult := s.moderateMessage(chatMsg.Message)
+		// El ID del mensaje lo asigna siempre el servidor
+		chatMsg.ID = s.newMessageID()
 		
-		// Usar el mensaje moderado si fue modificado
-		finalMessage := chatMsg.Message
-		if moderationResult.Action == "modify" {
-			finalMessage = moderationResult.ModifiedMessage
-			fmt.Printf("[SERVER] Mensaje moderado: '%s' -> '%s'\n", 
-				moderationResult.OriginalMessage, moderationResult.ModifiedMessage)
-		} else if moderationResult.Action == "block" {
-			// Si el mensaje fue bloqueado, enviar mensaje de sistema al usuario
-			s.publisher.PublishEvent(SystemEvent, "Tu mensaje fue bloqueado: "+moderationResult.Reason, "", map[string]interface{}{
-				"blocked_message": true,
-				"sender_id": observerID,
-			})
-			continue // No procesar este mensaje
+		// El nombre se fija con el primer mensaje y el que se publica es siempre el de la conexión
+		if chatMsg.Username != "" && !s.setConnectionUsername(observer, chatMsg.Username) {
+			continue
+		}
+		chatMsg.Username = observer.GetUsername()
+		if chatMsg.Room != "" {
+			observer.SetRoom(chatMsg.Room)
 		}
 		
-		// Publicar evento de mensaje con el contenido final
-		s.publisher.PublishEvent(MessageEvent, finalMessage, observer.GetUsername(), map[string]interface{}{
-			"chat_message": chatMsg,
-			"sender_id": observerID,
-			"moderation_result": moderationResult,
-		})
+		// El mensaje pasa por el pipeline (sanciones, límite, comandos,
+		// moderación, anotaciones) y se publica si ninguna etapa lo detuvo
+		inbound := &InboundMessage{
+			Chat:     chatMsg,
+			Text:     chatMsg.Message,
+			Observer: observer,
+			IP:       ip,
+			Token:    session,
+		}
+		if s.pipeline.Process(inbound) != VerdictContinue {
+			continue
+		}
+		s.publishInbound(inbound)
 	}
 	
 	// Publicar evento de desconexión
@@ -142,6 +256,176 @@ func (s *Server) handleWebSocket(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
+// setConnectionUsername fija el nombre de la conexión. Solo se puede elegir
+// una vez por conexión, y no se puede usar un nombre que reclamó otra sesión:
+// así nadie se hace pasar por otro usuario para que lo sancionen. Devuelve
+// false si el mensaje no se debe procesar.
+func (s *Server) setConnectionUsername(observer *ConnectionObserver, username string) bool {
+	username = strings.TrimSpace(username)
+	if current := observer.GetUsername(); current != "" {
+		if normalizeUsername(current) != normalizeUsername(username) {
+			observer.Update(Event{
+				Type:      SystemEvent,
+				Message:   "Tu nombre en esta conexión es " + current + "; para usar otro, vuelve a conectarte",
+				Timestamp: time.Now(),
+			})
+		}
+		return true
+	}
+	if !s.sessions.Claim(username, observer.GetToken(), time.Now()) {
+		observer.Update(Event{
+			Type:      SystemEvent,
+			Message:   "El nombre " + username + " ya lo usa otra persona; elige otro",
+			Data:      map[string]interface{}{"username_taken": username},
+			Timestamp: time.Now(),
+		})
+		return false
+	}
+	observer.SetUsername(username)
+	observer.Logger().Info("username set", "user", username)
+	return true
+}
+
+// publishChatMessage publica en su sala un mensaje ya moderado
+func (s *Server) publishChatMessage(item ReviewItem) {
+	data := map[string]interface{}{
+		"chat_message": item.ChatMessage,
+		"message_id": item.ID,
+		"sender_id": item.SenderID,
+		"moderation_result": item.Result,
+	}
+	if item.Mode == ReviewFlag && item.Status == ReviewPending {
+		data["pending_review"] = true
+	}
+	if len(item.Annotations) > 0 {
+		data["annotations"] = item.Annotations
+	}
+	s.publisher.PublishRoomEvent(MessageEvent, item.Room, item.Message, item.Username, data)
+	s.messages.Add(item)
+}
+
+// resolveReview publica o descarta un mensaje cuando se resuelve su revisión
+func (s *Server) resolveReview(item ReviewItem) {
+	data := map[string]interface{}{"message_id": item.ID}
+	switch {
+	case item.Status == ReviewApproved && item.Mode == ReviewHold:
+		s.publishChatMessage(item)
+	case item.Status == ReviewApproved:
+		s.publisher.PublishRoomEvent(MessageApprovedEvent, item.Room, "", item.Username, data)
+	case item.Status == ReviewRejected && item.Mode == ReviewFlag:
+		s.publisher.PublishRoomEvent(MessageRemovedEvent, item.Room, "", item.Username, data)
+	}
+	
+	if item.Status == ReviewRejected && item.ResolvedBy == "sla" {
+		s.notifyConnection(item.SenderID, "Tu mensaje no fue revisado a tiempo y se descartó", data)
+	} else if item.Status == ReviewRejected {
+		s.notifyConnection(item.SenderID, "Tu mensaje fue rechazado por un moderador", data)
+	}
+	
+	s.audit.Record(AuditEntry{
+		Kind:      AuditModeratorAction,
+		Actor:     item.ResolvedBy,
+		Action:    "review_" + item.Status,
+		Target:    item.Username,
+		Username:  item.Username,
+		Room:      item.Room,
+		MessageID: item.ID,
+		Strategy:  item.Result.StrategyUsed,
+		Reason:    item.Reason,
+	})
+}
+
+// notifyConnection envía un mensaje de sistema privado a una conexión, si sigue activa
+func (s *Server) notifyConnection(observerID, message string, data map[string]interface{}) {
+	s.mutex.RLock()
+	observer, exists := s.observerMap[observerID]
+	s.mutex.RUnlock()
+	if !exists {
+		return
+	}
+	observer.Update(Event{Type: SystemEvent, Message: message, Data: data, Timestamp: time.Now()})
+}
+
+// applySanction notifica en privado al usuario sancionado y, si corresponde,
+// lo desconecta de la sala
+func (s *Server) applySanction(observer *ConnectionObserver, sanction *Sanction) {
+	var message string
+	switch sanction.Type {
+	case SanctionWarning:
+		message = "Advertencia: tus mensajes infringen las normas del chat"
+	case SanctionMute:
+		message = "Estás silenciado hasta las " + sanction.ExpiresAt.Format("15:04:05")
+	case SanctionKick:
+		message = "Fuiste expulsado de la sala por infringir las normas"
+	case SanctionTempBan:
+		message = "Estás suspendido hasta las " + sanction.ExpiresAt.Format("15:04:05")
+	case SanctionPermBan:
+		message = "Fuiste baneado permanentemente del chat"
+	}
+
+	event := Event{
+		Type:    SystemEvent,
+		Message: message,
+		Data: map[string]interface{}{
+			"sanction": sanction,
+		},
+		Timestamp: time.Now(),
+	}
+
+	switch sanction.Type {
+	case SanctionKick, SanctionTempBan, SanctionPermBan:
+		observer.Disconnect(event)
+	default:
+		observer.Update(event)
+	}
+}
+
+// newMessageID genera un ID único para cada mensaje recibido
+func (s *Server) newMessageID() string {
+	// Con varias instancias, el ID de la instancia evita que dos mensajes tengan el mismo ID
+	if node := s.publisher.nodeID(); node != "" {
+		return fmt.Sprintf("msg_%d_%d_%s", time.Now().Unix(), atomic.AddInt64(&s.messageSeq, 1), shortNodeID(node))
+	}
+	return fmt.Sprintf("msg_%d_%d", time.Now().Unix(), atomic.AddInt64(&s.messageSeq, 1))
+}
+
+// trustProxy indica si el servidor corre detrás de un proxy de confianza
+// (TRUST_PROXY=true, como en Render) que agrega la IP real a X-Forwarded-For
+func trustProxy() bool {
+	trusted, _ := strconv.ParseBool(os.Getenv("TRUST_PROXY"))
+	return trusted
+}
+
+// clientIP obtiene la IP del cliente. X-Forwarded-For solo se lee detrás de
+// un proxy de confianza, y se toma la última entrada: la que agregó el proxy.
+// Las anteriores las escribe el cliente y no sirven para banear ni registrar.
+func clientIP(r *http.Request) string {
+	if trustProxy() {
+		if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
+			hops := strings.Split(forwarded, ",")
+			if hop := strings.TrimSpace(hops[len(hops)-1]); hop != "" {
+				return hop
+			}
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// Close apaga el servidor: deja de repartir eventos y guarda en disco lo que
+// quedó pendiente, como los offsets de los suscriptores durables
+func (s *Server) Close() {
+	if err := s.publisher.Close(); err != nil {
+		componentLog("eventlog").Error("could not close event log", "error", err)
+	}
+	s.firstSeen.Close()
+	s.sanctions.Close()
+	s.sessions.Close()
+}
+
 // Método auxiliar para obtener estadísticas del servidor
 func (s *Server) GetConnectionCount() int {
 	s.mutex.RLock()
@@ -149,12 +433,24 @@ func (s *Server) GetConnectionCount() int {
 	return len(s.observerMap)
 }
 
+// userFirstSeen devuelve cuándo envió el usuario su primer mensaje, para
+// las heurísticas de cuentas nuevas
+func (s *Server) userFirstSeen(username string) time.Time {
+	return s.firstSeen.Touch(username, time.Now())
+}
+
 // Método para moderar mensajes usando la estrategia centralizada
-func (s *Server) moderateMessage(message string) ModerationResult {
+func (s *Server) moderateMessage(message string, ctx MessageContext) ModerationResult {
+	// Solo se toma la estrategia con el lock: algunas (servicio externo,
+	// plugins) tardan, y mientras tanto las demás conexiones necesitan el lock
 	s.mutex.RLock()
-	defer s.mutex.RUnlock()
+	var moderator *ModerationContext
+	if s.moderationObserver != nil {
+		moderator = NewModerationContext(s.moderationObserver.Moderator.strategy)
+	}
+	s.mutex.RUnlock()
 	
-	if s.moderationObserver == nil {
+	if moderator == nil {
 		// Si no hay moderación, permitir el mensaje
 		return ModerationResult{
 			OriginalMessage: message,
@@ -168,22 +464,59 @@ func (s *Server) moderateMessage(message string) ModerationResult {
 	}
 	
 	// Usar la estrategia del ModerationObserver
-	return s.moderationObserver.Moderator.ModerateMessage(message)
+	result := moderator.ModerateMessageWithContext(message, ctx)
+	if result.Language == "" {
+		result.Language = ctx.Language
+	}
+	return result
 }
 
 // Método para cambiar la estrategia de moderación
 func (s *Server) SetModerationStrategy(strategy ModerationStrategy) {
+	s.setModerationStrategy(strategy, "admin")
+	
+	// En un clúster, los demás nodos adoptan la misma estrategia por su nombre en el registro
+	if s.cluster != nil {
+		if name, registered := strategies.NameFor(strategy); registered {
+			s.cluster.BroadcastStrategy(name)
+		} else {
+			slog.Warn("strategy is not registered; not propagated to the cluster", "component", "cluster", "strategy", strategy.GetName())
+		}
+	}
+}
+
+// setModerationStrategy cambia la estrategia solo en este nodo
+func (s *Server) setModerationStrategy(strategy ModerationStrategy, actor string) {
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
+	previous := s.moderationObserver.Moderator.strategy.GetName()
 	s.moderationObserver.SetStrategy(strategy)
-	fmt.Printf("[SERVER] Estrategia de moderación cambiada a: %s\n", strategy.GetName())
+	s.audit.Record(AuditEntry{
+		Kind:     AuditStrategyChange,
+		Actor:    actor,
+		Action:   "set_strategy",
+		Strategy: strategy.GetName(),
+		Data: map[string]interface{}{
+			"previous_strategy": previous,
+		},
+	})
+	slog.Info("moderation strategy changed", "component", "moderation", "strategy", strategy.GetName(), "previous", previous, "actor", actor)
 }
 
 // Método para obtener estadísticas de moderación
 func (s *Server) GetModerationStats() map[string]interface{} {
 	s.mutex.RLock()
 	defer s.mutex.RUnlock()
-	return s.moderationObserver.GetStats()
+	stats := s.moderationObserver.GetStats()
+	stats["sanctions"] = s.sanctions.GetStats()
+	stats["review_queue"] = s.review.GetStats()
+	stats["reports"] = s.reports.GetStats()
+	stats["spam"] = sharedSpamTracker().GetStats()
+	stats["pipeline"] = s.pipeline.GetStats()
+	if sharedExternalModerator().config.URL != "" {
+		stats["external"] = sharedExternalModerator().GetStats()
+	}
+	return stats
 }
 
 // Método para enviar mensajes de sistema a todos los usuarios conectados
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Límites de los nombres reclamados: un nombre que su sesión no usa hace más
+// de usernameClaimTTL queda libre, y se guardan como mucho maxUsernameClaims.
+const (
+	usernameClaimTTL     = 30 * 24 * time.Hour
+	maxUsernameClaims    = 100000
+	sessionsSaveDelay    = 5 * time.Second
+	usernameClaimRefresh = time.Hour // cada cuánto se actualiza el último uso de un nombre
+)
+
+// usernameClaim es un nombre de usuario reservado por una sesión
+type usernameClaim struct {
+	Session  string    `json:"session"`
+	LastUsed time.Time `json:"last_used"`
+}
+
+// sessionState es lo que se persiste en disco
+type sessionState struct {
+	Secret string                   `json:"secret,omitempty"` // solo si no se definió SESSION_SECRET
+	Claims map[string]usernameClaim `json:"claims"`
+}
+
+// SessionStore emite las sesiones del chat y recuerda qué sesión usa cada
+// nombre de usuario. La sesión la genera el servidor y va firmada, así que el
+// cliente no la puede inventar: es la identidad con la que se cuentan strikes
+// y se aplican sanciones. Un nombre ya usado por una sesión no lo puede tomar
+// otra, para que nadie se haga pasar por otro usuario y lo haga sancionar.
+type SessionStore struct {
+	secret    []byte
+	stored    string                   // secreto generado que se guarda en el archivo
+	claims    map[string]usernameClaim // usuario normalizado -> sesión
+	lastPrune time.Time
+	saver     *jsonSaver
+	mutex     sync.Mutex
+}
+
+// NewSessionStore carga los nombres reclamados. La clave de firma sale de
+// SESSION_SECRET; si no está definida se genera una y se guarda con los
+// nombres, para que las sesiones sigan valiendo después de reiniciar.
+func NewSessionStore(path string) *SessionStore {
+	state := sessionState{}
+	if err := readJSONFile(path, &state); err != nil {
+		componentLog("sessions").Error("could not load file", "path", path, "error", err)
+	}
+	ss := &SessionStore{claims: state.Claims, lastPrune: time.Now()}
+	if ss.claims == nil {
+		ss.claims = make(map[string]usernameClaim)
+	}
+	switch secret := os.Getenv("SESSION_SECRET"); {
+	case secret != "":
+		ss.secret = []byte(secret)
+	case state.Secret != "":
+		ss.stored = state.Secret
+		ss.secret = []byte(state.Secret)
+	default:
+		random := make([]byte, 32)
+		rand.Read(random)
+		ss.stored = hex.EncodeToString(random)
+		ss.secret = []byte(ss.stored)
+	}
+	ss.saver = newJSONSaver(path, "sessions", sessionsSaveDelay, ss.snapshot)
+	if state.Secret == "" && ss.stored != "" {
+		ss.saver.MarkDirty()
+	}
+	return ss
+}
+
+// Issue crea una sesión nueva. Devuelve su ID y el token firmado que el
+// cliente guarda y presenta al reconectarse.
+func (ss *SessionStore) Issue() (string, string) {
+	random := make([]byte, 12)
+	rand.Read(random)
+	id := "ses_" + hex.EncodeToString(random)
+	return id, id + "." + ss.sign(id)
+}
+
+// Verify comprueba la firma de un token y devuelve el ID de la sesión
+func (ss *SessionStore) Verify(token string) (string, bool) {
+	id, signature, found := strings.Cut(token, ".")
+	if !found || !strings.HasPrefix(id, "ses_") {
+		return "", false
+	}
+	if !hmac.Equal([]byte(signature), []byte(ss.sign(id))) {
+		return "", false
+	}
+	return id, true
+}
+
+func (ss *SessionStore) sign(id string) string {
+	mac := hmac.New(sha256.New, ss.secret)
+	mac.Write([]byte(id))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Claim reserva el nombre para la sesión. Devuelve false si lo usa otra sesión.
+func (ss *SessionStore) Claim(username, session string, now time.Time) bool {
+	key := normalizeUsername(username)
+	if key == "" || session == "" {
+		return false
+	}
+	ss.mutex.Lock()
+	defer ss.mutex.Unlock()
+
+	claim, exists := ss.claims[key]
+	if exists && claim.Session != session && now.Sub(claim.LastUsed) < usernameClaimTTL {
+		return false
+	}
+	if exists && claim.Session == session && now.Sub(claim.LastUsed) < usernameClaimRefresh {
+		return true
+	}
+	ss.claims[key] = usernameClaim{Session: session, LastUsed: now}
+	if len(ss.claims) > maxUsernameClaims || now.Sub(ss.lastPrune) > usernameClaimRefresh {
+		ss.prune(now)
+	}
+	ss.saver.MarkDirty()
+	return true
+}
+
+// Owner devuelve la sesión que usa el nombre, si alguna lo reclamó
+func (ss *SessionStore) Owner(username string) (string, bool) {
+	ss.mutex.Lock()
+	defer ss.mutex.Unlock()
+	claim, exists := ss.claims[normalizeUsername(username)]
+	if !exists || time.Since(claim.LastUsed) >= usernameClaimTTL {
+		return "", false
+	}
+	return claim.Session, true
+}
+
+// prune libera los nombres vencidos y, si todavía sobran, los que llevan más
+// tiempo sin usarse. Se llama con el mutex tomado.
+func (ss *SessionStore) prune(now time.Time) {
+	ss.lastPrune = now
+	for key, claim := range ss.claims {
+		if now.Sub(claim.LastUsed) >= usernameClaimTTL {
+			delete(ss.claims, key)
+		}
+	}
+	for len(ss.claims) > maxUsernameClaims {
+		var oldest time.Time
+		oldestKey := ""
+		for key, claim := range ss.claims {
+			if oldestKey == "" || claim.LastUsed.Before(oldest) {
+				oldest, oldestKey = claim.LastUsed, key
+			}
+		}
+		delete(ss.claims, oldestKey)
+	}
+}
+
+// snapshot copia el estado para guardarlo sin el mutex tomado
+func (ss *SessionStore) snapshot() interface{} {
+	ss.mutex.Lock()
+	defer ss.mutex.Unlock()
+	state := sessionState{Secret: ss.stored, Claims: make(map[string]usernameClaim, len(ss.claims))}
+	for key, claim := range ss.claims {
+		state.Claims[key] = claim
+	}
+	return state
+}
+
+// Close guarda los cambios pendientes
+func (ss *SessionStore) Close() {
+	ss.saver.Close()
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSessionStore(t *testing.T) {
+	t.Setenv("SESSION_SECRET", "")
+	path := filepath.Join(t.TempDir(), "sessions.json")
+	store := NewSessionStore(path)
+
+	id, token := store.Issue()
+	if verified, ok := store.Verify(token); !ok || verified != id {
+		t.Fatalf("Verify(%q) = %q, %v", token, verified, ok)
+	}
+	other, _ := store.Issue()
+	for _, forged := range []string{"", id, other + token[strings.Index(token, "."):], token + "0", "ses_x.y"} {
+		if _, ok := store.Verify(forged); ok {
+			t.Errorf("se aceptó una sesión falsa: %q", forged)
+		}
+	}
+
+	// Un nombre usado por una sesión no lo puede tomar otra
+	now := time.Now()
+	if !store.Claim("Ana", id, now) {
+		t.Fatal("ana estaba libre")
+	}
+	if store.Claim(" ana ", other, now) {
+		t.Fatal("otra sesión pudo usar el nombre de ana")
+	}
+	if !store.Claim("ana", id, now.Add(time.Minute)) {
+		t.Fatal("la dueña no pudo volver a usar su nombre")
+	}
+	// Pasado usernameClaimTTL sin usarse, el nombre queda libre
+	if !store.Claim("ana", other, now.Add(usernameClaimTTL+time.Hour)) {
+		t.Error("un nombre sin usar hace más de usernameClaimTTL debería quedar libre")
+	}
+	store.Close()
+
+	// Las sesiones y los nombres siguen valiendo después de reiniciar
+	reloaded := NewSessionStore(path)
+	defer reloaded.Close()
+	if _, ok := reloaded.Verify(token); !ok {
+		t.Error("la sesión dejó de valer después de reiniciar")
+	}
+	if owner, _ := reloaded.Owner("ana"); owner != other {
+		t.Errorf("Owner(ana) = %q, se esperaba %q", owner, other)
+	}
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxShadowExamples limita cuántas divergencias de ejemplo se guardan por candidata
+const maxShadowExamples = 20
+
+// ShadowExample es un mensaje en el que la candidata decidió distinto que la activa
+type ShadowExample struct {
+	Message   string           `json:"message"`
+	Active    ModerationResult `json:"active"`
+	Candidate ModerationResult `json:"candidate"`
+	Timestamp time.Time        `json:"timestamp"`
+}
+
+// shadowCandidate acumula la comparación de una estrategia candidata
+type shadowCandidate struct {
+	name      string
+	strategy  ModerationStrategy
+	since     time.Time
+	total     int64
+	agreed    int64
+	confusion map[string]map[string]int64 // acción activa -> acción candidata -> cantidad
+	examples  []ShadowExample
+	mutex     sync.Mutex
+}
+
+// ShadowReport es el resumen de una candidata
+type ShadowReport struct {
+	Name          string                      `json:"name"`
+	Strategy      string                      `json:"strategy"`
+	Since         time.Time                   `json:"since"`
+	Evaluated     int64                       `json:"evaluated"`
+	Agreed        int64                       `json:"agreed"`
+	AgreementRate float64                     `json:"agreement_rate"`
+	Confusion     map[string]map[string]int64 `json:"confusion"`
+	Examples      []ShadowExample             `json:"examples"`
+}
+
+// ShadowEvaluator corre estrategias candidatas en paralelo a la activa sin
+// afectar a los mensajes, para comparar sus decisiones sobre tráfico real
+type ShadowEvaluator struct {
+	candidates map[string]*shadowCandidate
+	mutex      sync.RWMutex
+}
+
+func NewShadowEvaluator() *ShadowEvaluator {
+	return &ShadowEvaluator{candidates: make(map[string]*shadowCandidate)}
+}
+
+// AddCandidate registra una estrategia del registro como candidata
+func (se *ShadowEvaluator) AddCandidate(name string) error {
+	strategy, err := strategies.Create(name)
+	if err != nil {
+		return err
+	}
+
+	se.mutex.Lock()
+	defer se.mutex.Unlock()
+	key := strings.ToLower(name)
+	if _, exists := se.candidates[key]; exists {
+		return errors.New("la estrategia ya está en modo sombra: " + name)
+	}
+	se.candidates[key] = &shadowCandidate{
+		name:      key,
+		strategy:  strategy,
+		since:     time.Now(),
+		confusion: make(map[string]map[string]int64),
+	}
+	componentLog("shadow").Info("shadow strategy registered", "strategy", strategy.GetName())
+	return nil
+}
+
+// RemoveCandidate deja de evaluar una candidata y devuelve su estrategia
+func (se *ShadowEvaluator) RemoveCandidate(name string) (ModerationStrategy, bool) {
+	se.mutex.Lock()
+	defer se.mutex.Unlock()
+	key := strings.ToLower(name)
+	candidate, exists := se.candidates[key]
+	if !exists {
+		return nil, false
+	}
+	delete(se.candidates, key)
+	return candidate.strategy, true
+}
+
+// Evaluate compara en segundo plano la decisión activa con cada candidata
+func (se *ShadowEvaluator) Evaluate(message string, ctx MessageContext, active ModerationResult) {
+	se.mutex.RLock()
+	candidates := make([]*shadowCandidate, 0, len(se.candidates))
+	for _, candidate := range se.candidates {
+		candidates = append(candidates, candidate)
+	}
+	se.mutex.RUnlock()
+
+	if len(candidates) == 0 {
+		return
+	}
+	go func() {
+		for _, candidate := range candidates {
+			candidate.evaluate(message, ctx, active)
+		}
+	}()
+}
+
+func (sc *shadowCandidate) evaluate(message string, ctx MessageContext, active ModerationResult) {
+	result := moderateWithContext(sc.strategy, message, ctx)
+
+	sc.mutex.Lock()
+	defer sc.mutex.Unlock()
+
+	sc.total++
+	if sc.confusion[active.Action] == nil {
+		sc.confusion[active.Action] = make(map[string]int64)
+	}
+	sc.confusion[active.Action][result.Action]++
+
+	if sameDecision(active, result) {
+		sc.agreed++
+		return
+	}
+	sc.examples = append(sc.examples, ShadowExample{
+		Message:   message,
+		Active:    active,
+		Candidate: result,
+		Timestamp: time.Now(),
+	})
+	if len(sc.examples) > maxShadowExamples {
+		sc.examples = sc.examples[1:]
+	}
+}
+
+// sameDecision considera iguales dos resultados con la misma acción y el mismo texto final
+func sameDecision(a, b ModerationResult) bool {
+	if a.Action != b.Action {
+		return false
+	}
+	return a.Action != "modify" || a.ModifiedMessage == b.ModifiedMessage
+}
+
+// Reports devuelve el resumen de todas las candidatas
+func (se *ShadowEvaluator) Reports() []ShadowReport {
+	se.mutex.RLock()
+	defer se.mutex.RUnlock()
+
+	reports := []ShadowReport{}
+	for _, candidate := range se.candidates {
+		reports = append(reports, candidate.report())
+	}
+	sort.Slice(reports, func(i, j int) bool { return reports[i].Name < reports[j].Name })
+	return reports
+}
+
+func (sc *shadowCandidate) report() ShadowReport {
+	sc.mutex.Lock()
+	defer sc.mutex.Unlock()
+
+	confusion := make(map[string]map[string]int64, len(sc.confusion))
+	for activeAction, row := range sc.confusion {
+		confusion[activeAction] = make(map[string]int64, len(row))
+		for candidateAction, count := range row {
+			confusion[activeAction][candidateAction] = count
+		}
+	}
+
+	rate := 0.0
+	if sc.total > 0 {
+		rate = float64(sc.agreed) / float64(sc.total)
+	}
+	return ShadowReport{
+		Name:          sc.name,
+		Strategy:      sc.strategy.GetName(),
+		Since:         sc.since,
+		Evaluated:     sc.total,
+		Agreed:        sc.agreed,
+		AgreementRate: rate,
+		Confusion:     confusion,
+		Examples:      append([]ShadowExample{}, sc.examples...),
+	}
+}
+
+// handleShadow administra las candidatas en modo sombra:
+// GET lista los reportes, POST {"strategy": "strict"} agrega una candidata
+// y DELETE ?strategy=strict la quita
+func (s *Server) handleShadow(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		writeJSON(w, s.shadow.Reports())
+	case "POST":
+		var req struct {
+			Strategy string `json:"strategy"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "JSON inválido: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := s.shadow.AddCandidate(req.Strategy); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+		writeJSON(w, s.shadow.Reports())
+	case "DELETE":
+		if _, removed := s.shadow.RemoveCandidate(r.URL.Query().Get("strategy")); !removed {
+			http.Error(w, "Candidata no encontrada", http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "Método no permitido", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleShadowPromote convierte una candidata en la estrategia activa
+func (s *Server) handleShadowPromote(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Método no permitido", http.StatusMethodNotAllowed)
+		return
+	}
+	var req struct {
+		Strategy string `json:"strategy"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "JSON inválido: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	strategy, exists := s.shadow.RemoveCandidate(req.Strategy)
+	if !exists {
+		http.Error(w, "Candidata no encontrada", http.StatusNotFound)
+		return
+	}
+	s.SetModerationStrategy(strategy)
+	w.Write([]byte("Estrategia cambiada a " + strategy.GetName()))
+}
@@ -0,0 +1,374 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"log/slog"
+	"math/bits"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Señales que puede detectar la estrategia de spam por comportamiento
+const (
+	SpamSignalDuplicate = "duplicate"
+	SpamSignalBurst     = "burst"
+	SpamSignalMentions  = "mentions"
+	SpamSignalLinks     = "links"
+	SpamSignalCrossRoom = "cross_room"
+)
+
+// SpamConfig configura las heurísticas de spam
+type SpamConfig struct {
+	Window             Duration           `json:"window"`              // historial que se considera por usuario
+	HistorySize        int                `json:"history_size"`        // mensajes recordados por usuario
+	DuplicateDistance  int                `json:"duplicate_distance"`  // bits distintos de simhash para considerar casi duplicado
+	DuplicateThreshold int                `json:"duplicate_threshold"` // repeticiones previas para disparar la señal
+	BurstWindow        Duration           `json:"burst_window"`
+	BurstLimit         int                `json:"burst_limit"` // mensajes dentro de burst_window
+	MaxMentions        int                `json:"max_mentions"`
+	MaxLinkDensity     float64            `json:"max_link_density"` // enlaces por palabra
+	MinLinks           int                `json:"min_links"`        // enlaces mínimos para evaluar la densidad
+	CrossRoomThreshold int                `json:"cross_room_threshold"`
+	NewAccountAge      Duration           `json:"new_account_age"`
+	NewAccountFactor   float64            `json:"new_account_factor"` // multiplica el puntaje de usuarios nuevos
+	Weights            map[string]float64 `json:"weights"`            // señal -> puntaje
+	WarnScore          float64            `json:"warn_score"`
+	BlockScore         float64            `json:"block_score"`
+}
+
+func DefaultSpamConfig() SpamConfig {
+	return SpamConfig{
+		Window:             Duration(10 * time.Minute),
+		HistorySize:        20,
+		DuplicateDistance:  3,
+		DuplicateThreshold: 2,
+		BurstWindow:        Duration(10 * time.Second),
+		BurstLimit:         5,
+		MaxMentions:        5,
+		MaxLinkDensity:     0.5,
+		MinLinks:           2,
+		CrossRoomThreshold: 2,
+		NewAccountAge:      Duration(10 * time.Minute),
+		NewAccountFactor:   1.5,
+		Weights: map[string]float64{
+			SpamSignalDuplicate: 0.5,
+			SpamSignalBurst:     0.5,
+			SpamSignalMentions:  0.4,
+			SpamSignalLinks:     0.4,
+			SpamSignalCrossRoom: 0.6,
+		},
+		WarnScore:  0.5,
+		BlockScore: 1.0,
+	}
+}
+
+// LoadSpamConfig carga la configuración desde SPAM_CONFIG_FILE o usa la de por defecto
+func LoadSpamConfig() SpamConfig {
+	config := DefaultSpamConfig()
+
+	path := os.Getenv("SPAM_CONFIG_FILE")
+	if path == "" {
+		return config
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		slog.Warn("could not read spam config", "path", path, "error", err)
+		return config
+	}
+	if err := json.Unmarshal(data, &config); err != nil {
+		slog.Warn("invalid spam config", "path", path, "error", err)
+		return DefaultSpamConfig()
+	}
+	return config
+}
+
+// spamEntry es un mensaje recordado en el historial de un usuario
+type spamEntry struct {
+	messageID string
+	room      string
+	hash      uint64
+	at        time.Time
+}
+
+// spamKey elige de quién es el historial: la sesión si se conoce, para que
+// cambiar de nombre no lo reinicie, y si no el usuario
+func spamKey(ctx MessageContext) string {
+	if ctx.Session != "" {
+		return "session:" + ctx.Session
+	}
+	return normalizeUsername(ctx.Username)
+}
+
+// spamUser es el historial reciente de un usuario
+type spamUser struct {
+	firstSeen time.Time
+	entries   []spamEntry
+}
+
+// SpamTracker guarda el historial por usuario. Es compartido por todas las
+// instancias de la estrategia para que cambiarla o evaluarla en modo sombra
+// no pierda el historial; registrar dos veces el mismo mensaje no lo duplica.
+type SpamTracker struct {
+	config  SpamConfig
+	users   map[string]*spamUser
+	signals map[string]int64
+	flagged int64
+	calls   int64
+	mutex   sync.Mutex
+}
+
+func NewSpamTracker(config SpamConfig) *SpamTracker {
+	return &SpamTracker{
+		config:  config,
+		users:   make(map[string]*spamUser),
+		signals: make(map[string]int64),
+	}
+}
+
+var (
+	spamTracker     *SpamTracker
+	spamTrackerOnce sync.Once
+)
+
+// sharedSpamTracker crea el historial compartido la primera vez que se usa
+func sharedSpamTracker() *SpamTracker {
+	spamTrackerOnce.Do(func() {
+		spamTracker = NewSpamTracker(LoadSpamConfig())
+	})
+	return spamTracker
+}
+
+var (
+	mentionPattern = regexp.MustCompile(`(^|\s)@[\p{L}\p{N}_.-]+`)
+	linkPattern    = regexp.MustCompile(`(?i)\b(?:https?://|www\.)[^\s]+`)
+)
+
+// simhash calcula una huella de 64 bits en la que textos parecidos difieren en pocos bits
+func simhash(text string) uint64 {
+	runes := []rune(strings.Join(strings.Fields(strings.ToLower(text)), " "))
+	features := []string{string(runes)}
+	if len(runes) > 3 {
+		features = features[:0]
+		for i := 0; i+3 <= len(runes); i++ {
+			features = append(features, string(runes[i:i+3]))
+		}
+	}
+
+	var weights [64]int
+	for _, feature := range features {
+		hasher := fnv.New64a()
+		hasher.Write([]byte(feature))
+		sum := hasher.Sum64()
+		for bit := 0; bit < 64; bit++ {
+			if sum&(1<<uint(bit)) != 0 {
+				weights[bit]++
+			} else {
+				weights[bit]--
+			}
+		}
+	}
+
+	var hash uint64
+	for bit := 0; bit < 64; bit++ {
+		if weights[bit] > 0 {
+			hash |= 1 << uint(bit)
+		}
+	}
+	return hash
+}
+
+// Analyze calcula las señales de spam de un mensaje y lo agrega al historial del usuario
+func (st *SpamTracker) Analyze(message string, ctx MessageContext) (float64, []string) {
+	config := st.config
+	triggered := []string{}
+
+	// Señales que solo dependen del contenido
+	if config.MaxMentions > 0 && len(mentionPattern.FindAllString(message, -1)) > config.MaxMentions {
+		triggered = append(triggered, SpamSignalMentions)
+	}
+	links := len(linkPattern.FindAllString(message, -1))
+	words := len(strings.Fields(message))
+	if links >= config.MinLinks && words > 0 && float64(links)/float64(words) > config.MaxLinkDensity {
+		triggered = append(triggered, SpamSignalLinks)
+	}
+
+	st.mutex.Lock()
+	defer st.mutex.Unlock()
+
+	newAccount, fresh := false, !ctx.DryRun
+	if key := spamKey(ctx); key != "" {
+		now := ctx.Timestamp
+		if now.IsZero() {
+			now = time.Now()
+		}
+		user, exists := st.users[key]
+		if !exists {
+			user = &spamUser{firstSeen: now}
+			if !ctx.DryRun {
+				st.users[key] = user
+			}
+		}
+		firstSeen := user.firstSeen
+		if !ctx.FirstSeen.IsZero() {
+			firstSeen = ctx.FirstSeen
+		}
+		newAccount = now.Sub(firstSeen) < time.Duration(config.NewAccountAge)
+
+		// Descartar lo que salió de la ventana
+		cutoff := now.Add(-time.Duration(config.Window))
+		kept := user.entries[:0]
+		for _, entry := range user.entries {
+			if entry.at.After(cutoff) {
+				kept = append(kept, entry)
+			}
+		}
+		user.entries = kept
+
+		hash := simhash(message)
+		duplicates, burst := 0, 1
+		rooms := map[string]bool{ctx.Room: true}
+		recorded := false
+		for _, entry := range user.entries {
+			if ctx.MessageID != "" && entry.messageID == ctx.MessageID {
+				recorded = true
+				continue
+			}
+			if now.Sub(entry.at) < time.Duration(config.BurstWindow) {
+				burst++
+			}
+			if bits.OnesCount64(entry.hash^hash) <= config.DuplicateDistance {
+				duplicates++
+				rooms[entry.room] = true
+			}
+		}
+
+		if config.DuplicateThreshold > 0 && duplicates >= config.DuplicateThreshold {
+			triggered = append(triggered, SpamSignalDuplicate)
+		}
+		if config.BurstLimit > 0 && burst > config.BurstLimit {
+			triggered = append(triggered, SpamSignalBurst)
+		}
+		if config.CrossRoomThreshold > 0 && len(rooms) >= config.CrossRoomThreshold {
+			triggered = append(triggered, SpamSignalCrossRoom)
+		}
+
+		fresh = !recorded && !ctx.DryRun
+		if fresh {
+			user.entries = append(user.entries, spamEntry{messageID: ctx.MessageID, room: ctx.Room, hash: hash, at: now})
+			if config.HistorySize > 0 && len(user.entries) > config.HistorySize {
+				user.entries = user.entries[len(user.entries)-config.HistorySize:]
+			}
+		}
+	}
+
+	// De vez en cuando se olvidan los usuarios sin mensajes dentro de la ventana
+	if st.calls++; st.calls%1000 == 0 {
+		st.pruneIdle(time.Now())
+	}
+
+	// Las estadísticas cuentan cada mensaje una sola vez aunque lo evalúen varias instancias
+	score := 0.0
+	for _, signal := range triggered {
+		score += config.Weights[signal]
+		if fresh {
+			st.signals[signal]++
+		}
+	}
+	if newAccount && len(triggered) > 0 && config.NewAccountFactor > 0 {
+		score *= config.NewAccountFactor
+		triggered = append(triggered, "new_account")
+	}
+	if fresh && len(triggered) > 0 {
+		st.flagged++
+	}
+	return score, triggered
+}
+
+// pruneIdle descarta a los usuarios cuyo último mensaje salió de la ventana.
+// Si vuelven, su antigüedad se toma del contexto del mensaje (FirstSeen).
+func (st *SpamTracker) pruneIdle(now time.Time) {
+	cutoff := now.Add(-time.Duration(st.config.Window))
+	for key, user := range st.users {
+		if len(user.entries) == 0 || !user.entries[len(user.entries)-1].at.After(cutoff) {
+			delete(st.users, key)
+		}
+	}
+}
+
+// GetStats retorna el resumen del detector de spam para /moderation/stats
+func (st *SpamTracker) GetStats() map[string]interface{} {
+	st.mutex.Lock()
+	defer st.mutex.Unlock()
+
+	signals := make(map[string]int64, len(st.signals))
+	for signal, count := range st.signals {
+		signals[signal] = count
+	}
+	return map[string]interface{}{
+		"tracked_users":    len(st.users),
+		"flagged_messages": st.flagged,
+		"signals":          signals,
+	}
+}
+
+// SpamStrategy detecta spam por el comportamiento del usuario: mensajes
+// repetidos, ráfagas, menciones y enlaces en exceso, cuentas nuevas y
+// repetición entre salas
+type SpamStrategy struct {
+	tracker *SpamTracker
+}
+
+func NewSpamStrategy() *SpamStrategy {
+	return &SpamStrategy{tracker: sharedSpamTracker()}
+}
+
+// NewSpamStrategyWithTracker crea la estrategia con un historial propio
+func NewSpamStrategyWithTracker(tracker *SpamTracker) *SpamStrategy {
+	return &SpamStrategy{tracker: tracker}
+}
+
+func (ss *SpamStrategy) Moderate(message string) ModerationResult {
+	return ss.ModerateWithContext(message, MessageContext{})
+}
+
+func (ss *SpamStrategy) ModerateWithContext(message string, ctx MessageContext) ModerationResult {
+	score, signals := ss.tracker.Analyze(message, ctx)
+	config := ss.tracker.config
+	sort.Strings(signals)
+
+	result := ModerationResult{
+		OriginalMessage: message,
+		ModifiedMessage: message,
+		Action:          "allow",
+		Reason:          "No spam behavior detected",
+		Confidence:      score,
+		Timestamp:       time.Now(),
+		StrategyUsed:    ss.GetName(),
+		MatchedTerms:    signals,
+	}
+	if result.Confidence > 1 {
+		result.Confidence = 1
+	}
+
+	switch {
+	case score >= config.BlockScore:
+		result.Action = "block"
+		result.ModifiedMessage = ""
+		result.Reason = fmt.Sprintf("Spam behavior detected (score %.2f): %s", score, strings.Join(signals, ", "))
+	case score >= config.WarnScore:
+		result.Action = "warn"
+		result.Reason = fmt.Sprintf("Possible spam behavior (score %.2f): %s", score, strings.Join(signals, ", "))
+	}
+	return result
+}
+
+func (ss *SpamStrategy) GetName() string {
+	return "SpamBehavior"
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSpamHistoryFollowsSession(t *testing.T) {
+	config := DefaultSpamConfig()
+	tracker := NewSpamTracker(config)
+	now := time.Now()
+
+	// La misma sesión repite el mensaje cambiando de nombre cada vez
+	var signals []string
+	for i, name := range []string{"ana", "ana2", "ana3"} {
+		ctx := MessageContext{Username: name, Session: "ses_ana", Room: "general", Timestamp: now.Add(time.Duration(i) * time.Second)}
+		_, signals = tracker.Analyze("compra seguidores baratos aquí", ctx)
+	}
+	if !containsFold(signals, SpamSignalDuplicate) {
+		t.Errorf("cambiar de nombre no debería reiniciar el historial, señales = %v", signals)
+	}
+
+	// Otra sesión con el mismo nombre empieza con el historial vacío
+	ctx := MessageContext{Username: "ana3", Session: "ses_otra", Room: "general", Timestamp: now.Add(5 * time.Second)}
+	if _, signals := tracker.Analyze("compra seguidores baratos aquí", ctx); containsFold(signals, SpamSignalDuplicate) {
+		t.Errorf("otra sesión no debería heredar el historial, señales = %v", signals)
+	}
+}
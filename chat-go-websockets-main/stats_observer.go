@@ -1,7 +1,7 @@
 package main
 
 import (
-	"fmt"
+	"log/slog"
 	"sync"
 	"time"
 )
@@ -49,7 +49,7 @@ func (so *StatsObserver) Update(event Event) {
 		break
 		
 	case SystemEvent:
-		fmt.Printf("[STATS] System message: %s\n", event.Message)
+		slog.Debug("system message", "component", "stats", "message", event.Message)
 	}
 }
 
@@ -82,7 +82,7 @@ func (so *StatsObserver) getMostActiveUsers() map[string]int64 {
 	return result
 }
 
-// PrintStats imprime estadísticas cada cierto tiempo
+// StartStatsTimer registra las estadísticas cada cierto tiempo
 func (so *StatsObserver) StartStatsTimer(interval time.Duration) {
 	go func() {
 		ticker := time.NewTicker(interval)
@@ -90,12 +90,13 @@ func (so *StatsObserver) StartStatsTimer(interval time.Duration) {
 		
 		for range ticker.C {
 			stats := so.GetStats()
-			fmt.Println("\n=== ESTADÍSTICAS DEL CHAT ===")
-			fmt.Printf("Total de mensajes: %v\n", stats["total_messages"])
-			fmt.Printf("Usuarios únicos: %v\n", stats["total_unique_users"])
-			fmt.Printf("Tiempo activo: %.1f minutos\n", stats["uptime_minutes"])
-			fmt.Printf("Usuarios activos: %v\n", stats["most_active_users"])
-			fmt.Println("=============================\n")
+			slog.Info("chat stats",
+				"component", "stats",
+				"total_messages", stats["total_messages"],
+				"unique_users", stats["total_unique_users"],
+				"uptime_minutes", stats["uptime_minutes"],
+				"active_users", stats["most_active_users"],
+			)
 		}
 	}()
 }
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// dataPath devuelve la ruta de un archivo dentro del directorio de datos
+// persistentes (DATA_DIR, por defecto ./data)
+func dataPath(name string) string {
+	dir := os.Getenv("DATA_DIR")
+	if dir == "" {
+		dir = "data"
+	}
+	return filepath.Join(dir, name)
+}
+
+// writeJSONFile guarda v como JSON de forma atómica (archivo temporal + rename)
+func writeJSONFile(path string, v interface{}) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// readJSONFile carga un archivo JSON en v. Si el archivo no existe no se
+// considera un error y v queda sin modificar.
+func readJSONFile(path string, v interface{}) error {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// jsonSaver guarda un archivo JSON en segundo plano. MarkDirty avisa que los
+// datos cambiaron y el archivo se escribe a lo sumo una vez por interval,
+// fuera del mutex de quien lo usa: snapshot copia los datos tomando su propio
+// lock y el JSON se arma después. Close escribe lo que haya quedado pendiente.
+type jsonSaver struct {
+	path      string
+	component string
+	interval  time.Duration
+	snapshot  func() interface{}
+	dirty     chan bool
+	stop      chan bool
+	done      chan bool
+	closeOnce sync.Once
+}
+
+func newJSONSaver(path, component string, interval time.Duration, snapshot func() interface{}) *jsonSaver {
+	js := &jsonSaver{
+		path:      path,
+		component: component,
+		interval:  interval,
+		snapshot:  snapshot,
+		dirty:     make(chan bool, 1),
+		stop:      make(chan bool),
+		done:      make(chan bool),
+	}
+	go js.run()
+	return js
+}
+
+// MarkDirty pide guardar el archivo; varios pedidos seguidos se juntan en una escritura
+func (js *jsonSaver) MarkDirty() {
+	select {
+	case js.dirty <- true:
+	default:
+	}
+}
+
+func (js *jsonSaver) run() {
+	defer close(js.done)
+	for {
+		select {
+		case <-js.dirty:
+		case <-js.stop:
+			js.flush()
+			return
+		}
+		// Esperar para juntar los cambios que lleguen mientras tanto
+		timer := time.NewTimer(js.interval)
+		select {
+		case <-timer.C:
+			js.write()
+		case <-js.stop:
+			timer.Stop()
+			js.write()
+			return
+		}
+	}
+}
+
+// flush escribe el archivo si quedó un pedido sin atender
+func (js *jsonSaver) flush() {
+	select {
+	case <-js.dirty:
+		js.write()
+	default:
+	}
+}
+
+func (js *jsonSaver) write() {
+	if err := writeJSONFile(js.path, js.snapshot()); err != nil {
+		componentLog(js.component).Error("could not save file", "path", js.path, "error", err)
+	}
+}
+
+// Close escribe los cambios pendientes y detiene el guardado en segundo plano
+func (js *jsonSaver) Close() {
+	js.closeOnce.Do(func() {
+		close(js.stop)
+		<-js.done
+	})
+}
@@ -1,9 +1,14 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
+	"math"
 	"regexp"
+	"sort"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -13,15 +18,49 @@ type ModerationStrategy interface {
 	GetName() string
 }
 
+// MessageContext describe quién envía un mensaje y dónde, para las estrategias
+// que deciden según el comportamiento del usuario y no solo según el texto
+type MessageContext struct {
+	MessageID string    `json:"message_id,omitempty"`
+	Username  string    `json:"username,omitempty"`
+	Session   string    `json:"session,omitempty"` // sesión emitida por el servidor
+	Room      string    `json:"room,omitempty"`
+	SenderID  string    `json:"sender_id,omitempty"`
+	IP        string    `json:"ip,omitempty"`
+	FirstSeen time.Time `json:"first_seen,omitempty"` // primer mensaje del usuario en este servidor
+	Language  string    `json:"language,omitempty"`   // idioma detectado; vacío = cada estrategia lo detecta
+	Timestamp time.Time `json:"timestamp"`
+	DryRun    bool      `json:"dry_run,omitempty"` // prueba: las estrategias no deben guardar estado
+}
+
+// ContextualModerationStrategy es una estrategia que además usa el contexto del mensaje.
+// Moderate(message) sigue funcionando sin contexto (por ejemplo en moderate-eval).
+type ContextualModerationStrategy interface {
+	ModerationStrategy
+	ModerateWithContext(message string, ctx MessageContext) ModerationResult
+}
+
+// moderateWithContext usa el contexto solo si la estrategia lo soporta
+func moderateWithContext(strategy ModerationStrategy, message string, ctx MessageContext) ModerationResult {
+	if contextual, ok := strategy.(ContextualModerationStrategy); ok {
+		return contextual.ModerateWithContext(message, ctx)
+	}
+	return strategy.Moderate(message)
+}
+
 // ModerationResult contiene el resultado del proceso de moderación
 type ModerationResult struct {
-	OriginalMessage string    `json:"original_message"`
-	ModifiedMessage string    `json:"modified_message"`
-	Action          string    `json:"action"` // "allow", "modify", "block", "warn"
-	Reason          string    `json:"reason"`
-	Confidence      float64   `json:"confidence"` // 0.0 - 1.0
-	Timestamp       time.Time `json:"timestamp"`
-	StrategyUsed    string    `json:"strategy_used"`
+	OriginalMessage string           `json:"original_message"`
+	ModifiedMessage string           `json:"modified_message"`
+	Action          string           `json:"action"` // "allow", "modify", "block", "warn"
+	Reason          string           `json:"reason"`
+	Confidence      float64          `json:"confidence"` // 0.0 - 1.0
+	Timestamp       time.Time        `json:"timestamp"`
+	StrategyUsed    string           `json:"strategy_used"`
+	MatchedTerms    []string         `json:"matched_terms,omitempty"`
+	Trace           []ModerationStep `json:"trace,omitempty"` // resultado de cada paso de una estrategia compuesta
+	Language        string           `json:"language,omitempty"` // idioma detectado del mensaje
+	Fallback        bool             `json:"fallback,omitempty"` // la estrategia no pudo decidir (servicio caído): no es un veredicto
 }
 
 // ModerationContext maneja las estrategias de moderación
@@ -40,6 +79,11 @@ func (mc *ModerationContext) SetStrategy(strategy ModerationStrategy) {
 }
 
 func (mc *ModerationContext) ModerateMessage(message string) ModerationResult {
+	return mc.ModerateMessageWithContext(message, MessageContext{})
+}
+
+// ModerateMessageWithContext modera pasando quién envía el mensaje y en qué sala
+func (mc *ModerationContext) ModerateMessageWithContext(message string, ctx MessageContext) ModerationResult {
 	if mc.strategy == nil {
 		return ModerationResult{
 			OriginalMessage: message,
@@ -51,46 +95,129 @@ func (mc *ModerationContext) ModerateMessage(message string) ModerationResult {
 			StrategyUsed:    "none",
 		}
 	}
-	return mc.strategy.Moderate(message)
+	return moderateWithContext(mc.strategy, message, ctx)
+}
+
+// StrategyFactory crea una nueva instancia de una estrategia de moderación
+type StrategyFactory func() ModerationStrategy
+
+// StrategyRegistry permite obtener estrategias por nombre
+type StrategyRegistry struct {
+	factories map[string]StrategyFactory
+	mutex     sync.RWMutex
+}
+
+// strategies es el registro global con las estrategias disponibles
+var strategies = &StrategyRegistry{
+	factories: map[string]StrategyFactory{
+		"badword":   func() ModerationStrategy { return NewBadWordReplacementStrategy() },
+		"strict":    func() ModerationStrategy { return NewStrictBlockingStrategy() },
+		"warning":   func() ModerationStrategy { return NewWarningStrategy() },
+		"composite": func() ModerationStrategy { return NewCompositeModerationStrategy() },
+		"spam":      func() ModerationStrategy { return NewSpamStrategy() },
+		"links":     func() ModerationStrategy { return NewLinkPolicyStrategy() },
+	},
+}
+
+// Register agrega o reemplaza una estrategia en el registro
+func (sr *StrategyRegistry) Register(name string, factory StrategyFactory) {
+	sr.mutex.Lock()
+	defer sr.mutex.Unlock()
+	sr.factories[strings.ToLower(name)] = factory
+}
+
+// Unregister quita una estrategia del registro
+func (sr *StrategyRegistry) Unregister(name string) {
+	sr.mutex.Lock()
+	defer sr.mutex.Unlock()
+	delete(sr.factories, strings.ToLower(name))
+}
+
+// Create instancia la estrategia registrada con ese nombre
+func (sr *StrategyRegistry) Create(name string) (ModerationStrategy, error) {
+	sr.mutex.RLock()
+	factory, exists := sr.factories[strings.ToLower(name)]
+	sr.mutex.RUnlock()
+	if !exists {
+		return nil, fmt.Errorf("estrategia desconocida: %s", name)
+	}
+	return factory(), nil
+}
+
+// NameFor busca con qué nombre está registrada una estrategia, comparando GetName
+func (sr *StrategyRegistry) NameFor(strategy ModerationStrategy) (string, bool) {
+	for _, name := range sr.Names() {
+		candidate, err := sr.Create(name)
+		if err == nil && candidate.GetName() == strategy.GetName() {
+			return name, true
+		}
+	}
+	return "", false
+}
+
+// Names devuelve los nombres registrados ordenados alfabéticamente
+func (sr *StrategyRegistry) Names() []string {
+	sr.mutex.RLock()
+	defer sr.mutex.RUnlock()
+
+	names := make([]string, 0, len(sr.factories))
+	for name := range sr.factories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
 }
 
 // BadWordReplacementStrategy reemplaza malas palabras con asteriscos
 type BadWordReplacementStrategy struct {
-	badWords    []string
+	dictionary  Dictionary
 	replacement string
 }
 
 func NewBadWordReplacementStrategy() *BadWordReplacementStrategy {
-	// Lista de palabras que queremos censurar
-	badWords := []string{
-		"malo", "feo", "tonto", "idiota", "estúpido", "imbécil",
-		"odio", "asco", "basura", "mierda", "joder", "puta",
-		"cabrón", "hijo de puta", "maldito", "desgraciado",
-		"mallo", "mal", "tonta", "estupido", "imbecil",
-		"puto", "cabron", "mierda", "jodido", "jodida",
-		"hdp", "hijo de puta", "conchudo", "pelotudo",
-		"boludo", "gil", "gila", "boluda", "pelotuda",
-		// Agregar más palabras según sea necesario
+	// Listas de palabras que queremos censurar, por idioma
+	dictionary := Dictionary{
+		"es": {
+			"malo", "feo", "tonto", "idiota", "estúpido", "imbécil",
+			"odio", "asco", "basura", "mierda", "joder", "puta",
+			"cabrón", "hijo de puta", "maldito", "desgraciado",
+			"mallo", "mal", "tonta", "estupido", "imbecil",
+			"puto", "cabron", "mierda", "jodido", "jodida",
+			"hdp", "hijo de puta", "conchudo", "pelotudo",
+			"boludo", "gil", "gila", "boluda", "pelotuda",
+		},
+		// Agregar más idiomas o palabras según sea necesario
 	}
-	
+
 	return &BadWordReplacementStrategy{
-		badWords:    badWords,
+		dictionary:  loadDictionary("badword", dictionary),
 		replacement: "***",
 	}
 }
 
+// NewBadWordReplacementStrategyWithWords crea la estrategia con una lista de palabras propia
+func NewBadWordReplacementStrategyWithWords(badWords []string, replacement string) *BadWordReplacementStrategy {
+	return &BadWordReplacementStrategy{
+		dictionary:  Dictionary{"*": badWords},
+		replacement: replacement,
+	}
+}
+
 func (bwrs *BadWordReplacementStrategy) Moderate(message string) ModerationResult {
+	return bwrs.ModerateWithContext(message, MessageContext{})
+}
+
+// ModerateWithContext aplica la lista del idioma del mensaje
+func (bwrs *BadWordReplacementStrategy) ModerateWithContext(message string, ctx MessageContext) ModerationResult {
 	originalMessage := message
 	modifiedMessage := message
 	action := "allow"
 	reason := "No inappropriate content detected"
 	confidence := 0.0
 	wordsFound := []string{}
+	language := messageLanguage(message, ctx)
 
-	// Convertir a minúsculas para comparación
-	// lowerMessage := strings.ToLower(message)
-	
-	for _, badWord := range bwrs.badWords {
+	for _, badWord := range bwrs.dictionary.Words(language) {
 		// Usar regex para encontrar palabras completas (case insensitive)
 		pattern := `(?i)\b` + regexp.QuoteMeta(badWord) + `\b`
 		regex, err := regexp.Compile(pattern)
@@ -98,10 +225,10 @@ func (bwrs *BadWordReplacementStrategy) Moderate(message string) ModerationResul
 			continue
 		}
 		
-		if regex.MatchString(message) {
+		if regex.MatchString(modifiedMessage) {
 			wordsFound = append(wordsFound, badWord)
 			// Reemplazar todas las ocurrencias de la palabra
-			modifiedMessage = regex.ReplaceAllString(message, bwrs.replacement)
+			modifiedMessage = regex.ReplaceAllString(modifiedMessage, bwrs.replacement)
 			action = "modify"
 			reason = "Inappropriate words detected and replaced: " + strings.Join(wordsFound, ", ")
 			confidence = 0.8
@@ -116,6 +243,8 @@ func (bwrs *BadWordReplacementStrategy) Moderate(message string) ModerationResul
 		Confidence:      confidence,
 		Timestamp:       time.Now(),
 		StrategyUsed:    bwrs.GetName(),
+		MatchedTerms:    wordsFound,
+		Language:        language,
 	}
 }
 
@@ -125,25 +254,40 @@ func (bwrs *BadWordReplacementStrategy) GetName() string {
 
 // StrictBlockingStrategy bloquea mensajes con contenido inapropiado
 type StrictBlockingStrategy struct {
-	badWords []string
+	dictionary Dictionary
 }
 
 func NewStrictBlockingStrategy() *StrictBlockingStrategy {
-	badWords := []string{
-		"spam", "scam", "hack", "virus", "malware",
-		"phishing", "fraud", "illegal", "drugs",
-		// Palabras más severas que requieren bloqueo
+	// Palabras más severas que requieren bloqueo; se aplican en todos los idiomas
+	dictionary := Dictionary{
+		"*": {
+			"spam", "scam", "hack", "virus", "malware",
+			"phishing", "fraud", "illegal", "drugs",
+		},
 	}
 	
 	return &StrictBlockingStrategy{
-		badWords: badWords,
+		dictionary: loadDictionary("strict", dictionary),
+	}
+}
+
+// NewStrictBlockingStrategyWithWords crea la estrategia con una lista de palabras propia
+func NewStrictBlockingStrategyWithWords(badWords []string) *StrictBlockingStrategy {
+	return &StrictBlockingStrategy{
+		dictionary: Dictionary{"*": badWords},
 	}
 }
 
 func (sbs *StrictBlockingStrategy) Moderate(message string) ModerationResult {
+	return sbs.ModerateWithContext(message, MessageContext{})
+}
+
+// ModerateWithContext aplica la lista del idioma del mensaje
+func (sbs *StrictBlockingStrategy) ModerateWithContext(message string, ctx MessageContext) ModerationResult {
 	lowerMessage := strings.ToLower(message)
+	language := messageLanguage(message, ctx)
 	
-	for _, badWord := range sbs.badWords {
+	for _, badWord := range sbs.dictionary.Words(language) {
 		if strings.Contains(lowerMessage, strings.ToLower(badWord)) {
 			return ModerationResult{
 				OriginalMessage: message,
@@ -153,6 +297,8 @@ func (sbs *StrictBlockingStrategy) Moderate(message string) ModerationResult {
 				Confidence:      0.9,
 				Timestamp:       time.Now(),
 				StrategyUsed:    sbs.GetName(),
+				MatchedTerms:    []string{badWord},
+				Language:        language,
 			}
 		}
 	}
@@ -165,6 +311,7 @@ func (sbs *StrictBlockingStrategy) Moderate(message string) ModerationResult {
 		Confidence:      0.1,
 		Timestamp:       time.Now(),
 		StrategyUsed:    sbs.GetName(),
+		Language:        language,
 	}
 }
 
@@ -174,25 +321,40 @@ func (sbs *StrictBlockingStrategy) GetName() string {
 
 // WarningStrategy envía advertencias pero permite el mensaje
 type WarningStrategy struct {
-	warningWords []string
+	dictionary Dictionary
 }
 
 func NewWarningStrategy() *WarningStrategy {
-	warningWords := []string{
-		"violencia", "agresión", "amenaza", "peligro",
-		"riesgo", "cuidado", "atención",
+	dictionary := Dictionary{
+		"es": {
+			"violencia", "agresión", "amenaza", "peligro",
+			"riesgo", "cuidado", "atención",
+		},
 	}
 	
 	return &WarningStrategy{
-		warningWords: warningWords,
+		dictionary: loadDictionary("warning", dictionary),
+	}
+}
+
+// NewWarningStrategyWithWords crea la estrategia con una lista de palabras propia
+func NewWarningStrategyWithWords(warningWords []string) *WarningStrategy {
+	return &WarningStrategy{
+		dictionary: Dictionary{"*": warningWords},
 	}
 }
 
 func (ws *WarningStrategy) Moderate(message string) ModerationResult {
+	return ws.ModerateWithContext(message, MessageContext{})
+}
+
+// ModerateWithContext aplica la lista del idioma del mensaje
+func (ws *WarningStrategy) ModerateWithContext(message string, ctx MessageContext) ModerationResult {
 	lowerMessage := strings.ToLower(message)
+	language := messageLanguage(message, ctx)
 	warnings := []string{}
 	
-	for _, warningWord := range ws.warningWords {
+	for _, warningWord := range ws.dictionary.Words(language) {
 		if strings.Contains(lowerMessage, strings.ToLower(warningWord)) {
 			warnings = append(warnings, warningWord)
 		}
@@ -207,6 +369,8 @@ func (ws *WarningStrategy) Moderate(message string) ModerationResult {
 			Confidence:      0.6,
 			Timestamp:       time.Now(),
 			StrategyUsed:    ws.GetName(),
+			MatchedTerms:    warnings,
+			Language:        language,
 		}
 	}
 	
@@ -218,6 +382,7 @@ func (ws *WarningStrategy) Moderate(message string) ModerationResult {
 		Confidence:      0.2,
 		Timestamp:       time.Now(),
 		StrategyUsed:    ws.GetName(),
+		Language:        language,
 	}
 }
 
@@ -228,39 +393,98 @@ func (ws *WarningStrategy) GetName() string {
 // CompositeModerationStrategy combina múltiples estrategias
 type CompositeModerationStrategy struct {
 	strategies []ModerationStrategy
-	name        string
+	steps      []CompositeStep
+	merge      string
+	confidence string
+	name       string
+}
+
+// CompositeStep configura un paso de la estrategia compuesta
+type CompositeStep struct {
+	Strategy string   `json:"strategy"`
+	StopOn   []string `json:"stop_on"`         // acciones que cortan la cadena; null usa ["block"] y [] no corta nunca
+	Weight   float64  `json:"weight,omitempty"` // peso en la confianza "mean"; por defecto 1
+}
+
+// Modos para combinar las acciones de los pasos
+const (
+	MergeMostSevere = "most_severe" // gana la acción más severa (block > modify > warn > allow)
+	MergeFirst      = "first"       // gana la primera acción distinta de allow
+)
+
+// Modos para combinar las confianzas de los pasos
+const (
+	ConfidenceMax  = "max"  // la mayor confianza entre los pasos que dieron la acción final
+	ConfidenceMean = "mean" // promedio ponderado de los pasos que no dieron allow
+)
+
+// actionSeverity ordena las acciones de menos a más severa
+var actionSeverity = map[string]int{
+	"allow":  0,
+	"warn":   1,
+	"modify": 2,
+	"block":  3,
+}
+
+// ModerationStep es el resultado de un paso dentro de una estrategia compuesta
+type ModerationStep struct {
+	Strategy        string   `json:"strategy"`
+	Action          string   `json:"action"`
+	Reason          string   `json:"reason"`
+	Confidence      float64  `json:"confidence"`
+	ModifiedMessage string   `json:"modified_message"`
+	MatchedTerms    []string `json:"matched_terms,omitempty"`
+	Stopped         bool     `json:"stopped,omitempty"` // la cadena se cortó en este paso
+	Fallback        bool     `json:"fallback,omitempty"`
 }
 
 func NewCompositeModerationStrategy() *CompositeModerationStrategy {
+	return NewNamedCompositeStrategy("Composite",
+		NewStrictBlockingStrategy(),
+		NewBadWordReplacementStrategy(),
+		NewWarningStrategy(),
+	)
+}
+
+// NewNamedCompositeStrategy combina las estrategias dadas en el orden indicado,
+// cortando en el primer block y quedándose con la acción más severa
+func NewNamedCompositeStrategy(name string, strategies ...ModerationStrategy) *CompositeModerationStrategy {
+	steps := make([]CompositeStep, len(strategies))
+	for i, strategy := range strategies {
+		steps[i] = CompositeStep{Strategy: strategy.GetName()}
+	}
+	return NewConfiguredCompositeStrategy(name, strategies, steps, MergeMostSevere, ConfidenceMax)
+}
+
+// NewConfiguredCompositeStrategy crea una compuesta con reglas de corte y de combinación propias.
+// steps[i] configura strategies[i].
+func NewConfiguredCompositeStrategy(name string, strategies []ModerationStrategy, steps []CompositeStep, merge, confidence string) *CompositeModerationStrategy {
+	if merge == "" {
+		merge = MergeMostSevere
+	}
+	if confidence == "" {
+		confidence = ConfidenceMax
+	}
 	return &CompositeModerationStrategy{
-		strategies: []ModerationStrategy{
-			NewStrictBlockingStrategy(),
-			NewBadWordReplacementStrategy(),
-			NewWarningStrategy(),
-		},
-		name: "Composite",
+		strategies: strategies,
+		steps:      steps,
+		merge:      merge,
+		confidence: confidence,
+		name:       name,
 	}
 }
 
 func (cms *CompositeModerationStrategy) Moderate(message string) ModerationResult {
-	// Ejecutar estrategias en orden de prioridad
-	for _, strategy := range cms.strategies {
-		result := strategy.Moderate(message)
-		
-		// Si alguna estrategia bloquea, retornar inmediatamente
-		if result.Action == "block" {
-			result.StrategyUsed = cms.GetName()
-			return result
-		}
-		
-		// Si se modificó el mensaje, usar el mensaje modificado para la siguiente estrategia
-		if result.Action == "modify" {
-			message = result.ModifiedMessage
-		}
+	return cms.ModerateWithContext(message, MessageContext{})
+}
+
+// ModerateWithContext pasa el contexto a las estrategias que lo usan
+func (cms *CompositeModerationStrategy) ModerateWithContext(message string, ctx MessageContext) ModerationResult {
+	// Detectar el idioma una sola vez, sobre el texto original
+	if ctx.Language == "" {
+		ctx.Language = DetectLanguage(message)
 	}
-	
-	// Si llegamos aquí, el mensaje fue procesado por todas las estrategias
-	return ModerationResult{
+	final := ModerationResult{
 		OriginalMessage: message,
 		ModifiedMessage: message,
 		Action:          "allow",
@@ -268,7 +492,102 @@ func (cms *CompositeModerationStrategy) Moderate(message string) ModerationResul
 		Confidence:      0.3,
 		Timestamp:       time.Now(),
 		StrategyUsed:    cms.GetName(),
+		Language:        ctx.Language,
+	}
+
+	// Ejecutar estrategias en orden de prioridad; cada una recibe el texto ya
+	// modificado por las anteriores
+	current := message
+	reasons := []string{}
+	decided := []ModerationStep{} // pasos que dieron la acción final
+	weightSum, weightedConfidence := 0.0, 0.0
+	for i, strategy := range cms.strategies {
+		result := moderateWithContext(strategy, current, ctx)
+		step := ModerationStep{
+			Strategy:        result.StrategyUsed,
+			Action:          result.Action,
+			Reason:          result.Reason,
+			Confidence:      result.Confidence,
+			ModifiedMessage: result.ModifiedMessage,
+			MatchedTerms:    result.MatchedTerms,
+			Fallback:        result.Fallback,
+		}
+		config := cms.stepConfig(i)
+
+		if result.Action != "allow" {
+			reasons = append(reasons, result.StrategyUsed+": "+result.Reason)
+			final.MatchedTerms = append(final.MatchedTerms, result.MatchedTerms...)
+			weight := config.Weight
+			if weight <= 0 {
+				weight = 1
+			}
+			weightSum += weight
+			weightedConfidence += weight * result.Confidence
+
+			if cms.wins(result.Action, final.Action) {
+				final.Action = result.Action
+				decided = decided[:0]
+			}
+			if result.Action == final.Action {
+				decided = append(decided, step)
+			}
+		}
+		// Las transformaciones se encadenan aunque la acción sea allow (por ejemplo, enlaces reescritos)
+		if result.Action != "block" && result.ModifiedMessage != "" {
+			current = result.ModifiedMessage
+		}
+
+		if containsFold(config.StopOn, result.Action) {
+			step.Stopped = true
+			final.Trace = append(final.Trace, step)
+			break
+		}
+		final.Trace = append(final.Trace, step)
+	}
+
+	final.ModifiedMessage = current
+	if final.Action == "block" {
+		final.ModifiedMessage = ""
 	}
+	// Si la acción final salió solo de pasos que no pudieron decidir, el
+	// resultado tampoco es un veredicto
+	final.Fallback = len(decided) > 0
+	for _, step := range decided {
+		final.Fallback = final.Fallback && step.Fallback
+	}
+	if len(reasons) > 0 {
+		final.Reason = strings.Join(reasons, "; ")
+		switch cms.confidence {
+		case ConfidenceMean:
+			final.Confidence = weightedConfidence / weightSum
+		default:
+			final.Confidence = 0
+			for _, step := range decided {
+				final.Confidence = math.Max(final.Confidence, step.Confidence)
+			}
+		}
+	}
+	return final
+}
+
+// wins indica si la acción de un paso reemplaza a la acción final actual
+func (cms *CompositeModerationStrategy) wins(action, current string) bool {
+	if cms.merge == MergeFirst {
+		return current == "allow"
+	}
+	return actionSeverity[action] > actionSeverity[current]
+}
+
+// stepConfig devuelve la configuración del paso i, con corte en block por defecto
+func (cms *CompositeModerationStrategy) stepConfig(i int) CompositeStep {
+	step := CompositeStep{}
+	if i < len(cms.steps) {
+		step = cms.steps[i]
+	}
+	if step.StopOn == nil {
+		step.StopOn = []string{"block"}
+	}
+	return step
 }
 
 func (cms *CompositeModerationStrategy) GetName() string {
@@ -282,47 +601,76 @@ type ModerationObserver struct {
 	blockedCount int64
 	modifiedCount int64
 	warningCount int64
+	languageCounts map[string]int64 // idioma detectado -> mensajes
+	languageMutex  sync.Mutex
 }
 
 func NewModerationObserver(strategy ModerationStrategy) *ModerationObserver {
 	return &ModerationObserver{
 		id:        "moderation_observer",
 		Moderator: NewModerationContext(strategy),
+		languageCounts: make(map[string]int64),
 	}
 }
 
+// Update registra el resultado de moderación de los mensajes publicados. El
+// mensaje ya se moderó en el pipeline, así que aquí no se vuelve a moderar.
 func (mo *ModerationObserver) Update(event Event) {
-	if event.Type == MessageEvent {
-		// Obtener el mensaje del evento
-		message := event.Message
-		if message == "" {
-			return
-		}
-		
-		// Moderar el mensaje
-		result := mo.Moderator.ModerateMessage(message)
-		
-		// Actualizar contadores
-		switch result.Action {
-		case "block":
-			mo.blockedCount++
-		case "modify":
-			mo.modifiedCount++
-		case "warn":
-			mo.warningCount++
+	if event.Type != MessageEvent {
+		return
+	}
+	result, ok := moderationResultFromEvent(event)
+	if !ok {
+		return
+	}
+	
+	// Log del resultado de moderación
+	attrs := append(eventAttrs(event), moderationAttrs(result)...)
+	if result.Action == "modify" && result.ModifiedMessage != result.OriginalMessage {
+		attrs = append(attrs, "original", result.OriginalMessage, "modified", result.ModifiedMessage)
+	}
+	componentLog("moderation").Info("message moderated", attrs...)
+}
+
+// Record cuenta el resultado de moderar un mensaje recibido. Lo llama el
+// pipeline, que modera cada mensaje una sola vez.
+func (mo *ModerationObserver) Record(result ModerationResult) {
+	switch result.Action {
+	case "block":
+		atomic.AddInt64(&mo.blockedCount, 1)
+	case "modify":
+		atomic.AddInt64(&mo.modifiedCount, 1)
+	case "warn":
+		atomic.AddInt64(&mo.warningCount, 1)
+	}
+	
+	language := result.Language
+	if language == "" {
+		language = DetectLanguage(result.OriginalMessage)
+	}
+	mo.languageMutex.Lock()
+	mo.languageCounts[language]++
+	mo.languageMutex.Unlock()
+}
+
+// moderationResultFromEvent obtiene el resultado de moderación de un evento
+// de mensaje; si llegó de otra instancia, viene como JSON decodificado
+func moderationResultFromEvent(event Event) (ModerationResult, bool) {
+	switch value := event.Data["moderation_result"].(type) {
+	case ModerationResult:
+		return value, true
+	case map[string]interface{}:
+		data, err := json.Marshal(value)
+		if err != nil {
+			return ModerationResult{}, false
 		}
-		
-		// Log del resultado de moderación
-		fmt.Printf("[MODERATION] %s: %s (Confidence: %.2f)\n", 
-			result.Action, result.Reason, result.Confidence)
-		
-		// Si el mensaje fue modificado, actualizar el evento
-		if result.Action == "modify" && result.ModifiedMessage != result.OriginalMessage {
-			// Aquí podrías actualizar el evento con el mensaje modificado
-			fmt.Printf("[MODERATION] Modified: '%s' -> '%s'\n", 
-				result.OriginalMessage, result.ModifiedMessage)
+		var result ModerationResult
+		if err := json.Unmarshal(data, &result); err != nil {
+			return ModerationResult{}, false
 		}
+		return result, true
 	}
+	return ModerationResult{}, false
 }
 
 func (mo *ModerationObserver) GetID() string {
@@ -334,10 +682,18 @@ func (mo *ModerationObserver) SetStrategy(strategy ModerationStrategy) {
 }
 
 func (mo *ModerationObserver) GetStats() map[string]interface{} {
+	mo.languageMutex.Lock()
+	languages := make(map[string]int64, len(mo.languageCounts))
+	for language, count := range mo.languageCounts {
+		languages[language] = count
+	}
+	mo.languageMutex.Unlock()
+
 	return map[string]interface{}{
-		"blocked_messages":  mo.blockedCount,
-		"modified_messages": mo.modifiedCount,
-		"warning_messages":  mo.warningCount,
+		"blocked_messages":  atomic.LoadInt64(&mo.blockedCount),
+		"modified_messages": atomic.LoadInt64(&mo.modifiedCount),
+		"warning_messages":  atomic.LoadInt64(&mo.warningCount),
+		"languages":         languages,
 		"strategy":          mo.Moderator.strategy.GetName(),
 	}
 }
@@ -0,0 +1,101 @@
+package main
+
+// EventFilter elige qué eventos recibe un observador. Los campos vacíos no
+// filtran; los que tienen valor se deben cumplir todos.
+//
+//	// Solo mensajes y eventos de sistema de la sala "soporte"
+//	publisher.Subscribe(observer, EventFilter{
+//	    Types: []EventType{MessageEvent, SystemEvent},
+//	    Room:  "soporte",
+//	})
+//
+// Si se pasan varios filtros a Subscribe, el evento se entrega cuando cumple
+// alguno de ellos.
+type EventFilter struct {
+	Types    []EventType                       // tipos de evento; vacío = todos
+	Room     string                            // sala fija
+	RoomOf   func() string                     // sala que puede cambiar, por ejemplo la de una conexión
+	Username string                            // autor del evento
+	Data     map[string]func(interface{}) bool // clave de Data -> condición sobre su valor
+}
+
+// Matches indica si el evento cumple el filtro. Los eventos sin sala son para
+// todas las salas, así que pasan cualquier filtro de sala.
+func (f EventFilter) Matches(event Event) bool {
+	if len(f.Types) > 0 && !containsEventType(f.Types, event.Type) {
+		return false
+	}
+	if event.Room != "" {
+		if f.Room != "" && event.Room != f.Room {
+			return false
+		}
+		if f.RoomOf != nil && event.Room != f.RoomOf() {
+			return false
+		}
+	}
+	if f.Username != "" && event.Username != f.Username {
+		return false
+	}
+	for key, condition := range f.Data {
+		value, exists := event.Data[key]
+		if !exists || !condition(value) {
+			return false
+		}
+	}
+	return true
+}
+
+// DataEquals es una condición de Data que compara con un valor fijo
+func DataEquals(expected interface{}) func(interface{}) bool {
+	return func(value interface{}) bool {
+		return value == expected
+	}
+}
+
+func containsEventType(types []EventType, eventType EventType) bool {
+	for _, candidate := range types {
+		if candidate == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+// subscription es un observador con sus filtros
+type subscription struct {
+	observer Observer
+	filters  []EventFilter
+}
+
+// matches indica si el evento cumple alguno de los filtros (o si no hay filtros)
+func (sub *subscription) matches(event Event) bool {
+	if len(sub.filters) == 0 {
+		return true
+	}
+	for _, filter := range sub.filters {
+		if filter.Matches(event) {
+			return true
+		}
+	}
+	return false
+}
+
+// eventTypes devuelve los tipos a los que se limita la suscripción, o nil si
+// puede recibir cualquier tipo
+func (sub *subscription) eventTypes() []EventType {
+	types := []EventType{}
+	for _, filter := range sub.filters {
+		if len(filter.Types) == 0 {
+			return nil
+		}
+		for _, eventType := range filter.Types {
+			if !containsEventType(types, eventType) {
+				types = append(types, eventType)
+			}
+		}
+	}
+	if len(types) == 0 {
+		return nil
+	}
+	return types
+}
@@ -0,0 +1,584 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/api"
+	"github.com/tetratelabs/wazero/imports/wasi_snapshot_preview1"
+)
+
+// ABI de los plugins de moderación
+//
+// Un plugin es un módulo WebAssembly que exporta:
+//
+//	memory                       la memoria lineal del módulo
+//	alloc(size: i32) -> i32      reserva size bytes y devuelve el puntero
+//	moderate(ptr: i32, len: i32) -> i64
+//
+// El servidor escribe en la memoria (usando alloc) un JSON con el mensaje y su
+// contexto:
+//
+//	{"message": "...", "context": {"username": "...", "room": "...", "language": "es", ...}}
+//
+// y llama a moderate con su puntero y largo. moderate devuelve el puntero al
+// JSON de respuesta en los 32 bits altos y su largo en los 32 bits bajos:
+//
+//	{"action": "modify", "modified_message": "...", "reason": "...",
+//	 "confidence": 0.8, "matched_terms": ["..."]}
+//
+// Opcionalmente puede exportar name() -> i64 con el mismo formato, devolviendo
+// el nombre de la estrategia como texto. Se permiten las importaciones de WASI
+// (sin acceso a archivos, red ni variables de entorno) y se llama a
+// _initialize si existe. Cada llamada usa una instancia nueva del módulo, así
+// que los plugins no pueden guardar estado entre mensajes.
+
+// PluginConfig describe cómo cargar un plugin
+type PluginConfig struct {
+	Name             string   `json:"name"`
+	File             string   `json:"file"`                         // ruta dentro de PLUGIN_DIR
+	Timeout          Duration `json:"timeout,omitempty"`            // tiempo máximo por mensaje
+	MemoryLimitPages uint32   `json:"memory_limit_pages,omitempty"` // páginas de 64 KiB
+	MaxFailures      int      `json:"max_failures,omitempty"`       // fallas seguidas antes de desactivarlo
+	Activate         bool     `json:"activate,omitempty"`           // activarlo al cargarlo
+}
+
+func (pc *PluginConfig) applyDefaults() {
+	if pc.Timeout <= 0 {
+		pc.Timeout = Duration(100 * time.Millisecond)
+	}
+	if pc.MemoryLimitPages == 0 {
+		pc.MemoryLimitPages = 256 // 16 MiB
+	}
+	if pc.MaxFailures <= 0 {
+		pc.MaxFailures = 5
+	}
+}
+
+// pluginDir es el directorio desde el que se pueden cargar plugins
+func pluginDir() string {
+	if dir := os.Getenv("PLUGIN_DIR"); dir != "" {
+		return dir
+	}
+	return "plugins"
+}
+
+// strategyResponse es el resultado en JSON que devuelven los plugins y los
+// servicios de moderación externos
+type strategyResponse struct {
+	Action          string   `json:"action"`
+	ModifiedMessage *string  `json:"modified_message"`
+	Reason          string   `json:"reason"`
+	Confidence      float64  `json:"confidence"`
+	MatchedTerms    []string `json:"matched_terms"`
+}
+
+// validate verifica que la acción sea una de las conocidas
+func (sr strategyResponse) validate() error {
+	if _, valid := actionSeverity[sr.Action]; !valid {
+		return fmt.Errorf("acción inválida: %q", sr.Action)
+	}
+	return nil
+}
+
+// toResult convierte la respuesta en un ModerationResult
+func (sr strategyResponse) toResult(message, strategyName string, ctx MessageContext) ModerationResult {
+	result := ModerationResult{
+		OriginalMessage: message,
+		ModifiedMessage: message,
+		Action:          sr.Action,
+		Reason:          sr.Reason,
+		Confidence:      sr.Confidence,
+		Timestamp:       time.Now(),
+		StrategyUsed:    strategyName,
+		MatchedTerms:    sr.MatchedTerms,
+		Language:        ctx.Language,
+	}
+	if sr.ModifiedMessage != nil {
+		result.ModifiedMessage = *sr.ModifiedMessage
+	}
+	if result.Action == "block" {
+		result.ModifiedMessage = ""
+	}
+	return result
+}
+
+// WasmPlugin es un módulo compilado listo para instanciar
+type WasmPlugin struct {
+	config   PluginConfig
+	name     string
+	runtime  wazero.Runtime
+	compiled wazero.CompiledModule
+	loadedAt time.Time
+
+	calls     int64
+	failures  int64
+	streak    int64 // fallas seguidas
+	lastError string
+	totalTime time.Duration
+	disabled  bool
+	inflight  int  // llamadas en curso; el runtime se cierra cuando terminan
+	closed    bool // descargado o reemplazado por otra versión
+	mutex     sync.Mutex
+}
+
+// LoadWasmPlugin compila el módulo y verifica que cumpla el ABI
+func LoadWasmPlugin(config PluginConfig) (*WasmPlugin, error) {
+	config.applyDefaults()
+	if config.Name == "" || config.File == "" {
+		return nil, errors.New("el plugin necesita name y file")
+	}
+
+	// Solo se cargan archivos dentro del directorio de plugins
+	path := filepath.Join(pluginDir(), filepath.Clean("/"+config.File))
+	code, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx := context.Background()
+	runtime := wazero.NewRuntimeWithConfig(ctx, wazero.NewRuntimeConfig().
+		WithMemoryLimitPages(config.MemoryLimitPages).
+		WithCloseOnContextDone(true))
+	if _, err := wasi_snapshot_preview1.Instantiate(ctx, runtime); err != nil {
+		runtime.Close(ctx)
+		return nil, err
+	}
+	compiled, err := runtime.CompileModule(ctx, code)
+	if err != nil {
+		runtime.Close(ctx)
+		return nil, fmt.Errorf("módulo inválido: %v", err)
+	}
+
+	exports := compiled.ExportedFunctions()
+	for _, required := range []string{"alloc", "moderate"} {
+		if _, exists := exports[required]; !exists {
+			runtime.Close(ctx)
+			return nil, fmt.Errorf("el módulo no exporta %s", required)
+		}
+	}
+
+	plugin := &WasmPlugin{
+		config:   config,
+		name:     "Wasm:" + config.Name,
+		runtime:  runtime,
+		compiled: compiled,
+		loadedAt: time.Now(),
+	}
+	if _, exists := exports["name"]; exists {
+		if name, err := plugin.callName(); err == nil && name != "" {
+			plugin.name = name
+		}
+	}
+	return plugin, nil
+}
+
+// instantiate crea una instancia nueva del módulo con el timeout del plugin
+func (wp *WasmPlugin) instantiate() (api.Module, context.Context, context.CancelFunc, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(wp.config.Timeout))
+	module, err := wp.runtime.InstantiateModule(ctx, wp.compiled, wazero.NewModuleConfig().
+		WithName("").
+		WithStartFunctions("_initialize"))
+	if err != nil {
+		cancel()
+		return nil, nil, nil, err
+	}
+	return module, ctx, cancel, nil
+}
+
+// readPacked lee el texto apuntado por un resultado ptr<<32 | len
+func readPacked(module api.Module, packed uint64) ([]byte, error) {
+	ptr, size := uint32(packed>>32), uint32(packed)
+	data, ok := module.Memory().Read(ptr, size)
+	if !ok {
+		return nil, fmt.Errorf("resultado fuera de la memoria (ptr=%d, len=%d)", ptr, size)
+	}
+	return append([]byte{}, data...), nil
+}
+
+func (wp *WasmPlugin) callName() (string, error) {
+	module, ctx, cancel, err := wp.instantiate()
+	if err != nil {
+		return "", err
+	}
+	defer cancel()
+	defer module.Close(ctx)
+
+	results, err := module.ExportedFunction("name").Call(ctx)
+	if err != nil {
+		return "", err
+	}
+	name, err := readPacked(module, results[0])
+	return string(name), err
+}
+
+// Call ejecuta moderate en una instancia nueva del módulo
+func (wp *WasmPlugin) Call(message string, messageCtx MessageContext) (strategyResponse, error) {
+	var response strategyResponse
+	input, err := json.Marshal(map[string]interface{}{
+		"message": message,
+		"context": messageCtx,
+	})
+	if err != nil {
+		return response, err
+	}
+
+	module, ctx, cancel, err := wp.instantiate()
+	if err != nil {
+		return response, err
+	}
+	defer cancel()
+	defer module.Close(ctx)
+
+	results, err := module.ExportedFunction("alloc").Call(ctx, uint64(len(input)))
+	if err != nil {
+		return response, err
+	}
+	ptr := uint32(results[0])
+	if !module.Memory().Write(ptr, input) {
+		return response, errors.New("alloc devolvió un puntero fuera de la memoria")
+	}
+
+	results, err = module.ExportedFunction("moderate").Call(ctx, uint64(ptr), uint64(len(input)))
+	if err != nil {
+		if ctx.Err() != nil {
+			return response, fmt.Errorf("timeout de %s", time.Duration(wp.config.Timeout))
+		}
+		return response, err
+	}
+	output, err := readPacked(module, results[0])
+	if err != nil {
+		return response, err
+	}
+	if err := json.Unmarshal(output, &response); err != nil {
+		return response, fmt.Errorf("respuesta inválida: %v", err)
+	}
+	return response, response.validate()
+}
+
+// record actualiza las métricas del plugin y dice si acaba de superar el máximo de fallas
+func (wp *WasmPlugin) record(elapsed time.Duration, err error) bool {
+	wp.mutex.Lock()
+	defer wp.mutex.Unlock()
+	wp.calls++
+	wp.totalTime += elapsed
+	if err == nil {
+		wp.streak = 0
+		return false
+	}
+	wp.failures++
+	wp.streak++
+	wp.lastError = err.Error()
+	if !wp.disabled && wp.streak >= int64(wp.config.MaxFailures) {
+		wp.disabled = true
+		return true
+	}
+	return false
+}
+
+// Disabled indica si el plugin se desactivó por fallas seguidas
+func (wp *WasmPlugin) Disabled() bool {
+	wp.mutex.Lock()
+	defer wp.mutex.Unlock()
+	return wp.disabled
+}
+
+// GetStats retorna las métricas del plugin
+func (wp *WasmPlugin) GetStats() map[string]interface{} {
+	wp.mutex.Lock()
+	defer wp.mutex.Unlock()
+	average := time.Duration(0)
+	if wp.calls > 0 {
+		average = wp.totalTime / time.Duration(wp.calls)
+	}
+	return map[string]interface{}{
+		"name":               wp.config.Name,
+		"strategy":           wp.name,
+		"file":               wp.config.File,
+		"loaded_at":          wp.loadedAt,
+		"timeout":            wp.config.Timeout,
+		"memory_limit_pages": wp.config.MemoryLimitPages,
+		"calls":              wp.calls,
+		"failures":           wp.failures,
+		"disabled":           wp.disabled,
+		"last_error":         wp.lastError,
+		"average_latency":    average.String(),
+	}
+}
+
+// acquire reserva el runtime para una llamada. Devuelve false si el plugin
+// ya se descargó o se reemplazó.
+func (wp *WasmPlugin) acquire() bool {
+	wp.mutex.Lock()
+	defer wp.mutex.Unlock()
+	if wp.closed {
+		return false
+	}
+	wp.inflight++
+	return true
+}
+
+// release termina una llamada y cierra el runtime si era la última de un plugin descargado
+func (wp *WasmPlugin) release() {
+	wp.mutex.Lock()
+	wp.inflight--
+	closeNow := wp.closed && wp.inflight == 0
+	wp.mutex.Unlock()
+	if closeNow {
+		wp.runtime.Close(context.Background())
+	}
+}
+
+// Close libera el runtime del plugin. Las llamadas en curso terminan antes;
+// las siguientes usan la estrategia de respaldo.
+func (wp *WasmPlugin) Close() {
+	wp.mutex.Lock()
+	if wp.closed {
+		wp.mutex.Unlock()
+		return
+	}
+	wp.closed = true
+	closeNow := wp.inflight == 0
+	wp.mutex.Unlock()
+	if closeNow {
+		wp.runtime.Close(context.Background())
+	}
+}
+
+// WasmStrategy adapta un plugin a ModerationStrategy. Si el plugin falla, el
+// mensaje se modera con la estrategia de respaldo (la que estaba activa al cargarlo).
+type WasmStrategy struct {
+	plugin   *WasmPlugin
+	fallback ModerationStrategy
+	onFail   func(*WasmPlugin) // se llama cuando el plugin supera el máximo de fallas
+}
+
+func NewWasmStrategy(plugin *WasmPlugin, fallback ModerationStrategy, onFail func(*WasmPlugin)) *WasmStrategy {
+	return &WasmStrategy{
+		plugin:   plugin,
+		fallback: fallback,
+		onFail:   onFail,
+	}
+}
+
+func (ws *WasmStrategy) Moderate(message string) ModerationResult {
+	return ws.ModerateWithContext(message, MessageContext{})
+}
+
+func (ws *WasmStrategy) ModerateWithContext(message string, ctx MessageContext) ModerationResult {
+	if ws.plugin.Disabled() {
+		return ws.fallbackResult(message, ctx, errors.New("plugin desactivado"))
+	}
+	if !ws.plugin.acquire() {
+		return ws.fallbackResult(message, ctx, errors.New("plugin descargado"))
+	}
+
+	start := time.Now()
+	response, err := ws.plugin.Call(message, ctx)
+	ws.plugin.release()
+	if ws.plugin.record(time.Since(start), err) && ws.onFail != nil {
+		// En otra goroutine: el servidor modera con su lock tomado
+		go ws.onFail(ws.plugin)
+	}
+	if err != nil {
+		componentLog("plugins").Warn("plugin call failed", "plugin", ws.plugin.config.Name, "error", err, "message_id", ctx.MessageID)
+		return ws.fallbackResult(message, ctx, err)
+	}
+
+	return response.toResult(message, ws.GetName(), ctx)
+}
+
+// fallbackResult modera con la estrategia de respaldo, o deja pasar el mensaje si no hay
+func (ws *WasmStrategy) fallbackResult(message string, ctx MessageContext, cause error) ModerationResult {
+	if ws.fallback == nil {
+		return ModerationResult{
+			OriginalMessage: message,
+			ModifiedMessage: message,
+			Action:          "allow",
+			Reason:          "Plugin failed and no fallback is configured: " + cause.Error(),
+			Confidence:      0.0,
+			Timestamp:       time.Now(),
+			StrategyUsed:    ws.GetName(),
+		}
+	}
+	result := moderateWithContext(ws.fallback, message, ctx)
+	result.Reason = fmt.Sprintf("%s (plugin %s failed: %v)", result.Reason, ws.plugin.config.Name, cause)
+	return result
+}
+
+func (ws *WasmStrategy) GetName() string {
+	return ws.plugin.name
+}
+
+// PluginManager carga y descarga plugins en caliente
+type PluginManager struct {
+	plugins   map[string]*WasmPlugin
+	onReplace func(previous, plugin *WasmPlugin) // plugin es nil al descargar
+	mutex     sync.Mutex
+}
+
+// NewPluginManager crea el administrador. onReplace se llama al recargar o
+// descargar un plugin, antes de cerrar la versión anterior, para que quien la
+// esté usando pase a la nueva (o a otra estrategia).
+func NewPluginManager(onReplace func(previous, plugin *WasmPlugin)) *PluginManager {
+	return &PluginManager{plugins: make(map[string]*WasmPlugin), onReplace: onReplace}
+}
+
+// pluginStrategyName es el nombre con el que el plugin queda en el registro
+func pluginStrategyName(name string) string {
+	return "wasm:" + strings.ToLower(name)
+}
+
+// Load compila un plugin, lo registra como "wasm:<nombre>" y reemplaza al
+// anterior con el mismo nombre
+func (pm *PluginManager) Load(config PluginConfig, fallback func() ModerationStrategy, onFail func(*WasmPlugin)) (*WasmPlugin, error) {
+	plugin, err := LoadWasmPlugin(config)
+	if err != nil {
+		return nil, err
+	}
+
+	pm.mutex.Lock()
+	previous := pm.plugins[strings.ToLower(config.Name)]
+	pm.plugins[strings.ToLower(config.Name)] = plugin
+	pm.mutex.Unlock()
+
+	strategies.Register(pluginStrategyName(config.Name), func() ModerationStrategy {
+		return NewWasmStrategy(plugin, fallback(), onFail)
+	})
+	if previous != nil {
+		if pm.onReplace != nil {
+			pm.onReplace(previous, plugin)
+		}
+		previous.Close()
+	}
+	componentLog("plugins").Info("plugin loaded", "plugin", config.Name, "module", plugin.name)
+	return plugin, nil
+}
+
+// Unload quita un plugin del registro y libera su runtime
+func (pm *PluginManager) Unload(name string) bool {
+	pm.mutex.Lock()
+	plugin, exists := pm.plugins[strings.ToLower(name)]
+	delete(pm.plugins, strings.ToLower(name))
+	pm.mutex.Unlock()
+	if !exists {
+		return false
+	}
+	strategies.Unregister(pluginStrategyName(name))
+	if pm.onReplace != nil {
+		pm.onReplace(plugin, nil)
+	}
+	plugin.Close()
+	componentLog("plugins").Info("plugin unloaded", "plugin", name)
+	return true
+}
+
+// List devuelve las métricas de los plugins cargados
+func (pm *PluginManager) List() []map[string]interface{} {
+	pm.mutex.Lock()
+	defer pm.mutex.Unlock()
+	names := make([]string, 0, len(pm.plugins))
+	for name := range pm.plugins {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	list := make([]map[string]interface{}, 0, len(names))
+	for _, name := range names {
+		list = append(list, pm.plugins[name].GetStats())
+	}
+	return list
+}
+
+// activeStrategy devuelve la estrategia activa del servidor
+func (s *Server) activeStrategy() ModerationStrategy {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return s.moderationObserver.Moderator.strategy
+}
+
+// pluginFallback es la estrategia de respaldo de un plugin: la activa en ese
+// momento, salvo que sea otro plugin
+func (s *Server) pluginFallback() ModerationStrategy {
+	active := s.activeStrategy()
+	if wasm, isPlugin := active.(*WasmStrategy); isPlugin {
+		return wasm.fallback
+	}
+	return active
+}
+
+// pluginFailed vuelve a la estrategia de respaldo si el plugin activo se desactivó
+func (s *Server) pluginFailed(plugin *WasmPlugin) {
+	componentLog("plugins").Error("plugin disabled after consecutive failures", "plugin", plugin.config.Name, "failures", plugin.config.MaxFailures)
+	if wasm, isPlugin := s.activeStrategy().(*WasmStrategy); isPlugin && wasm.plugin == plugin && wasm.fallback != nil {
+		// La falla es de este nodo, así que el cambio no se propaga al clúster
+		s.setModerationStrategy(wasm.fallback, "plugins")
+	}
+}
+
+// pluginReplaced pasa la estrategia activa a la nueva versión de un plugin que
+// se recargó o, si se descargó, a su estrategia de respaldo
+func (s *Server) pluginReplaced(previous, plugin *WasmPlugin) {
+	wasm, isPlugin := s.activeStrategy().(*WasmStrategy)
+	if !isPlugin || wasm.plugin != previous {
+		return
+	}
+	if plugin != nil {
+		s.setModerationStrategy(NewWasmStrategy(plugin, wasm.fallback, wasm.onFail), "plugins")
+		return
+	}
+	fallback := wasm.fallback
+	if fallback == nil {
+		fallback = NewBadWordReplacementStrategy()
+	}
+	s.setModerationStrategy(fallback, "plugins")
+}
+
+// handlePlugins administra los plugins WASM:
+// GET los lista, POST carga (o recarga) uno y DELETE ?name=... lo descarga
+func (s *Server) handlePlugins(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		writeJSON(w, s.plugins.List())
+	case "POST":
+		var config PluginConfig
+		if err := json.NewDecoder(r.Body).Decode(&config); err != nil {
+			http.Error(w, "JSON inválido: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		fallback := s.pluginFallback()
+		plugin, err := s.plugins.Load(config, func() ModerationStrategy { return fallback }, s.pluginFailed)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if config.Activate {
+			strategy, err := strategies.Create(pluginStrategyName(config.Name))
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			s.SetModerationStrategy(strategy)
+		}
+		w.WriteHeader(http.StatusCreated)
+		writeJSON(w, plugin.GetStats())
+	case "DELETE":
+		// Si el plugin está activo, pluginReplaced vuelve a su estrategia de respaldo
+		if !s.plugins.Unload(r.URL.Query().Get("name")) {
+			http.Error(w, "Plugin no encontrado", http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "Método no permitido", http.StatusMethodNotAllowed)
+	}
+}
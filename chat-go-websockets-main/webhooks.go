@@ -0,0 +1,810 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Cabeceras de cada entrega. La firma es HMAC-SHA256 de "<timestamp>.<cuerpo>"
+// con el secreto del webhook, en hexadecimal y con el prefijo "sha256=".
+const (
+	webhookIDHeader        = "X-Webhook-Id"
+	webhookDeliveryHeader  = "X-Webhook-Delivery"
+	webhookEventHeader     = "X-Webhook-Event"
+	webhookTimestampHeader = "X-Webhook-Timestamp"
+	webhookSignatureHeader = "X-Webhook-Signature"
+)
+
+// WebhookConfig configura las entregas de los webhooks salientes
+type WebhookConfig struct {
+	Timeout         Duration `json:"timeout"`           // tiempo máximo de cada intento
+	MaxAttempts     int      `json:"max_attempts"`      // intentos antes de pasar a dead letters
+	InitialBackoff  Duration `json:"initial_backoff"`   // espera antes del primer reintento; se duplica en cada uno
+	MaxBackoff      Duration `json:"max_backoff"`       // espera máxima entre reintentos
+	QueueSize       int      `json:"queue_size"`        // eventos pendientes por webhook si el log de eventos está desactivado
+	DeadLetterLimit int      `json:"dead_letter_limit"` // entregas fallidas que se guardan; se descartan las más viejas
+
+	IncomingRateLimit RateLimitConfig `json:"incoming_rate_limit"` // límite de los webhooks entrantes que no indican uno
+}
+
+func DefaultWebhookConfig() WebhookConfig {
+	return WebhookConfig{
+		Timeout:         Duration(5 * time.Second),
+		MaxAttempts:     5,
+		InitialBackoff:  Duration(time.Second),
+		MaxBackoff:      Duration(time.Minute),
+		QueueSize:       256,
+		DeadLetterLimit: 1000,
+		IncomingRateLimit: RateLimitConfig{
+			Messages: 30,
+			Window:   Duration(time.Minute),
+		},
+	}
+}
+
+// LoadWebhookConfig carga WEBHOOK_CONFIG_FILE o usa la configuración por defecto
+func LoadWebhookConfig() WebhookConfig {
+	config := DefaultWebhookConfig()
+
+	path := os.Getenv("WEBHOOK_CONFIG_FILE")
+	if path == "" {
+		return config
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		slog.Warn("could not read webhook config", "path", path, "error", err)
+		return config
+	}
+	if err := json.Unmarshal(data, &config); err != nil {
+		slog.Warn("invalid webhook config", "path", path, "error", err)
+		return DefaultWebhookConfig()
+	}
+	if config.MaxAttempts < 1 {
+		config.MaxAttempts = 1
+	}
+	return config
+}
+
+// Webhook es un destino al que se envían los eventos elegidos
+//
+//	{
+//	    "url": "https://incidentes.example.com/chat",
+//	    "types": ["message", "message_removed"],
+//	    "rooms": ["soporte", "guardia"]
+//	}
+//
+// Sin tipos recibe todos los eventos; sin salas, los de todas las salas. Los
+// eventos sin sala (avisos de sistema para todos) pasan cualquier filtro de sala.
+type Webhook struct {
+	ID        string      `json:"id"`
+	URL       string      `json:"url"`
+	Secret    string      `json:"secret,omitempty"` // si no se indica se genera uno
+	Types     []EventType `json:"types,omitempty"`
+	Rooms     []string    `json:"rooms,omitempty"`
+	Disabled  bool        `json:"disabled"`
+	CreatedBy string      `json:"created_by,omitempty"`
+	CreatedAt time.Time   `json:"created_at"`
+}
+
+// filters arma los filtros de suscripción: uno por sala, o uno solo sin sala
+func (wh Webhook) filters() []EventFilter {
+	if len(wh.Rooms) == 0 {
+		return []EventFilter{{Types: wh.Types}}
+	}
+	filters := make([]EventFilter, 0, len(wh.Rooms))
+	for _, room := range wh.Rooms {
+		filters = append(filters, EventFilter{Types: wh.Types, Room: room})
+	}
+	return filters
+}
+
+// public devuelve el webhook sin el secreto, para listarlo
+func (wh Webhook) public() Webhook {
+	wh.Secret = ""
+	return wh
+}
+
+// WebhookPayload es el cuerpo que recibe el destino
+type WebhookPayload struct {
+	Webhook  string `json:"webhook"`
+	Delivery string `json:"delivery"` // igual en todos los reintentos, para descartar duplicados
+	Event    Event  `json:"event"`
+}
+
+// WebhookDeadLetter es una entrega que agotó sus intentos
+type WebhookDeadLetter struct {
+	Delivery   string    `json:"delivery"`
+	Webhook    string    `json:"webhook"`
+	Event      Event     `json:"event"`
+	Attempts   int       `json:"attempts"`
+	LastStatus int       `json:"last_status,omitempty"`
+	LastError  string    `json:"last_error"`
+	FailedAt   time.Time `json:"failed_at"`
+}
+
+// webhookStats son las métricas de entrega de un webhook. Se conservan
+// aunque el webhook se desactive y se vuelva a activar.
+type webhookStats struct {
+	delivered    int64
+	failed       int64 // intentos fallidos
+	retries      int64
+	deadLettered int64
+	latencyTotal int64 // nanosegundos, de los intentos con respuesta
+	latencyCount int64
+
+	mutex       sync.Mutex
+	lastStatus  int
+	lastError   string
+	lastAttempt time.Time
+	lastSuccess time.Time
+}
+
+func (ws *webhookStats) attempt(status int, err error, latency time.Duration) {
+	if status > 0 {
+		atomic.AddInt64(&ws.latencyTotal, int64(latency))
+		atomic.AddInt64(&ws.latencyCount, 1)
+	}
+	if err != nil {
+		atomic.AddInt64(&ws.failed, 1)
+	} else {
+		atomic.AddInt64(&ws.delivered, 1)
+	}
+
+	ws.mutex.Lock()
+	defer ws.mutex.Unlock()
+	ws.lastStatus = status
+	ws.lastAttempt = time.Now()
+	if err != nil {
+		ws.lastError = err.Error()
+	} else {
+		ws.lastError = ""
+		ws.lastSuccess = ws.lastAttempt
+	}
+}
+
+func (ws *webhookStats) snapshot() map[string]interface{} {
+	avgLatency := 0.0
+	if count := atomic.LoadInt64(&ws.latencyCount); count > 0 {
+		avgLatency = float64(atomic.LoadInt64(&ws.latencyTotal)) / float64(count) / float64(time.Millisecond)
+	}
+	stats := map[string]interface{}{
+		"delivered":      atomic.LoadInt64(&ws.delivered),
+		"failed":         atomic.LoadInt64(&ws.failed),
+		"retries":        atomic.LoadInt64(&ws.retries),
+		"dead_lettered":  atomic.LoadInt64(&ws.deadLettered),
+		"avg_latency_ms": avgLatency,
+	}
+
+	ws.mutex.Lock()
+	defer ws.mutex.Unlock()
+	if !ws.lastAttempt.IsZero() {
+		stats["last_status"] = ws.lastStatus
+		stats["last_attempt"] = ws.lastAttempt
+	}
+	if ws.lastError != "" {
+		stats["last_error"] = ws.lastError
+	}
+	if !ws.lastSuccess.IsZero() {
+		stats["last_success"] = ws.lastSuccess
+	}
+	return stats
+}
+
+// webhookEndpoint es el observador de un webhook activo. Con el log de
+// eventos activo es un suscriptor durable: entrega en orden, de a un evento,
+// y después de reiniciar sigue desde el último evento entregado. Sin log, los
+// eventos esperan en una cola en memoria.
+type webhookEndpoint struct {
+	hook    Webhook
+	manager *WebhookManager
+	stats   *webhookStats
+	queue   chan Event // nil si es un suscriptor durable
+	stop    chan bool
+}
+
+func (we *webhookEndpoint) GetID() string {
+	return webhookSubscriberID(we.hook.ID)
+}
+
+func webhookSubscriberID(id string) string {
+	return "webhook:" + id
+}
+
+func (we *webhookEndpoint) Update(event Event) {
+	// Cada instancia entrega solo sus eventos, para no enviar duplicados
+	if we.manager.publisher.isRemote(event) {
+		return
+	}
+	if we.queue == nil {
+		we.deliver(event, deliveryID(we.hook.ID, event))
+		return
+	}
+	select {
+	case we.queue <- event:
+	default:
+		we.manager.deadLetter(WebhookDeadLetter{
+			Delivery:  deliveryID(we.hook.ID, event),
+			Webhook:   we.hook.ID,
+			Event:     event,
+			LastError: "cola llena",
+			FailedAt:  time.Now(),
+		}, we.stats)
+	}
+}
+
+func (we *webhookEndpoint) run() {
+	for {
+		select {
+		case event := <-we.queue:
+			we.deliver(event, deliveryID(we.hook.ID, event))
+		case <-we.stop:
+			return
+		}
+	}
+}
+
+// deliver envía el evento con reintentos y, si se agotan, lo guarda como dead letter
+func (we *webhookEndpoint) deliver(event Event, delivery string) {
+	config := we.manager.config
+	body, err := json.Marshal(WebhookPayload{Webhook: we.hook.ID, Delivery: delivery, Event: event})
+	if err != nil {
+		componentLog("webhooks").Error("could not marshal event", append(eventAttrs(event), "webhook", we.hook.ID, "error", err)...)
+		return
+	}
+
+	backoff := time.Duration(config.InitialBackoff)
+	for attempt := 1; ; attempt++ {
+		start := time.Now()
+		status, retryAfter, err := we.manager.send(we.hook, delivery, event.Type, body)
+		we.stats.attempt(status, err, time.Since(start))
+		if err == nil {
+			return
+		}
+		if attempt >= config.MaxAttempts || !retryableStatus(status) {
+			componentLog("webhooks").Warn("delivery dead-lettered", append(eventAttrs(event), "webhook", we.hook.ID, "delivery", delivery, "attempts", attempt, "status", status, "error", err)...)
+			we.manager.deadLetter(WebhookDeadLetter{
+				Delivery:   delivery,
+				Webhook:    we.hook.ID,
+				Event:      event,
+				Attempts:   attempt,
+				LastStatus: status,
+				LastError:  err.Error(),
+				FailedAt:   time.Now(),
+			}, we.stats)
+			return
+		}
+
+		wait := backoff
+		if retryAfter > wait {
+			wait = retryAfter
+		}
+		if wait > time.Duration(config.MaxBackoff) {
+			wait = time.Duration(config.MaxBackoff)
+		}
+		atomic.AddInt64(&we.stats.retries, 1)
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-we.stop:
+			// El webhook se desactivó mientras esperaba
+			timer.Stop()
+			return
+		}
+		backoff *= 2
+	}
+}
+
+// retryableStatus indica si vale la pena reintentar: errores de red, 5xx, 408 y 429.
+// Los demás 4xx no se arreglan reintentando.
+func retryableStatus(status int) bool {
+	return status == 0 || status >= 500 || status == http.StatusRequestTimeout || status == http.StatusTooManyRequests
+}
+
+func newDeliveryID() string {
+	id := make([]byte, 8)
+	rand.Read(id)
+	return "dlv_" + hex.EncodeToString(id)
+}
+
+// deliveryID identifica la entrega de un evento a un webhook. Si el evento
+// tiene offset se deriva del webhook, la instancia y el offset: al volver a
+// entregarlo (después de reiniciar o de mover el suscriptor) lleva el mismo ID
+// y el receptor puede descartar el duplicado. Sin log de eventos es aleatorio.
+func deliveryID(hookID string, event Event) string {
+	if event.Offset == 0 {
+		return newDeliveryID()
+	}
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%s|%s|%d", hookID, event.Node, event.Offset)))
+	return "dlv_" + hex.EncodeToString(sum[:8])
+}
+
+// WebhookManager guarda los webhooks en data/webhooks.json y mantiene un
+// observador por cada webhook activo
+type WebhookManager struct {
+	config      WebhookConfig
+	path        string
+	deadPath    string
+	publisher   *EventPublisher
+	client      *http.Client
+	hooks       map[string]Webhook
+	endpoints   map[string]*webhookEndpoint
+	stats       map[string]*webhookStats
+	deadLetters []WebhookDeadLetter
+	nextID      int64
+	mutex       sync.RWMutex
+	deadMutex   sync.Mutex
+}
+
+// NewWebhookManager carga los webhooks guardados y activa los habilitados.
+// Se crea después de configurar el log de eventos, para usar suscriptores durables.
+func NewWebhookManager(config WebhookConfig, publisher *EventPublisher) *WebhookManager {
+	wm := &WebhookManager{
+		config:    config,
+		path:      dataPath("webhooks.json"),
+		deadPath:  dataPath("webhooks_dead.json"),
+		publisher: publisher,
+		client:    &http.Client{Timeout: time.Duration(config.Timeout)},
+		hooks:     make(map[string]Webhook),
+		endpoints: make(map[string]*webhookEndpoint),
+		stats:     make(map[string]*webhookStats),
+	}
+	if err := readJSONFile(wm.path, &wm.hooks); err != nil {
+		componentLog("webhooks").Error("could not load file", "path", wm.path, "error", err)
+	}
+	if err := readJSONFile(wm.deadPath, &wm.deadLetters); err != nil {
+		componentLog("webhooks").Error("could not load file", "path", wm.deadPath, "error", err)
+	}
+
+	wm.mutex.Lock()
+	defer wm.mutex.Unlock()
+	for id, hook := range wm.hooks {
+		if !hook.Disabled {
+			wm.start(hook, false)
+		}
+		if seq, err := strconv.ParseInt(strings.TrimPrefix(id, "wh_"), 10, 64); err == nil && seq > wm.nextID {
+			wm.nextID = seq
+		}
+	}
+	return wm
+}
+
+// start suscribe el observador del webhook; se llama con el lock tomado. Con
+// skipBacklog, o si el webhook nunca leyó del log, empieza por los eventos nuevos.
+func (wm *WebhookManager) start(hook Webhook, skipBacklog bool) {
+	if wm.stats[hook.ID] == nil {
+		wm.stats[hook.ID] = &webhookStats{}
+	}
+	endpoint := &webhookEndpoint{
+		hook:    hook,
+		manager: wm,
+		stats:   wm.stats[hook.ID],
+		stop:    make(chan bool),
+	}
+	wm.endpoints[hook.ID] = endpoint
+
+	if el := wm.publisher.EventLog(); el != nil {
+		id := endpoint.GetID()
+		if _, exists := el.offsets.Get(id); skipBacklog || !exists {
+			_, next := el.Bounds()
+			el.offsets.Commit(id, next-1)
+		}
+		if err := wm.publisher.SubscribeDurable(endpoint, hook.filters()...); err == nil {
+			return
+		}
+	}
+	endpoint.queue = make(chan Event, wm.config.QueueSize)
+	go endpoint.run()
+	wm.publisher.Subscribe(endpoint, hook.filters()...)
+}
+
+// halt quita el observador del webhook; se llama con el lock tomado
+func (wm *WebhookManager) halt(id string) {
+	endpoint, exists := wm.endpoints[id]
+	if !exists {
+		return
+	}
+	wm.publisher.Unsubscribe(endpoint)
+	close(endpoint.stop)
+	delete(wm.endpoints, id)
+}
+
+// Register valida y guarda un webhook nuevo, y empieza a enviarle los eventos
+// que ocurran desde ahora
+func (wm *WebhookManager) Register(hook Webhook) (Webhook, error) {
+	hook.URL = strings.TrimSpace(hook.URL)
+	parsed, err := url.Parse(hook.URL)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return hook, errors.New("la URL del webhook debe ser http o https")
+	}
+	if hook.Secret == "" {
+		secret := make([]byte, 32)
+		rand.Read(secret)
+		hook.Secret = hex.EncodeToString(secret)
+	}
+	for i, room := range hook.Rooms {
+		hook.Rooms[i] = strings.TrimSpace(room)
+	}
+
+	wm.mutex.Lock()
+	defer wm.mutex.Unlock()
+	wm.nextID++
+	hook.ID = fmt.Sprintf("wh_%d", wm.nextID)
+	hook.Disabled = false
+	hook.CreatedAt = time.Now()
+	wm.hooks[hook.ID] = hook
+	wm.start(hook, true)
+	wm.save()
+	return hook, nil
+}
+
+// SetDisabled desactiva o vuelve a activar un webhook. Al activarlo recibe
+// solo los eventos nuevos, no los que ocurrieron mientras estaba desactivado.
+func (wm *WebhookManager) SetDisabled(id string, disabled bool) (Webhook, error) {
+	wm.mutex.Lock()
+	defer wm.mutex.Unlock()
+	hook, exists := wm.hooks[id]
+	if !exists {
+		return hook, errors.New("webhook no encontrado: " + id)
+	}
+	if hook.Disabled == disabled {
+		return hook.public(), nil
+	}
+	hook.Disabled = disabled
+	wm.hooks[id] = hook
+	if disabled {
+		wm.halt(id)
+	} else {
+		wm.start(hook, true)
+	}
+	wm.save()
+	return hook.public(), nil
+}
+
+// Remove borra un webhook junto con sus dead letters
+func (wm *WebhookManager) Remove(id string) bool {
+	wm.mutex.Lock()
+	if _, exists := wm.hooks[id]; !exists {
+		wm.mutex.Unlock()
+		return false
+	}
+	wm.halt(id)
+	delete(wm.hooks, id)
+	delete(wm.stats, id)
+	wm.save()
+	wm.mutex.Unlock()
+
+	if el := wm.publisher.EventLog(); el != nil {
+		el.offsets.Remove(webhookSubscriberID(id))
+	}
+	wm.takeDeadLetters(id)
+	return true
+}
+
+// List devuelve los webhooks, sin secretos, con sus métricas de entrega
+func (wm *WebhookManager) List() []map[string]interface{} {
+	wm.mutex.RLock()
+	defer wm.mutex.RUnlock()
+	list := make([]map[string]interface{}, 0, len(wm.hooks))
+	for id, hook := range wm.hooks {
+		entry := map[string]interface{}{"webhook": hook.public()}
+		if stats := wm.stats[id]; stats != nil {
+			entry["stats"] = stats.snapshot()
+		}
+		if endpoint := wm.endpoints[id]; endpoint != nil && endpoint.queue != nil {
+			entry["queued"] = len(endpoint.queue)
+		}
+		list = append(list, entry)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i]["webhook"].(Webhook).CreatedAt.Before(list[j]["webhook"].(Webhook).CreatedAt)
+	})
+	return list
+}
+
+// Test envía un evento de prueba firmado, un solo intento y sin dead letters.
+// Funciona también con webhooks desactivados, para probarlos antes de activarlos.
+func (wm *WebhookManager) Test(id string) (map[string]interface{}, error) {
+	wm.mutex.RLock()
+	hook, exists := wm.hooks[id]
+	wm.mutex.RUnlock()
+	if !exists {
+		return nil, errors.New("webhook no encontrado: " + id)
+	}
+
+	event := Event{
+		Type:      SystemEvent,
+		Message:   "Evento de prueba del webhook",
+		Data:      map[string]interface{}{"test": true},
+		Timestamp: time.Now(),
+	}
+	delivery := newDeliveryID()
+	body, err := json.Marshal(WebhookPayload{Webhook: hook.ID, Delivery: delivery, Event: event})
+	if err != nil {
+		return nil, err
+	}
+	start := time.Now()
+	status, _, err := wm.send(hook, delivery, event.Type, body)
+	result := map[string]interface{}{
+		"delivery":   delivery,
+		"ok":         err == nil,
+		"status":     status,
+		"latency_ms": float64(time.Since(start)) / float64(time.Millisecond),
+	}
+	if err != nil {
+		result["error"] = err.Error()
+	}
+	return result, nil
+}
+
+// send hace un intento de entrega. Devuelve el código HTTP (0 si no hubo
+// respuesta) y la espera pedida con Retry-After, si la hay.
+func (wm *WebhookManager) send(hook Webhook, delivery string, eventType EventType, body []byte) (int, time.Duration, error) {
+	req, err := http.NewRequest("POST", hook.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, 0, err
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "go-chat-webhooks")
+	req.Header.Set(webhookIDHeader, hook.ID)
+	req.Header.Set(webhookDeliveryHeader, delivery)
+	req.Header.Set(webhookEventHeader, string(eventType))
+	req.Header.Set(webhookTimestampHeader, timestamp)
+	req.Header.Set(webhookSignatureHeader, "sha256="+signWebhook(hook.Secret, timestamp, body))
+
+	resp, err := wm.client.Do(req)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return resp.StatusCode, 0, nil
+	}
+	var retryAfter time.Duration
+	if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && seconds > 0 {
+		retryAfter = time.Duration(seconds) * time.Second
+	}
+	return resp.StatusCode, retryAfter, fmt.Errorf("HTTP %d", resp.StatusCode)
+}
+
+// signWebhook calcula la firma que el destino debe comprobar
+func signWebhook(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// deadLetter guarda una entrega fallida, descartando las más viejas si se supera el límite
+func (wm *WebhookManager) deadLetter(letter WebhookDeadLetter, stats *webhookStats) {
+	atomic.AddInt64(&stats.deadLettered, 1)
+
+	wm.deadMutex.Lock()
+	defer wm.deadMutex.Unlock()
+	wm.deadLetters = append(wm.deadLetters, letter)
+	if excess := len(wm.deadLetters) - wm.config.DeadLetterLimit; excess > 0 {
+		wm.deadLetters = append([]WebhookDeadLetter(nil), wm.deadLetters[excess:]...)
+	}
+	wm.saveDeadLetters()
+}
+
+// DeadLetters devuelve las entregas fallidas de un webhook (o de todos), las más recientes primero
+func (wm *WebhookManager) DeadLetters(id string, limit int) []WebhookDeadLetter {
+	wm.deadMutex.Lock()
+	defer wm.deadMutex.Unlock()
+	letters := []WebhookDeadLetter{}
+	for i := len(wm.deadLetters) - 1; i >= 0; i-- {
+		if id != "" && wm.deadLetters[i].Webhook != id {
+			continue
+		}
+		letters = append(letters, wm.deadLetters[i])
+		if limit > 0 && len(letters) >= limit {
+			break
+		}
+	}
+	return letters
+}
+
+// takeDeadLetters quita y devuelve las entregas fallidas de un webhook, las más viejas primero
+func (wm *WebhookManager) takeDeadLetters(id string) []WebhookDeadLetter {
+	wm.deadMutex.Lock()
+	defer wm.deadMutex.Unlock()
+	taken := []WebhookDeadLetter{}
+	kept := wm.deadLetters[:0]
+	for _, letter := range wm.deadLetters {
+		if letter.Webhook == id {
+			taken = append(taken, letter)
+		} else {
+			kept = append(kept, letter)
+		}
+	}
+	wm.deadLetters = kept
+	if len(taken) > 0 {
+		wm.saveDeadLetters()
+	}
+	return taken
+}
+
+// Replay vuelve a entregar las dead letters de un webhook activo, con el
+// mismo ID de entrega. Las que vuelvan a fallar quedan otra vez como dead letters.
+func (wm *WebhookManager) Replay(id string) (int, error) {
+	wm.mutex.RLock()
+	endpoint, active := wm.endpoints[id]
+	_, exists := wm.hooks[id]
+	wm.mutex.RUnlock()
+	if !exists {
+		return 0, errors.New("webhook no encontrado: " + id)
+	}
+	if !active {
+		return 0, errors.New("el webhook está desactivado: " + id)
+	}
+
+	letters := wm.takeDeadLetters(id)
+	go func() {
+		for _, letter := range letters {
+			endpoint.deliver(letter.Event, letter.Delivery)
+		}
+	}()
+	return len(letters), nil
+}
+
+// save persiste los webhooks; se llama con el lock tomado
+func (wm *WebhookManager) save() {
+	if err := writeJSONFile(wm.path, wm.hooks); err != nil {
+		componentLog("webhooks").Error("could not save file", "path", wm.path, "error", err)
+	}
+}
+
+// saveDeadLetters persiste las dead letters; se llama con deadMutex tomado
+func (wm *WebhookManager) saveDeadLetters() {
+	if err := writeJSONFile(wm.deadPath, wm.deadLetters); err != nil {
+		componentLog("webhooks").Error("could not save file", "path", wm.deadPath, "error", err)
+	}
+}
+
+// webhookRequest es el cuerpo de las acciones sobre un webhook
+type webhookRequest struct {
+	ID string `json:"id"`
+}
+
+// handleWebhooks administra los webhooks salientes:
+// GET los lista con sus métricas, POST registra uno y DELETE ?id=... lo borra.
+// La respuesta de POST es la única que incluye el secreto.
+func (s *Server) handleWebhooks(w http.ResponseWriter, r *http.Request) {
+	moderator := r.Header.Get("X-Moderator")
+	if moderator == "" {
+		moderator = "admin"
+	}
+
+	switch r.Method {
+	case "GET":
+		writeJSON(w, s.webhooks.List())
+	case "POST":
+		var hook Webhook
+		if err := json.NewDecoder(r.Body).Decode(&hook); err != nil {
+			http.Error(w, "JSON inválido: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		hook.CreatedBy = moderator
+		hook, err := s.webhooks.Register(hook)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		s.audit.Record(AuditEntry{
+			Kind:   AuditModeratorAction,
+			Actor:  moderator,
+			Action: "register_webhook",
+			Target: hook.ID,
+			Data: map[string]interface{}{
+				"url":   hook.URL,
+				"types": hook.Types,
+				"rooms": hook.Rooms,
+			},
+		})
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(hook)
+	case "DELETE":
+		id := r.URL.Query().Get("id")
+		if !s.webhooks.Remove(id) {
+			http.Error(w, "Webhook no encontrado", http.StatusNotFound)
+			return
+		}
+		s.audit.Record(AuditEntry{
+			Kind:   AuditModeratorAction,
+			Actor:  moderator,
+			Action: "delete_webhook",
+			Target: id,
+		})
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "Método no permitido", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleWebhookAction atiende POST {"id": "..."} para test, disable y enable
+func (s *Server) handleWebhookAction(action string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			http.Error(w, "Método no permitido", http.StatusMethodNotAllowed)
+			return
+		}
+		var req webhookRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "JSON inválido: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		moderator := r.Header.Get("X-Moderator")
+		if moderator == "" {
+			moderator = "admin"
+		}
+
+		var result interface{}
+		var err error
+		switch action {
+		case "test":
+			result, err = s.webhooks.Test(req.ID)
+		case "disable":
+			result, err = s.webhooks.SetDisabled(req.ID, true)
+		case "enable":
+			result, err = s.webhooks.SetDisabled(req.ID, false)
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		if action != "test" {
+			s.audit.Record(AuditEntry{
+				Kind:   AuditModeratorAction,
+				Actor:  moderator,
+				Action: action + "_webhook",
+				Target: req.ID,
+			})
+		}
+		writeJSON(w, result)
+	}
+}
+
+// handleWebhookDeadLetters lista las entregas fallidas (GET ?id=...&limit=...)
+// o las vuelve a entregar (POST {"id": "..."})
+func (s *Server) handleWebhookDeadLetters(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+		writeJSON(w, s.webhooks.DeadLetters(r.URL.Query().Get("id"), limit))
+	case "POST":
+		var req webhookRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "JSON inválido: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		replayed, err := s.webhooks.Replay(req.ID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		writeJSON(w, map[string]interface{}{"id": req.ID, "replayed": replayed})
+	default:
+		http.Error(w, "Método no permitido", http.StatusMethodNotAllowed)
+	}
+}
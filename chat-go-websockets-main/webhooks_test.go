@@ -0,0 +1,25 @@
+package main
+
+import "testing"
+
+func TestDeliveryIDIsStablePerOffset(t *testing.T) {
+	event := Event{Type: MessageEvent, Offset: 42}
+	first := deliveryID("wh_1", event)
+	if again := deliveryID("wh_1", event); again != first {
+		t.Fatalf("el mismo evento dio dos IDs: %s y %s", first, again)
+	}
+	for _, other := range []string{
+		deliveryID("wh_2", event),
+		deliveryID("wh_1", Event{Type: MessageEvent, Offset: 43}),
+		deliveryID("wh_1", Event{Type: MessageEvent, Offset: 42, Node: "nodo-b"}),
+	} {
+		if other == first {
+			t.Errorf("dos entregas distintas comparten el ID %s", first)
+		}
+	}
+
+	// Sin log de eventos no hay offset y cada entrega tiene su propio ID
+	if deliveryID("wh_1", Event{}) == deliveryID("wh_1", Event{}) {
+		t.Error("dos entregas sin offset comparten el ID")
+	}
+}